@@ -61,7 +61,7 @@ func TestChunkBlock(t *testing.T) {
 	if res && wallet.IsHdWalletInitialized() {
 		key := wallet.GetDefKey()
 
-		block, total := payouts.TransactionChunkBlock("Dd2KRkRceHtx7ep3qWHVAHEdjYoyPpAYx", to, "hello", value, key)
+		block, total := payouts.TransactionChunkBlock("Dd2KRkRceHtx7ep3qWHVAHEdjYoyPpAYx", to, "hello", value, 0, key)
 		fmt.Println(total)
 		fmt.Println(block)
 	}
@@ -85,7 +85,7 @@ func TestChunkRpc(t *testing.T) {
 		// fmt.Println(wallet.GetMnemonic())
 		key := wallet.GetDefKey()
 
-		hash, err := payouts.TransferChunkRpc(value, "Fii9BuhR1KogfNzWbtSH1YJgQQDwFMomK", to, "hello", key)
+		hash, err := payouts.TransferChunkRpc(value, 0, "Fii9BuhR1KogfNzWbtSH1YJgQQDwFMomK", to, "hello", key)
 		fmt.Println(hash)
 		fmt.Println(err)
 	}
@@ -118,7 +118,11 @@ func TestPayChunk(t *testing.T) {
 		payouts.BipKey = wallet.GetDefKey()
 		backend = kvstore.NewKvClient(&cfg.KvRocks, cfg.Coin)
 
-		payouts.PayChunk(backend, &to, &value, "test pay")
+		var batch []kvstore.MinerPayout
+		for i := range to {
+			batch = append(batch, kvstore.MinerPayout{Login: to[i], Address: to[i], Unpaid: pool.Amount(value[i])})
+		}
+		payouts.PayChunk(backend, &batch, "test pay", payouts.FeePolicy{})
 
 	}
 
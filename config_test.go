@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+func TestDecryptPoolConfigureOfflineSigning(t *testing.T) {
+	pass := []byte("12345678")
+	address := "LW2PGwYk4eovUttAn64ApS6nQ29yKVBhU"
+	if !util.ValidateAddress(address) {
+		t.Fatal("invalid test address")
+	}
+	encrypted, err := util.Ae64Encode([]byte(address), pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no wallet password on a host signing offline
+	c := &pool.Config{AddressEncrypted: encrypted}
+	c.KvRocks.Engine = kvstore.EngineBolt
+	c.PayOut.SigningDir = t.TempDir()
+	if err := decryptPoolConfigure(c, pass); err != nil {
+		t.Fatal(err)
+	}
+	if c.Address != address || c.WalletPswd != "" {
+		t.Fatalf("unexpected config %s %q", c.Address, c.WalletPswd)
+	}
+
+	// wallet password is required otherwise, empty one is an error instead of a panic
+	c = &pool.Config{AddressEncrypted: encrypted}
+	c.KvRocks.Engine = kvstore.EngineBolt
+	if err := decryptPoolConfigure(c, pass); err == nil {
+		t.Fatal("missing wallet password accepted")
+	}
+
+	walletEncrypted, _ := util.Ae64Encode([]byte("wallet"), pass)
+	c = &pool.Config{AddressEncrypted: encrypted, WalletEncrypted: walletEncrypted}
+	c.KvRocks.Engine = kvstore.EngineBolt
+	if err := decryptPoolConfigure(c, pass); err != nil || c.WalletPswd != "wallet" {
+		t.Fatalf("wallet password %q %v", c.WalletPswd, err)
+	}
+}
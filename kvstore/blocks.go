@@ -0,0 +1,240 @@
+package kvstore
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/redis/go-redis/v9"
+)
+
+// status of a job the pool submitted its min share for
+const (
+	BlockCandidate = "candidate" // min share submitted, waiting for reward message
+	BlockWin       = "win"       // pool share got the reward
+	BlockLost      = "lost"      // reward went to another share
+)
+
+// block registry: zset "blocks" of job hashes scored by found time, hash "block:<jobHash>" of each job,
+// set "blocks:open" of candidates waiting for reward message
+type BlockData struct {
+	JobHash   string      `json:"jobHash"`
+	Status    string      `json:"status"`
+	Finder    string      `json:"finder"` // miner of pool min share
+	Share     string      `json:"share"`
+	FoundAt   int64       `json:"foundAt"`
+	Diff      int64       `json:"diff"`   // pool diff of the job
+	Winner    string      `json:"winner"` // address of rewarded share
+	Reward    pool.Amount `json:"reward"`
+	Fee       pool.Amount `json:"fee"`
+	TxBlock   string      `json:"txBlock"`
+	UpdatedAt int64       `json:"updatedAt"`
+}
+
+func parseBlock(jobHash string, m map[string]string) BlockData {
+	b := BlockData{
+		JobHash: jobHash,
+		Status:  m["status"],
+		Finder:  m["finder"],
+		Share:   m["share"],
+		Winner:  m["winner"],
+		TxBlock: m["txBlock"],
+	}
+	b.FoundAt, _ = strconv.ParseInt(m["foundAt"], 10, 64)
+	b.Diff, _ = strconv.ParseInt(m["diff"], 10, 64)
+	b.UpdatedAt, _ = strconv.ParseInt(m["updatedAt"], 10, 64)
+	b.Reward, _ = pool.ParseAmount(m["reward"])
+	b.Fee, _ = pool.ParseAmount(m["fee"])
+	return b
+}
+
+// hash fields of a resolved block
+func resultFields(status, login string, reward pool.XdagjReward, diff, ms int64) []interface{} {
+	return []interface{}{
+		"status", status,
+		"winner", login,
+		"reward", reward.Amount.String(),
+		"fee", reward.Fee.String(),
+		"txBlock", reward.TxBlock,
+		"diff", diff,
+		"updatedAt", ms,
+	}
+}
+
+// luck of blocks found within window
+type LuckStats struct {
+	Window     string  `json:"window"`
+	Candidates int64   `json:"candidates"` // waiting for result
+	Wins       int64   `json:"wins"`
+	Lost       int64   `json:"lost"`
+	Luck       float64 `json:"luck"`   // wins per resolved block
+	Effort     float64 `json:"effort"` // resolved blocks per win
+	Diff       int64   `json:"diff"`   // pool diff of resolved blocks
+}
+
+func NewLuckStats(blocks []BlockData, window time.Duration) *LuckStats {
+	l := &LuckStats{Window: window.String()}
+	for _, b := range blocks {
+		switch b.Status {
+		case BlockCandidate:
+			l.Candidates++
+			continue
+		case BlockWin:
+			l.Wins++
+		case BlockLost:
+			l.Lost++
+		}
+		l.Diff += b.Diff
+	}
+	if resolved := l.Wins + l.Lost; resolved > 0 {
+		l.Luck = float64(l.Wins) / float64(resolved)
+		if l.Wins > 0 {
+			l.Effort = float64(resolved) / float64(l.Wins)
+		}
+	}
+	return l
+}
+
+// record min share of a job sent to node, a lower share of the job replaces the finder.
+// a block already resolved by a reward message keeps its status
+func (r *KvClient) WriteCandidate(jobHash, login, share string, ms, ts int64) error {
+	tx := r.client.TxPipeline()
+	tx.HSetNX(ctx, r.formatKey("block", jobHash), "status", BlockCandidate)
+	tx.HSet(ctx, r.formatKey("block", jobHash),
+		"finder", login,
+		"share", share,
+		"foundAt", ms,
+		"updatedAt", ms)
+	tx.ZAddNX(ctx, r.formatKey("blocks"), redis.Z{Score: float64(ts), Member: jobHash})
+	tx.SAdd(ctx, r.formatKey("blocks", "open"), jobHash)
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+// mark candidate of a job rewarded to another share as lost
+func (r *KvClient) SetLostReward(login string, reward pool.XdagjReward, ms, ts int64) error {
+	status, err := r.client.HGet(ctx, r.formatKey("block", reward.PreHash), "status").Result()
+	if err == redis.Nil {
+		return nil // not a candidate of pool
+	}
+	if err != nil || status != BlockCandidate {
+		return err
+	}
+	diff, _ := r.client.HGet(ctx, r.formatKey("pool", reward.PreHash), "diff").Int64()
+	tx := r.client.TxPipeline()
+	tx.HSet(ctx, r.formatKey("block", reward.PreHash), resultFields(BlockLost, login, reward, diff, ms)...)
+	tx.SRem(ctx, r.formatKey("blocks", "open"), reward.PreHash)
+	_, err = tx.Exec(ctx)
+	return err
+}
+
+// count a task round for every open candidate, candidates without reward message after rounds
+// are resolved as lost. returns number of candidates closed
+func (r *KvClient) ExpireCandidates(rounds, ms int64) (int64, error) {
+	hashes, err := r.client.SMembers(ctx, r.formatKey("blocks", "open")).Result()
+	if err != nil || len(hashes) == 0 {
+		return 0, err
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(hashes))
+	for i, h := range hashes {
+		cmds[i] = pipe.HIncrBy(ctx, r.formatKey("block", h), "rounds", 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var n int64
+	for i, cmd := range cmds {
+		if cmd.Val() < rounds {
+			continue
+		}
+		if err := r.expireCandidate(hashes[i], ms); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// resolve a candidate as lost unless a reward message resolved it meanwhile
+func (r *KvClient) expireCandidate(jobHash string, ms int64) error {
+	key := r.formatKey("block", jobHash)
+	return r.client.Watch(ctx, func(w *redis.Tx) error {
+		status, err := w.HGet(ctx, key, "status").Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		diff, _ := w.HGet(ctx, r.formatKey("pool", jobHash), "diff").Int64()
+		_, err = w.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+			if status == BlockCandidate {
+				tx.HSet(ctx, key, "status", BlockLost, "diff", diff, "updatedAt", ms)
+			}
+			tx.SRem(ctx, r.formatKey("blocks", "open"), jobHash)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// blocks newest first and count of blocks
+func (r *KvClient) GetBlocks(start, end int64) (int64, []BlockData, error) {
+	count, err := r.client.ZCard(ctx, r.formatKey("blocks")).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	hashes, err := r.client.ZRevRange(ctx, r.formatKey("blocks"), start, end).Result()
+	if err != nil {
+		return count, nil, err
+	}
+	list, err := r.blocks(hashes)
+	return count, list, err
+}
+
+// blocks found since ts in seconds, oldest first
+func (r *KvClient) BlocksSince(ts int64) ([]BlockData, error) {
+	hashes, err := r.client.ZRangeByScore(ctx, r.formatKey("blocks"),
+		&redis.ZRangeBy{Min: strconv.FormatInt(ts, 10), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.blocks(hashes)
+}
+
+func (r *KvClient) blocks(hashes []string) ([]BlockData, error) {
+	list := []BlockData{}
+	if len(hashes) == 0 {
+		return list, nil
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(hashes))
+	for i, h := range hashes {
+		cmds[i] = pipe.HGetAll(ctx, r.formatKey("block", h))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		list = append(list, parseBlock(hashes[i], cmd.Val()))
+	}
+	return list, nil
+}
+
+// delete blocks found before max seconds
+func (r *KvClient) purgeBlocks(max string) (int64, error) {
+	hashes, err := r.client.ZRangeByScore(ctx, r.formatKey("blocks"),
+		&redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil || len(hashes) == 0 {
+		return 0, err
+	}
+	tx := r.client.TxPipeline()
+	for _, h := range hashes {
+		tx.Del(ctx, r.formatKey("block", h))
+		tx.SRem(ctx, r.formatKey("blocks", "open"), h)
+	}
+	tx.ZRemRangeByScore(ctx, r.formatKey("blocks"), "-inf", max)
+	_, err = tx.Exec(ctx)
+	return int64(len(hashes)), err
+}
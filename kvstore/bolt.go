@@ -0,0 +1,553 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/XDagger/xdagpool/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+// embedded store in a bbolt file, keys and members are the same as in kvrocks.
+// every key is a nested bucket under the bucket of its type, a sorted set keeps
+// member -> score and an index of score + member in rank order.
+type BoltStore struct {
+	db          *bolt.DB
+	prefix      string
+	pplnsShares int64 // length of pplns share window
+}
+
+var (
+	bucketHash   = []byte("hash")
+	bucketZset   = []byte("zset")
+	bucketSet    = []byte("set")
+	bucketList   = []byte("list")
+	bucketExpire = []byte("expire") // key -> deadline in ms
+
+	zsetScores = []byte("m") // member -> score
+	zsetIndex  = []byte("s") // score + member -> nil
+
+	errNotFound = errors.New("kvstore: not found")
+)
+
+func NewBoltStore(path, prefix string) (*BoltStore, error) {
+	if path == "" {
+		return nil, errors.New("bolt store path is empty")
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketHash, bucketZset, bucketSet, bucketList, bucketExpire} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db, prefix: prefix}, nil
+}
+
+func (b *BoltStore) Check() (string, error) {
+	return "PONG", b.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketHash) == nil {
+			return errors.New("bolt store is not initialized")
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStore) formatKey(args ...interface{}) string {
+	return join(b.prefix, join(args...))
+}
+
+func (b *BoltStore) view(fn func(t *boltTx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx, now: util.MakeTimestamp()})
+	})
+}
+
+func (b *BoltStore) update(fn func(t *boltTx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx, now: util.MakeTimestamp()})
+	})
+}
+
+// redis like commands in a bolt transaction
+type boltTx struct {
+	tx  *bolt.Tx
+	now int64
+}
+
+func (t *boltTx) expired(key string) bool {
+	v := t.tx.Bucket(bucketExpire).Get([]byte(key))
+	return v != nil && int64(binary.BigEndian.Uint64(v)) <= t.now
+}
+
+// bucket of key, nil if it does not exist or is expired
+func (t *boltTx) bucket(kind []byte, key string) *bolt.Bucket {
+	if t.expired(key) {
+		return nil
+	}
+	return t.tx.Bucket(kind).Bucket([]byte(key))
+}
+
+func (t *boltTx) createBucket(kind []byte, key string) (*bolt.Bucket, error) {
+	if t.expired(key) {
+		if err := t.del(key); err != nil {
+			return nil, err
+		}
+	}
+	return t.tx.Bucket(kind).CreateBucketIfNotExists([]byte(key))
+}
+
+func (t *boltTx) del(key string) error {
+	for _, kind := range [][]byte{bucketHash, bucketZset, bucketSet, bucketList} {
+		err := t.tx.Bucket(kind).DeleteBucket([]byte(key))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	return t.tx.Bucket(bucketExpire).Delete([]byte(key))
+}
+
+func (t *boltTx) expire(key string, d time.Duration) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(t.now+d.Milliseconds()))
+	return t.tx.Bucket(bucketExpire).Put([]byte(key), v[:])
+}
+
+// delete expired keys
+func (t *boltTx) purgeExpired() (int64, error) {
+	var keys []string
+	c := t.tx.Bucket(bucketExpire).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if int64(binary.BigEndian.Uint64(v)) <= t.now {
+			keys = append(keys, string(k))
+		}
+	}
+	for _, k := range keys {
+		if err := t.del(k); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(keys)), nil
+}
+
+// keys of type with prefix
+func (t *boltTx) scan(kind []byte, prefix string) []string {
+	var keys []string
+	c := t.tx.Bucket(kind).Cursor()
+	p := []byte(prefix)
+	for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+		if !t.expired(string(k)) {
+			keys = append(keys, string(k))
+		}
+	}
+	return keys
+}
+
+// hash
+
+func (t *boltTx) hGet(key, field string) (string, error) {
+	b := t.bucket(bucketHash, key)
+	if b == nil {
+		return "", errNotFound
+	}
+	v := b.Get([]byte(field))
+	if v == nil {
+		return "", errNotFound
+	}
+	return string(v), nil
+}
+
+func (t *boltTx) hGetInt64(key, field string) (int64, error) {
+	v, err := t.hGet(key, field)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// values of fields like redis HMGet, nil for missing ones
+func (t *boltTx) hmGet(key string, fields ...string) []interface{} {
+	vals := make([]interface{}, len(fields))
+	for i, f := range fields {
+		if v, err := t.hGet(key, f); err == nil {
+			vals[i] = v
+		}
+	}
+	return vals
+}
+
+func (t *boltTx) hGetAll(key string) map[string]string {
+	m := make(map[string]string)
+	b := t.bucket(bucketHash, key)
+	if b == nil {
+		return m
+	}
+	_ = b.ForEach(func(k, v []byte) error {
+		m[string(k)] = string(v)
+		return nil
+	})
+	return m
+}
+
+// set field value pairs, values are converted like join
+func (t *boltTx) hSet(key string, pairs ...interface{}) error {
+	b, err := t.createBucket(bucketHash, key)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		field, _ := pairs[i].(string)
+		var value string
+		switch v := pairs[i+1].(type) {
+		case int:
+			value = strconv.Itoa(v)
+		default:
+			value = join(v)
+		}
+		if err := b.Put([]byte(field), []byte(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *boltTx) hIncrBy(key, field string, n int64) (int64, error) {
+	b, err := t.createBucket(bucketHash, key)
+	if err != nil {
+		return 0, err
+	}
+	var v int64
+	if old := b.Get([]byte(field)); old != nil {
+		v, err = strconv.ParseInt(string(old), 10, 64)
+		if err != nil {
+			return 0, errors.New("hash value is not an integer")
+		}
+	}
+	v += n
+	return v, b.Put([]byte(field), []byte(strconv.FormatInt(v, 10)))
+}
+
+func (t *boltTx) hDel(key string, fields ...string) (int64, error) {
+	b := t.bucket(bucketHash, key)
+	if b == nil {
+		return 0, nil
+	}
+	var n int64
+	for _, f := range fields {
+		if b.Get([]byte(f)) == nil {
+			continue
+		}
+		if err := b.Delete([]byte(f)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// increase field value pairs
+func (t *boltTx) hIncrByAll(key string, pairs ...interface{}) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if _, err := t.hIncrBy(key, pairs[i].(string), pairs[i+1].(int64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set
+
+func (t *boltTx) sAdd(key, member string) error {
+	b, err := t.createBucket(bucketSet, key)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(member), nil)
+}
+
+func (t *boltTx) sRem(key, member string) error {
+	b := t.bucket(bucketSet, key)
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(member))
+}
+
+func (t *boltTx) sIsMember(key, member string) bool {
+	b := t.bucket(bucketSet, key)
+	return b != nil && b.Get([]byte(member)) != nil
+}
+
+func (t *boltTx) sMembers(key string) []string {
+	var members []string
+	b := t.bucket(bucketSet, key)
+	if b == nil {
+		return members
+	}
+	_ = b.ForEach(func(k, _ []byte) error {
+		members = append(members, string(k))
+		return nil
+	})
+	return members
+}
+
+// list, pushed on the left
+
+func (t *boltTx) lPush(key, value string) error {
+	b, err := t.createBucket(bucketList, key)
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], math.MaxUint64-seq) // newest first
+	return b.Put(k[:], []byte(value))
+}
+
+// first n values
+func (t *boltTx) lRange(key string, n int64) []string {
+	var vals []string
+	b := t.bucket(bucketList, key)
+	if b == nil {
+		return vals
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil && int64(len(vals)) < n; k, v = c.Next() {
+		vals = append(vals, string(v))
+	}
+	return vals
+}
+
+// keep first n values
+func (t *boltTx) lTrim(key string, n int64) error {
+	b := t.bucket(bucketList, key)
+	if b == nil {
+		return nil
+	}
+	var drop [][]byte
+	c := b.Cursor()
+	var i int64
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if i >= n {
+			drop = append(drop, append([]byte(nil), k...))
+		}
+		i++
+	}
+	for _, k := range drop {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sorted set
+
+// score bytes sorting like float numbers
+func scoreKey(score float64, member string) []byte {
+	bits := math.Float64bits(score)
+	if score < 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	k := make([]byte, 8, 8+len(member))
+	binary.BigEndian.PutUint64(k, bits)
+	return append(k, member...)
+}
+
+func keyScore(k []byte) (float64, string) {
+	bits := binary.BigEndian.Uint64(k[:8])
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), string(k[8:])
+}
+
+func (t *boltTx) zBuckets(key string) (scores, index *bolt.Bucket) {
+	b := t.bucket(bucketZset, key)
+	if b == nil {
+		return nil, nil
+	}
+	return b.Bucket(zsetScores), b.Bucket(zsetIndex)
+}
+
+func (t *boltTx) zAdd(key string, score float64, member string) error {
+	b, err := t.createBucket(bucketZset, key)
+	if err != nil {
+		return err
+	}
+	scores, err := b.CreateBucketIfNotExists(zsetScores)
+	if err != nil {
+		return err
+	}
+	index, err := b.CreateBucketIfNotExists(zsetIndex)
+	if err != nil {
+		return err
+	}
+	if old := scores.Get([]byte(member)); old != nil {
+		if err := index.Delete(scoreKey(math.Float64frombits(binary.BigEndian.Uint64(old)), member)); err != nil {
+			return err
+		}
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], math.Float64bits(score))
+	if err := scores.Put([]byte(member), v[:]); err != nil {
+		return err
+	}
+	return index.Put(scoreKey(score, member), nil)
+}
+
+func (t *boltTx) zRem(key, member string) error {
+	scores, index := t.zBuckets(key)
+	if scores == nil {
+		return nil
+	}
+	old := scores.Get([]byte(member))
+	if old == nil {
+		return nil
+	}
+	if err := index.Delete(scoreKey(math.Float64frombits(binary.BigEndian.Uint64(old)), member)); err != nil {
+		return err
+	}
+	return scores.Delete([]byte(member))
+}
+
+func (t *boltTx) zCard(key string) int64 {
+	scores, _ := t.zBuckets(key)
+	if scores == nil {
+		return 0
+	}
+	return int64(scores.Stats().KeyN)
+}
+
+type zMember struct {
+	score  float64
+	member string
+}
+
+// members by rank from start to stop inclusive, negative ranks count from the end
+func (t *boltTx) zRange(key string, start, stop int64, rev bool) []zMember {
+	var res []zMember
+	_, index := t.zBuckets(key)
+	if index == nil {
+		return res
+	}
+	n := int64(index.Stats().KeyN)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return res
+	}
+	c := index.Cursor()
+	var k []byte
+	if rev {
+		k, _ = c.Last()
+	} else {
+		k, _ = c.First()
+	}
+	for i := int64(0); k != nil && i <= stop; i++ {
+		if i >= start {
+			score, member := keyScore(k)
+			res = append(res, zMember{score, member})
+		}
+		if rev {
+			k, _ = c.Prev()
+		} else {
+			k, _ = c.Next()
+		}
+	}
+	return res
+}
+
+func (t *boltTx) zRangeMembers(key string, start, stop int64, rev bool) []string {
+	var members []string
+	for _, z := range t.zRange(key, start, stop, rev) {
+		members = append(members, z.member)
+	}
+	return members
+}
+
+// members with score from min inclusive to max exclusive
+func (t *boltTx) zRangeByScore(key string, min, max float64) []zMember {
+	var res []zMember
+	_, index := t.zBuckets(key)
+	if index == nil {
+		return res
+	}
+	c := index.Cursor()
+	for k, _ := c.Seek(scoreKey(min, "")); k != nil; k, _ = c.Next() {
+		score, member := keyScore(k)
+		if score >= max {
+			break
+		}
+		res = append(res, zMember{score, member})
+	}
+	return res
+}
+
+func (t *boltTx) zScore(key, member string) (float64, bool) {
+	scores, _ := t.zBuckets(key)
+	if scores == nil {
+		return 0, false
+	}
+	v := scores.Get([]byte(member))
+	if v == nil {
+		return 0, false
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(v)), true
+}
+
+// remove members with score below max
+func (t *boltTx) zRemBelow(key string, max float64) (int64, error) {
+	members := t.zRangeByScore(key, math.Inf(-1), max)
+	for _, z := range members {
+		if err := t.zRem(key, z.member); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(members)), nil
+}
+
+// remove members by rank from start to stop inclusive
+func (t *boltTx) zRemRangeByRank(key string, start, stop int64) (int64, error) {
+	members := t.zRange(key, start, stop, false)
+	for _, z := range members {
+		if err := t.zRem(key, z.member); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(members)), nil
+}
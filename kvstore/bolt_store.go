@@ -0,0 +1,1099 @@
+package kvstore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+// pool storage on BoltStore, every write of KvClient transaction is one bolt transaction
+
+func (b *BoltStore) WriteInvalidShare(ms, ts int64, login, id string, diff int64) error {
+	return b.update(func(t *boltTx) error {
+		return t.zAdd(b.formatKey("invalidhashrate"), float64(ts), join(diff, login, id, ms))
+	})
+}
+
+func (b *BoltStore) WriteRejectShare(ms, ts int64, login, id string, diff int64) error {
+	return b.update(func(t *boltTx) error {
+		return t.zAdd(b.formatKey("rejecthashrate"), float64(ts), join(diff, login, id, ms))
+	})
+}
+
+func (b *BoltStore) WriteBlock(login, id, share string, diff int64, shareU64 uint64,
+	timestamp uint64, jobHash string) (bool, error) {
+	if util.MinedShares.ShareExist(share) {
+		return true, nil
+	}
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+	err := b.update(func(t *boltTx) error {
+		for _, key := range []string{b.formatKey("workers", login+"."+id), b.formatKey("miners", login), b.formatKey("stats")} {
+			if err := t.hSet(key, "lastShare", strconv.FormatInt(ts, 10)); err != nil {
+				return err
+			}
+		}
+		if _, err := t.hIncrBy(b.formatKey("pool", jobHash), "diff", diff); err != nil {
+			return err
+		}
+		if _, err := t.hIncrBy(b.formatKey("job", jobHash), login, diff); err != nil {
+			return err
+		}
+		if err := t.expire(b.formatKey("job", jobHash), expireDuration); err != nil {
+			return err
+		}
+		if err := t.expire(b.formatKey("pool", jobHash), expireDuration); err != nil {
+			return err
+		}
+		if b.pplnsShares <= 0 {
+			return nil
+		}
+		if err := t.lPush(b.formatKey("pplns"), join(diff, login, ms)); err != nil {
+			return err
+		}
+		return t.lTrim(b.formatKey("pplns"), b.pplnsShares)
+	})
+	return false, err
+}
+
+// check and store min share of a job in one transaction, same steps as minShareScript
+func (b *BoltStore) IsMinShare(jobHash, login, share string, shareU64 uint64) bool {
+	var min bool
+	err := b.update(func(t *boltTx) error {
+		key := b.formatKey("mini", jobHash)
+		z := t.zRange(key, 0, 0, false)
+		if len(z) > 0 && uint64(z[0].score) <= shareU64 {
+			return nil
+		}
+		min = true
+		if err := t.sAdd(b.formatKey("submit", jobHash), share); err != nil {
+			return err
+		}
+		if err := t.zAdd(key, float64(shareU64), login); err != nil {
+			return err
+		}
+		if len(z) == 0 {
+			if err := t.expire(b.formatKey("submit", jobHash), expireDuration); err != nil {
+				return err
+			}
+			if err := t.expire(key, expireDuration); err != nil {
+				return err
+			}
+		}
+		_, err := t.zRemRangeByRank(key, 1, -1)
+		return err
+	})
+	if err != nil {
+		util.Error.Println("store submitted min share error", err)
+		return false
+	}
+	return min
+}
+
+func (b *BoltStore) IsPoolShare(jobHash, share string) bool {
+	var ok bool
+	_ = b.view(func(t *boltTx) error {
+		ok = t.sIsMember(b.formatKey("submit", jobHash), share)
+		return nil
+	})
+	return ok
+}
+
+func (b *BoltStore) SetPplnsShares(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	b.pplnsShares = n
+}
+
+func (b *BoltStore) PurgeRecords(window time.Duration) (int64, error) {
+	max := float64(util.MakeTimestamp()/1000 - int64(window/time.Second))
+	var total int64
+	err := b.update(func(t *boltTx) error {
+		keys := []string{
+			b.formatKey("pool", "donate"),
+			b.formatKey("pool", "rewards"),
+			b.formatKey("rejecthashrate"),
+			b.formatKey("invalidhashrate"),
+		}
+		for _, kind := range []string{"balance", "rewards", "payment"} {
+			keys = append(keys, t.scan(bucketZset, b.formatKey(kind, ""))...)
+		}
+		for _, key := range keys {
+			n, err := t.zRemBelow(key, max)
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+		n, err := b.purgeBlocks(t, max)
+		if err != nil {
+			return err
+		}
+		total += n
+		n, err = t.purgeExpired()
+		total += n
+		return err
+	})
+	return total, err
+}
+
+func (b *BoltStore) SetMinerReward(login, txHash, jobHash string, reward pool.Amount, ms, ts int64) error {
+	return b.update(func(t *boltTx) error {
+		return b.setMinerReward(t, login, txHash, jobHash, reward, ms, ts)
+	})
+}
+
+// change unpaid of miner account and keep unpaid index in step
+func (b *BoltStore) incrUnpaid(t *boltTx, login string, n int64) error {
+	unpaid, err := t.hIncrBy(b.formatKey("account", login), "unpaid", n)
+	if err != nil {
+		return err
+	}
+	if unpaid <= 0 {
+		return t.zRem(b.formatKey("unpaid"), login)
+	}
+	return t.zAdd(b.formatKey("unpaid"), float64(unpaid), login)
+}
+
+func (b *BoltStore) setMinerReward(t *boltTx, login, txHash, jobHash string, reward pool.Amount, ms, ts int64) error {
+	if _, err := t.hIncrBy(b.formatKey("account", login), "reward", int64(reward)); err != nil {
+		return err
+	}
+	if err := b.incrUnpaid(t, login, int64(reward)); err != nil {
+		return err
+	}
+	if err := t.zAdd(b.formatKey("rewards", jobHash), float64(ts), join(reward, ms, txHash, login)); err != nil {
+		return err
+	}
+	if err := t.zAdd(b.formatKey("rewards", login), float64(ts), join(reward, ms, txHash, jobHash)); err != nil {
+		return err
+	}
+	return t.zAdd(b.formatKey("balance", login), float64(ts), join("reward", reward, ms, txHash, jobHash))
+}
+
+func (b *BoltStore) SetWinReward(login string, reward pool.XdagjReward, ms, ts int64) error {
+	return b.update(func(t *boltTx) error {
+		account := b.formatKey("pool", "account")
+		if _, err := t.hIncrBy(account, "rewards", int64(reward.Amount)); err != nil {
+			return err
+		}
+		if _, err := t.hIncrBy(account, "unpaid", int64(reward.Amount)); err != nil {
+			return err
+		}
+		return b.winBlock(t, login, reward, ms, ts)
+	})
+}
+
+// record won block, donation and its rewards entry in t
+func (b *BoltStore) winBlock(t *boltTx, login string, reward pool.XdagjReward, ms, ts int64) error {
+	if _, err := t.hIncrBy(b.formatKey("pool", "account"), "donate", int64(reward.Donate)); err != nil {
+		return err
+	}
+	if err := t.zAdd(b.formatKey("pool", "rewards"), float64(ts),
+		join(reward.Amount, reward.Fee, ms, reward.TxBlock, reward.PreHash, login, reward.Share)); err != nil {
+		return err
+	}
+	if err := t.zAdd(b.formatKey("pool", "donate"), float64(ts),
+		join(reward.Donate, ms, reward.PreHash, reward.DonateBlock)); err != nil {
+		return err
+	}
+	if err := t.del(b.formatKey("submit", reward.PreHash)); err != nil {
+		return err
+	}
+	return b.resolveBlock(t, BlockWin, login, reward, ms, ts)
+}
+
+func (b *BoltStore) resolveBlock(t *boltTx, status, login string, reward pool.XdagjReward, ms, ts int64) error {
+	diff, _ := t.hGetInt64(b.formatKey("pool", reward.PreHash), "diff")
+	if err := t.hSet(b.formatKey("block", reward.PreHash), resultFields(status, login, reward, diff, ms)...); err != nil {
+		return err
+	}
+	if err := t.sRem(b.formatKey("blocks", "open"), reward.PreHash); err != nil {
+		return err
+	}
+	if _, ok := t.zScore(b.formatKey("blocks"), reward.PreHash); ok {
+		return nil
+	}
+	return t.zAdd(b.formatKey("blocks"), float64(ts), reward.PreHash)
+}
+
+func (b *BoltStore) WriteCandidate(jobHash, login, share string, ms, ts int64) error {
+	return b.update(func(t *boltTx) error {
+		if _, err := t.hGet(b.formatKey("block", jobHash), "status"); err == errNotFound {
+			if err := t.hSet(b.formatKey("block", jobHash), "status", BlockCandidate); err != nil {
+				return err
+			}
+		}
+		err := t.hSet(b.formatKey("block", jobHash),
+			"finder", login,
+			"share", share,
+			"foundAt", ms,
+			"updatedAt", ms)
+		if err != nil {
+			return err
+		}
+		if err := t.sAdd(b.formatKey("blocks", "open"), jobHash); err != nil {
+			return err
+		}
+		if _, ok := t.zScore(b.formatKey("blocks"), jobHash); ok {
+			return nil
+		}
+		return t.zAdd(b.formatKey("blocks"), float64(ts), jobHash)
+	})
+}
+
+func (b *BoltStore) SetLostReward(login string, reward pool.XdagjReward, ms, ts int64) error {
+	return b.update(func(t *boltTx) error {
+		status, _ := t.hGet(b.formatKey("block", reward.PreHash), "status")
+		if status != BlockCandidate {
+			return nil // not a candidate of pool or already resolved
+		}
+		return b.resolveBlock(t, BlockLost, login, reward, ms, ts)
+	})
+}
+
+func (b *BoltStore) ExpireCandidates(rounds, ms int64) (int64, error) {
+	var n int64
+	err := b.update(func(t *boltTx) error {
+		for _, h := range t.sMembers(b.formatKey("blocks", "open")) {
+			key := b.formatKey("block", h)
+			v, err := t.hIncrBy(key, "rounds", 1)
+			if err != nil {
+				return err
+			}
+			if v < rounds {
+				continue
+			}
+			if status, _ := t.hGet(key, "status"); status == BlockCandidate {
+				diff, _ := t.hGetInt64(b.formatKey("pool", h), "diff")
+				if err := t.hSet(key, "status", BlockLost, "diff", diff, "updatedAt", ms); err != nil {
+					return err
+				}
+			}
+			if err := t.sRem(b.formatKey("blocks", "open"), h); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (b *BoltStore) GetBlocks(start, end int64) (int64, []BlockData, error) {
+	var count int64
+	var list []BlockData
+	err := b.view(func(t *boltTx) error {
+		count = t.zCard(b.formatKey("blocks"))
+		list = b.blocks(t, t.zRange(b.formatKey("blocks"), start, end, true))
+		return nil
+	})
+	return count, list, err
+}
+
+func (b *BoltStore) BlocksSince(ts int64) ([]BlockData, error) {
+	var list []BlockData
+	err := b.view(func(t *boltTx) error {
+		list = b.blocks(t, t.zRangeByScore(b.formatKey("blocks"), float64(ts), math.Inf(1)))
+		return nil
+	})
+	return list, err
+}
+
+func (b *BoltStore) blocks(t *boltTx, hashes []zMember) []BlockData {
+	list := []BlockData{}
+	for _, z := range hashes {
+		m := t.hGetAll(b.formatKey("block", z.member))
+		if len(m) == 0 {
+			continue
+		}
+		list = append(list, parseBlock(z.member, m))
+	}
+	return list
+}
+
+// delete blocks found before max seconds
+func (b *BoltStore) purgeBlocks(t *boltTx, max float64) (int64, error) {
+	hashes := t.zRangeByScore(b.formatKey("blocks"), math.Inf(-1), max)
+	for _, z := range hashes {
+		if err := t.del(b.formatKey("block", z.member)); err != nil {
+			return 0, err
+		}
+		if err := t.zRem(b.formatKey("blocks"), z.member); err != nil {
+			return 0, err
+		}
+		if err := t.sRem(b.formatKey("blocks", "open"), z.member); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(hashes)), nil
+}
+
+func (b *BoltStore) WriteShareStats(login, id, kind string, diff, ts int64) error {
+	return b.update(func(t *boltTx) error {
+		for _, res := range Resolutions {
+			field := seriesField(res.bucket(ts), kind)
+			for _, series := range []string{SeriesPool, MinerSeries(login), WorkerSeries(login, id)} {
+				key := b.formatKey("series", res.Name, series)
+				if _, err := t.hIncrBy(key, field, diff); err != nil {
+					return err
+				}
+				if err := t.expire(key, res.Retention); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) ShareSeries(series string, res Resolution) ([]HashratePoint, error) {
+	var fields map[string]string
+	err := b.view(func(t *boltTx) error {
+		fields = t.hGetAll(b.formatKey("series", res.Name, series))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buildSeries(fields, res, util.MakeTimestamp()/1000), nil
+}
+
+func (b *BoltStore) PurgeShareStats() (int64, error) {
+	now := util.MakeTimestamp() / 1000
+	var total int64
+	err := b.update(func(t *boltTx) error {
+		for _, res := range Resolutions {
+			for _, key := range t.scan(bucketHash, b.formatKey("series", res.Name, "")) {
+				fields := make([]string, 0)
+				for f := range t.hGetAll(key) {
+					fields = append(fields, f)
+				}
+				n, err := t.hDel(key, staleFields(fields, res, now)...)
+				if err != nil {
+					return err
+				}
+				total += n
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// set lowest hash finder reward of a job
+func (b *BoltStore) SetFinderReward(login string, reward pool.XdagjReward, fee pool.Amount, ms, ts int64) {
+	if fee <= 0 {
+		return
+	}
+	err := b.update(func(t *boltTx) error {
+		z := t.zRange(b.formatKey("mini", reward.PreHash), 0, 0, false)
+		if len(z) == 0 {
+			return errors.New("lowest hash finder not found")
+		}
+		return b.setMinerReward(t, z[0].member, reward.TxBlock, reward.PreHash, fee, ms, ts)
+	})
+	if err != nil {
+		util.Error.Println("store hash finder reward error", reward.PreHash, err)
+	}
+}
+
+// get all miners diff and pool diff of a job
+func (b *BoltStore) GetJobDiffs(jobHash string) (map[string]int64, int64) {
+	var miners map[string]int64
+	var poolDiff int64
+	_ = b.view(func(t *boltTx) error {
+		poolDiff, _ = t.hGetInt64(b.formatKey("pool", jobHash), "diff")
+		raw := t.hGetAll(b.formatKey("job", jobHash))
+		miners = make(map[string]int64, len(raw))
+		for address, v := range raw {
+			miners[address], _ = strconv.ParseInt(v, 10, 64)
+		}
+		return nil
+	})
+	return miners, poolDiff
+}
+
+func (b *BoltStore) DivideEqual(login string, reward pool.XdagjReward, fee, amount pool.Amount, ms, ts int64) {
+	diffs, _ := b.GetJobDiffs(reward.PreHash)
+	if len(diffs) == 0 {
+		util.Error.Println("equal direct reward miners count is 0", reward.PreHash)
+		return
+	}
+	for miner, part := range equalParts(diffs, fee, amount) {
+		err := b.SetMinerReward(miner, reward.TxBlock, reward.PreHash, part, ms, ts)
+		if err != nil {
+			util.Error.Println("store equal direct reward error", reward.PreHash, miner, part, err)
+			continue
+		}
+	}
+}
+
+func (b *BoltStore) GetPplnsProportion(n int64, window time.Duration) map[string]float64 {
+	return pplnsProportion(b.pplnsDiffs(n, window))
+}
+
+func (b *BoltStore) pplnsDiffs(n int64, window time.Duration) (map[string]int64, int64) {
+	if n <= 0 {
+		n = b.pplnsShares
+	}
+	var raw []string
+	_ = b.view(func(t *boltTx) error {
+		raw = t.lRange(b.formatKey("pplns"), n)
+		return nil
+	})
+	return sumPplnsDiffs(raw, window)
+}
+
+func (b *BoltStore) DividePplns(reward pool.XdagjReward, amount pool.Amount, n int64, window time.Duration, ms, ts int64) {
+	diffs, _ := b.pplnsDiffs(n, window)
+	if len(diffs) == 0 {
+		util.Error.Println("pplns reward miners count is 0", reward.PreHash)
+		return
+	}
+	for miner, part := range SplitAmount(amount, diffs) {
+		err := b.SetMinerReward(miner, reward.TxBlock, reward.PreHash, part, ms, ts)
+		if err != nil {
+			util.Error.Println("store pplns reward error", reward.PreHash, miner, part, err)
+			continue
+		}
+	}
+}
+
+// credit a pps share to miner unless pool buffer is negative, same steps as ppsCreditScript
+func (b *BoltStore) CreditPpsShare(login string, amount, reserve pool.Amount) (bool, error) {
+	var credited bool
+	err := b.update(func(t *boltTx) error {
+		vals := t.hmGet(b.formatKey("pps"), "rewards", "credited")
+		if reserve+pool.Amount(parseInt64(vals[0])-parseInt64(vals[1])) < 0 {
+			_, err := t.hIncrBy(b.formatKey("pps"), "skipped", 1)
+			return err
+		}
+		for _, field := range []string{"reward", "pps"} {
+			if _, err := t.hIncrBy(b.formatKey("account", login), field, int64(amount)); err != nil {
+				return err
+			}
+		}
+		if err := b.incrUnpaid(t, login, int64(amount)); err != nil {
+			return err
+		}
+		if _, err := t.hIncrBy(b.formatKey("pps"), "credited", int64(amount)); err != nil {
+			return err
+		}
+		if _, err := t.hIncrBy(b.formatKey("pps"), "shares", 1); err != nil {
+			return err
+		}
+		credited = true
+		return nil
+	})
+	return credited, err
+}
+
+// won block in pps mode funds pool buffer instead of pool account, fee is averaged for fpps
+func (b *BoltStore) SetPpsWinReward(login string, reward pool.XdagjReward, ms, ts int64) error {
+	return b.update(func(t *boltTx) error {
+		avgFee, err := pool.ParseAmount(parseString(t.hmGet(b.formatKey("pps"), "avgFee")[0]))
+		if err != nil {
+			avgFee = 0
+		}
+		if _, err := t.hIncrBy(b.formatKey("pps"), "rewards", int64(reward.Amount)); err != nil {
+			return err
+		}
+		if _, err := t.hIncrBy(b.formatKey("pps"), "blocks", 1); err != nil {
+			return err
+		}
+		if err := t.hSet(b.formatKey("pps"), "avgFee", ppsAvgFee(avgFee, reward.Fee).String()); err != nil {
+			return err
+		}
+		return b.winBlock(t, login, reward, ms, ts)
+	})
+}
+
+func (b *BoltStore) PpsAvgFee() (pool.Amount, error) {
+	var avgFee string
+	err := b.view(func(t *boltTx) error {
+		var err error
+		avgFee, err = t.hGet(b.formatKey("pps"), "avgFee")
+		return err
+	})
+	if err == errNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return pool.ParseAmount(avgFee)
+}
+
+func (b *BoltStore) GetPpsStats(reserve pool.Amount) (map[string]interface{}, error) {
+	var vals []interface{}
+	err := b.view(func(t *boltTx) error {
+		vals = t.hmGet(b.formatKey("pps"), ppsStatsFields...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ppsStats(reserve, vals), nil
+}
+
+func (b *BoltStore) SetPayment(login, txHash, remark string, payment pool.Amount, ms, ts int64) error {
+	return b.update(func(t *boltTx) error {
+		account := b.formatKey("account", login)
+		if _, err := t.hIncrBy(account, "payment", int64(payment)); err != nil {
+			return err
+		}
+		if err := b.incrUnpaid(t, login, -1*int64(payment)); err != nil {
+			return err
+		}
+		if err := t.hSet(account, "paidAt", ms); err != nil {
+			return err
+		}
+		if _, err := t.hIncrBy(b.formatKey("pool", "account"), "payment", int64(payment)); err != nil {
+			return err
+		}
+		if _, err := t.hIncrBy(b.formatKey("pool", "account"), "unpaid", -1*int64(payment)); err != nil {
+			return err
+		}
+		if err := t.zAdd(b.formatKey("payment", login), float64(ts), join(payment, ms, txHash, remark)); err != nil {
+			return err
+		}
+		return t.zAdd(b.formatKey("balance", login), float64(ts), join("payment", payment, ms, txHash, remark))
+	})
+}
+
+// record a batch payment and close its journal entry, see KvClient.SetChunkPayment
+func (b *BoltStore) SetChunkPayment(j *PaymentJournal, ms, ts int64) error {
+	if len(j.Logins) != len(j.Amounts) || len(j.Fees) > 0 && len(j.Fees) != len(j.Logins) {
+		return errors.New("set payment chunck size not match")
+	}
+	var total int64
+	for _, v := range j.Amounts {
+		total += v
+	}
+	return b.update(func(t *boltTx) error {
+		// a journal entry is recorded once, concurrent or repeated recoveries of it are no-ops
+		status, _ := t.hGet(b.formatKey("journal", j.TxHash), "status")
+		if status != JournalPending && status != JournalBroadcast {
+			return nil
+		}
+		err := t.hIncrByAll(b.formatKey("pool", "account"), "payment", total, "unpaid", -1*total, "fees", j.Fee)
+		if err != nil {
+			return err
+		}
+		for i, login := range j.Logins {
+			account := b.formatKey("account", login)
+			if _, err := t.hIncrBy(account, "payment", j.Amounts[i]); err != nil {
+				return err
+			}
+			if err := b.incrUnpaid(t, login, -1*j.Amounts[i]); err != nil {
+				return err
+			}
+			if err := t.hSet(account, "paidAt", ms); err != nil {
+				return err
+			}
+			if len(j.Fees) > 0 {
+				if _, err := t.hIncrBy(account, "fee", j.Fees[i]); err != nil {
+					return err
+				}
+			}
+			if err := t.zAdd(b.formatKey("payment", login), float64(ts),
+				join(pool.Amount(j.Amounts[i]), ms, j.TxHash, j.Remark)); err != nil {
+				return err
+			}
+			if err := t.zAdd(b.formatKey("balance", login), float64(ts),
+				join("payment", pool.Amount(j.Amounts[i]), ms, j.TxHash, j.Remark)); err != nil {
+				return err
+			}
+		}
+		if err := t.hSet(b.formatKey("journal", j.TxHash), "status", JournalRecorded, "recordedAt", ms, "updatedAt", ms); err != nil {
+			return err
+		}
+		if err := t.sRem(b.formatKey("journal", "open"), j.TxHash); err != nil {
+			return err
+		}
+		return t.zAdd(b.formatKey("journal", "unconfirmed"), float64(ms), j.TxHash)
+	})
+}
+
+// get all miners and their unpaid amount which unpaid amount bigger than threshold
+func (b *BoltStore) GetMinersToPay(threshold, minThreshold pool.Amount) []MinerPayout {
+	var miners []MinerPayout
+	err := b.view(func(t *boltTx) error {
+		for _, z := range t.zRangeByScore(b.formatKey("unpaid"), float64(minThreshold), math.Inf(1)) {
+			login := z.member
+			vals := t.hmGet(b.formatKey("account", login), "unpaid", "paidAt")
+			if vals[0] == nil {
+				continue
+			}
+			unpaid, err := strconv.ParseInt(parseString(vals[0]), 10, 64)
+			if err != nil {
+				util.Error.Println("get miner unpaid error", login, err)
+				continue
+			}
+			settings := parseMinerSettings(t.hGetAll(b.formatKey("settings", login)))
+			if pool.Amount(unpaid) <= settings.EffectiveThreshold(threshold, minThreshold) {
+				continue
+			}
+			payout := MinerPayout{Login: login, Address: login, Unpaid: pool.Amount(unpaid), PaidAt: parseInt64(vals[1])}
+			if settings != nil && settings.PayoutAddress != "" {
+				payout.Address = settings.PayoutAddress
+			}
+			miners = append(miners, payout)
+		}
+		return nil
+	})
+	if err != nil {
+		util.Error.Println("range miner unpaid error", err)
+		return nil
+	}
+	return miners
+}
+
+// rebuild unpaid index from miner accounts, for data written before the index existed
+func (b *BoltStore) IndexUnpaid() (int64, error) {
+	var total int64
+	err := b.update(func(t *boltTx) error {
+		prefix := b.formatKey("account", "")
+		for _, key := range t.scan(bucketHash, prefix) {
+			unpaid, err := t.hGetInt64(key, "unpaid")
+			if err == errNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			login := strings.TrimPrefix(key, prefix)
+			if unpaid <= 0 {
+				err = t.zRem(b.formatKey("unpaid"), login)
+			} else {
+				err = t.zAdd(b.formatKey("unpaid"), float64(unpaid), login)
+				total++
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+func (b *BoltStore) JournalPending(j *PaymentJournal) error {
+	return b.update(func(t *boltTx) error {
+		if err := t.hSet(b.formatKey("journal", j.TxHash), journalFields(j, util.MakeTimestamp())...); err != nil {
+			return err
+		}
+		return t.sAdd(b.formatKey("journal", "open"), j.TxHash)
+	})
+}
+
+func (b *BoltStore) JournalBroadcast(txHash string) error {
+	return b.update(func(t *boltTx) error {
+		return t.hSet(b.formatKey("journal", txHash), "status", JournalBroadcast, "updatedAt", util.MakeTimestamp())
+	})
+}
+
+func (b *BoltStore) JournalAbort(txHash, reason string) error {
+	return b.update(func(t *boltTx) error {
+		err := t.hSet(b.formatKey("journal", txHash),
+			"status", JournalAborted, "error", reason, "updatedAt", util.MakeTimestamp())
+		if err != nil {
+			return err
+		}
+		return t.sRem(b.formatKey("journal", "open"), txHash)
+	})
+}
+
+func (b *BoltStore) GetJournal(txHash string) (*PaymentJournal, error) {
+	var j *PaymentJournal
+	err := b.view(func(t *boltTx) error {
+		j = b.journal(t, txHash)
+		return nil
+	})
+	return j, err
+}
+
+func (b *BoltStore) journal(t *boltTx, txHash string) *PaymentJournal {
+	m := t.hGetAll(b.formatKey("journal", txHash))
+	if len(m) == 0 {
+		return nil
+	}
+	return parseJournal(txHash, m)
+}
+
+// journal entries not recorded or aborted yet
+func (b *BoltStore) OpenJournals() ([]*PaymentJournal, error) {
+	var res []*PaymentJournal
+	err := b.update(func(t *boltTx) error {
+		for _, h := range t.sMembers(b.formatKey("journal", "open")) {
+			j := b.journal(t, h)
+			if j == nil {
+				util.Error.Println("payment journal entry lost", h)
+				if err := t.sRem(b.formatKey("journal", "open"), h); err != nil {
+					return err
+				}
+				continue
+			}
+			res = append(res, j)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// recorded payments waiting for confirmation, oldest first
+func (b *BoltStore) UnconfirmedPayments() ([]*PaymentJournal, error) {
+	var res []*PaymentJournal
+	err := b.update(func(t *boltTx) error {
+		for _, h := range t.zRangeMembers(b.formatKey("journal", "unconfirmed"), 0, -1, false) {
+			j := b.journal(t, h)
+			if j == nil || j.Status != JournalRecorded {
+				util.Error.Println("unconfirmed payment journal entry lost", h)
+				if err := t.zRem(b.formatKey("journal", "unconfirmed"), h); err != nil {
+					return err
+				}
+				continue
+			}
+			res = append(res, j)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (b *BoltStore) ConfirmPayment(txHash string) error {
+	return b.update(func(t *boltTx) error {
+		err := t.hSet(b.formatKey("journal", txHash), "status", JournalConfirmed, "updatedAt", util.MakeTimestamp())
+		if err != nil {
+			return err
+		}
+		return t.zRem(b.formatKey("journal", "unconfirmed"), txHash)
+	})
+}
+
+// revert a recorded payment never accepted by node, amounts go back to unpaid
+func (b *BoltStore) FailPayment(j *PaymentJournal, reason string, ms, ts int64) error {
+	if len(j.Logins) != len(j.Amounts) {
+		return errors.New("failed payment chunck size not match")
+	}
+	var total int64
+	for _, v := range j.Amounts {
+		total += v
+	}
+	return b.update(func(t *boltTx) error {
+		// only a recorded payment is reverted, and only once
+		status, _ := t.hGet(b.formatKey("journal", j.TxHash), "status")
+		if status != JournalRecorded {
+			return ErrJournalStatus
+		}
+		err := t.hIncrByAll(b.formatKey("pool", "account"), "payment", -1*total, "unpaid", total, "fees", -1*j.Fee)
+		if err != nil {
+			return err
+		}
+		for i, login := range j.Logins {
+			account := b.formatKey("account", login)
+			if _, err := t.hIncrBy(account, "payment", -1*j.Amounts[i]); err != nil {
+				return err
+			}
+			if err := b.incrUnpaid(t, login, j.Amounts[i]); err != nil {
+				return err
+			}
+			if i < len(j.Fees) {
+				if _, err := t.hIncrBy(account, "fee", -1*j.Fees[i]); err != nil {
+					return err
+				}
+			}
+			if err := t.zAdd(b.formatKey("balance", login), float64(ts),
+				join("refund", pool.Amount(j.Amounts[i]), ms, j.TxHash, reason)); err != nil {
+				return err
+			}
+		}
+		if err := t.hSet(b.formatKey("journal", j.TxHash), "status", JournalFailed, "error", reason, "updatedAt", ms); err != nil {
+			return err
+		}
+		return t.zRem(b.formatKey("journal", "unconfirmed"), j.TxHash)
+	})
+}
+
+func (b *BoltStore) SetMinerSettings(login string, s *MinerSettings) error {
+	return b.update(func(t *boltTx) error {
+		return t.hSet(b.formatKey("settings", login),
+			"threshold", int64(s.Threshold),
+			"payoutAddress", s.PayoutAddress,
+			"timestamp", s.Timestamp)
+	})
+}
+
+func (b *BoltStore) GetMinerSettings(login string) (*MinerSettings, error) {
+	var s *MinerSettings
+	err := b.view(func(t *boltTx) error {
+		s = parseMinerSettings(t.hGetAll(b.formatKey("settings", login)))
+		return nil
+	})
+	return s, err
+}
+
+func (b *BoltStore) PausePayouts(reason string) error {
+	return b.update(func(t *boltTx) error {
+		return t.hSet(b.formatKey("payouts"), "paused", 1, "reason", reason, "pausedAt", util.MakeTimestamp()/1000)
+	})
+}
+
+func (b *BoltStore) ResumePayouts() error {
+	return b.update(func(t *boltTx) error {
+		return t.del(b.formatKey("payouts"))
+	})
+}
+
+func (b *BoltStore) GetPayoutsState() (*PayoutsState, error) {
+	var s *PayoutsState
+	err := b.view(func(t *boltTx) error {
+		s = parsePayoutsState(t.hGetAll(b.formatKey("payouts")))
+		return nil
+	})
+	return s, err
+}
+
+func (b *BoltStore) PayoutsPaused() (bool, error) {
+	s, err := b.GetPayoutsState()
+	if err != nil {
+		return false, err
+	}
+	return s.Paused, nil
+}
+
+func (b *BoltStore) SavePayoutReport(p *PayoutReport) error {
+	return b.update(func(t *boltTx) error {
+		if err := t.zAdd(b.formatKey("payouts", "runs"), float64(p.Timestamp), payoutReportMember(p)); err != nil {
+			return err
+		}
+		_, err := t.zRemRangeByRank(b.formatKey("payouts", "runs"), 0, -maxPayoutReports-1)
+		return err
+	})
+}
+
+func (b *BoltStore) PayoutReports(n int64) ([]PayoutReport, error) {
+	list := []PayoutReport{}
+	err := b.view(func(t *boltTx) error {
+		for _, v := range t.zRangeMembers(b.formatKey("payouts", "runs"), 0, n-1, true) {
+			p, err := convertPayoutReport(v)
+			if err != nil {
+				continue
+			}
+			list = append(list, p)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// amount in a field of hash and card of sorted set
+func (b *BoltStore) totalAndCount(key, field, zset string) (pool.Amount, int64, error) {
+	var val, count int64
+	err := b.view(func(t *boltTx) error {
+		var err error
+		val, err = t.hGetInt64(key, field)
+		count = t.zCard(zset)
+		return err
+	})
+	return pool.Amount(val), count, err
+}
+
+// members of sorted set by rank
+func (b *BoltStore) zRange(key string, start, end int64) []string {
+	var val []string
+	_ = b.view(func(t *boltTx) error {
+		val = t.zRangeMembers(key, start, end, false)
+		return nil
+	})
+	return val
+}
+
+func (b *BoltStore) GetTotalDonate() (pool.Amount, int64, error) {
+	val, count, err := b.totalAndCount(b.formatKey("pool", "account"), "donate", b.formatKey("pool", "donate"))
+	if err != nil {
+		util.Error.Println("get pool total donate error", err)
+		return 0, 0, err
+	}
+	return val, count, nil
+}
+
+func (b *BoltStore) GetDonateList(start, end int64) ([]DonateData, error) {
+	var list []DonateData
+	for _, v := range b.zRange(b.formatKey("pool", "donate"), start, end) {
+		d, err := convertDonate(v)
+		if err != nil {
+			continue
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+func (b *BoltStore) GetPoolAccount() (pool.Amount, pool.Amount, pool.Amount, pool.Amount, error) {
+	var vals [4]int64
+	err := b.view(func(t *boltTx) error {
+		for i, field := range []string{"rewards", "payment", "unpaid", "donate"} {
+			var err error
+			if vals[i], err = t.hGetInt64(b.formatKey("pool", "account"), field); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		util.Error.Println("get pool account error", err)
+		return 0, 0, 0, 0, err
+	}
+	return pool.Amount(vals[0]), pool.Amount(vals[1]), pool.Amount(vals[2]), pool.Amount(vals[3]), nil
+}
+
+func (b *BoltStore) GetTotalPoolRewards() (pool.Amount, int64, error) {
+	val, count, err := b.totalAndCount(b.formatKey("pool", "account"), "rewards", b.formatKey("pool", "rewards"))
+	if err != nil {
+		util.Error.Println("get pool total rewards error", err)
+		return 0, 0, err
+	}
+	return val, count, nil
+}
+
+func (b *BoltStore) GetPoolRewardsList(start, end int64) ([]PoolRewardsData, error) {
+	var list []PoolRewardsData
+	for _, v := range b.zRange(b.formatKey("pool", "rewards"), start, end) {
+		d, err := convertPoolRewards(v)
+		if err != nil {
+			continue
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+func (b *BoltStore) GetMinerAccount(address string) (pool.Amount, pool.Amount, pool.Amount, error) {
+	var vals []interface{}
+	_ = b.view(func(t *boltTx) error {
+		vals = t.hmGet(b.formatKey("account", address), "reward", "payment", "unpaid")
+		return nil
+	})
+	if vals[0] == nil {
+		util.Error.Println("get miner total reward error", errNotFound, address)
+	}
+	return pool.Amount(parseInt64(vals[0])), pool.Amount(parseInt64(vals[1])), pool.Amount(parseInt64(vals[2])), nil
+}
+
+func (b *BoltStore) GetMinerUnpaid(address string) pool.Amount {
+	var unpaid int64
+	err := b.view(func(t *boltTx) error {
+		var err error
+		unpaid, err = t.hGetInt64(b.formatKey("account", address), "unpaid")
+		return err
+	})
+	if err != nil {
+		util.Error.Println("get pool total unpaid error", err)
+		return 0
+	}
+	return pool.Amount(unpaid)
+}
+
+func (b *BoltStore) MinerTotalRewards(address string) (pool.Amount, int64, error) {
+	val, count, err := b.totalAndCount(b.formatKey("account", address), "reward", b.formatKey("rewards", address))
+	if err != nil {
+		util.Error.Println("get miner total rewards error", err)
+		return 0, 0, err
+	}
+	return val, count, nil
+}
+
+func (b *BoltStore) MinerRewardsList(address string, start, end int64) ([]MinerRewardsData, error) {
+	var list []MinerRewardsData
+	for _, v := range b.zRange(b.formatKey("rewards", address), start, end) {
+		d, err := convertMinerRewards(v)
+		if err != nil {
+			continue
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+func (b *BoltStore) MinerTotalPayment(address string) (pool.Amount, int64, error) {
+	val, count, err := b.totalAndCount(b.formatKey("account", address), "payment", b.formatKey("payment", address))
+	if err != nil {
+		util.Error.Println("get miner total payment error", err)
+		return 0, 0, err
+	}
+	return val, count, nil
+}
+
+func (b *BoltStore) MinerPaymentList(address string, start, end int64) ([]MinerPaymentData, error) {
+	var list []MinerPaymentData
+	err := b.view(func(t *boltTx) error {
+		for _, v := range t.zRangeMembers(b.formatKey("payment", address), start, end, false) {
+			d, err := convertMinerPayment(v)
+			if err != nil {
+				continue
+			}
+			d.Status, _ = t.hGet(b.formatKey("journal", d.TxBlock), "status")
+			list = append(list, d)
+		}
+		return nil
+	})
+	return list, err
+}
+
+func (b *BoltStore) MinerBalanceList(address string, start, end int64) (int64, []MinerBalanceData, error) {
+	var count int64
+	var list []MinerBalanceData
+	err := b.view(func(t *boltTx) error {
+		count = t.zCard(b.formatKey("balance", address))
+		for _, v := range t.zRangeMembers(b.formatKey("balance", address), start, end, false) {
+			d, err := convertMinerBalance(v)
+			if err != nil {
+				continue
+			}
+			if d.Action == "payment" {
+				d.Status, _ = t.hGet(b.formatKey("journal", d.TxBlock), "status")
+			}
+			list = append(list, d)
+		}
+		return nil
+	})
+	return count, list, err
+}
+
+func (b *BoltStore) GetLedger() (*Ledger, error) {
+	var l *Ledger
+	err := b.view(func(t *boltTx) error {
+		vals := t.hmGet(b.formatKey("pool", "account"), "rewards", "payment", "unpaid", "fees")
+		l = &Ledger{
+			Rewards: pool.Amount(parseInt64(vals[0])),
+			Payment: pool.Amount(parseInt64(vals[1])),
+			Unpaid:  pool.Amount(parseInt64(vals[2])),
+			Fees:    pool.Amount(parseInt64(vals[3])),
+		}
+		l.PpsRewards = pool.Amount(parseInt64(t.hmGet(b.formatKey("pps"), "rewards")[0]))
+		prefix := b.formatKey("account", "")
+		for _, key := range t.scan(bucketHash, prefix) {
+			vals := t.hmGet(key, "reward", "payment", "unpaid")
+			reward := pool.Amount(parseInt64(vals[0]))
+			payment := pool.Amount(parseInt64(vals[1]))
+			unpaid := pool.Amount(parseInt64(vals[2]))
+			l.MinerRewards += reward
+			l.MinerPayment += payment
+			l.MinerUnpaid += unpaid
+			l.Accounts++
+			if unpaid != reward-payment {
+				l.Mismatched = append(l.Mismatched, strings.TrimPrefix(key, prefix))
+			}
+		}
+		return nil
+	})
+	return l, err
+}
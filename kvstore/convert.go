@@ -4,11 +4,13 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+
+	"github.com/XDagger/xdagpool/pool"
 )
 
 type DonateData struct {
 	Timestamp int64
-	Donate    float64
+	Donate    pool.Amount
 	TxBlock   string
 }
 
@@ -18,7 +20,7 @@ func convertDonate(member string) (DonateData, error) {
 	if len(fields) != 4 {
 		return donate, errors.New("donate data format error")
 	}
-	val, _ := strconv.ParseFloat(fields[0], 64)
+	val, _ := pool.ParseAmount(fields[0])
 	t, _ := strconv.ParseInt(fields[1], 10, 64)
 	return DonateData{
 		Donate:    val,
@@ -29,8 +31,8 @@ func convertDonate(member string) (DonateData, error) {
 
 type PoolRewardsData struct {
 	Timestamp int64
-	Reward    float64
-	Fee       float64
+	Reward    pool.Amount
+	Fee       pool.Amount
 	TxBlock   string
 }
 
@@ -40,9 +42,9 @@ func convertPoolRewards(member string) (PoolRewardsData, error) {
 	if len(fields) != 6 {
 		return donate, errors.New("pool rewards data format error")
 	}
-	val, _ := strconv.ParseFloat(fields[0], 64)
+	val, _ := pool.ParseAmount(fields[0])
 	t, _ := strconv.ParseInt(fields[1], 10, 64)
-	fee, _ := strconv.ParseFloat(fields[4], 64)
+	fee, _ := pool.ParseAmount(fields[4])
 	return PoolRewardsData{
 		Reward:    val,
 		Timestamp: t,
@@ -53,7 +55,7 @@ func convertPoolRewards(member string) (PoolRewardsData, error) {
 
 type MinerRewardsData struct {
 	Timestamp int64
-	Reward    float64
+	Reward    pool.Amount
 	TxBlock   string
 	Mode      string
 }
@@ -64,7 +66,7 @@ func convertMinerRewards(member string) (MinerRewardsData, error) {
 	if len(fields) != 5 {
 		return donate, errors.New("miner rewards data format error")
 	}
-	val, _ := strconv.ParseFloat(fields[0], 64)
+	val, _ := pool.ParseAmount(fields[0])
 	t, _ := strconv.ParseInt(fields[1], 10, 64)
 
 	return MinerRewardsData{
@@ -77,17 +79,18 @@ func convertMinerRewards(member string) (MinerRewardsData, error) {
 
 type MinerPaymentData struct {
 	Timestamp int64
-	Payment   float64
+	Payment   pool.Amount
 	TxBlock   string
+	Status    string
 }
 
 func convertMinerPayment(member string) (MinerPaymentData, error) {
 	var donate MinerPaymentData
 	fields := strings.Split(member, ":")
-	if len(fields) != 4 {
+	if len(fields) < 4 { // remark may contain ':'
 		return donate, errors.New("miner Payment data format error")
 	}
-	val, _ := strconv.ParseFloat(fields[0], 64)
+	val, _ := pool.ParseAmount(fields[0])
 	t, _ := strconv.ParseInt(fields[1], 10, 64)
 
 	return MinerPaymentData{
@@ -100,17 +103,18 @@ func convertMinerPayment(member string) (MinerPaymentData, error) {
 type MinerBalanceData struct {
 	Action    string
 	Timestamp int64
-	Value     float64
+	Value     pool.Amount
 	TxBlock   string
+	Status    string
 }
 
 func convertMinerBalance(member string) (MinerBalanceData, error) {
 	var donate MinerBalanceData
 	fields := strings.Split(member, ":")
-	if len(fields) != 4 {
+	if len(fields) < 4 { // remark may contain ':'
 		return donate, errors.New("miner Balance data format error")
 	}
-	val, _ := strconv.ParseFloat(fields[1], 64)
+	val, _ := pool.ParseAmount(fields[1])
 	t, _ := strconv.ParseInt(fields[2], 10, 64)
 
 	return MinerBalanceData{
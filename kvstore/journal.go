@@ -0,0 +1,263 @@
+package kvstore
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// payment journal status, a payment is journaled before its transaction is sent
+const (
+	JournalPending   = "pending"   // transaction built, may or may not be sent
+	JournalBroadcast = "broadcast" // node accepted transaction, payment not recorded
+	JournalRecorded  = "recorded"  // unpaid balances decremented
+	JournalAborted   = "aborted"   // transaction rejected by node, nothing paid
+	JournalConfirmed = "confirmed" // transaction accepted in dag
+	JournalFailed    = "failed"    // transaction rejected or unknown to node in time, unpaid re-credited
+)
+
+// journal entry is not in a status the change applies to
+var ErrJournalStatus = errors.New("payment journal status does not allow the change")
+
+type PaymentJournal struct {
+	TxHash     string   `json:"txHash"`
+	Block      string   `json:"-"` // signed transaction block hex
+	Remark     string   `json:"remark"`
+	Logins     []string `json:"logins"`
+	Addresses  []string `json:"addresses"` // payout addresses of logins
+	Amounts    []int64  `json:"amounts"`   // taken from unpaid of logins
+	Fees       []int64  `json:"fees"`      // fee share of logins deducted from their outputs
+	Fee        int64    `json:"fee"`       // transaction fee
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	CreatedAt  int64    `json:"createdAt"`
+	RecordedAt int64    `json:"recordedAt"`
+	UpdatedAt  int64    `json:"updatedAt"`
+}
+
+// write journal entry before sending its transaction
+func (r *KvClient) JournalPending(j *PaymentJournal) error {
+	tx := r.client.TxPipeline()
+	tx.HSet(ctx, r.formatKey("journal", j.TxHash), journalFields(j, util.MakeTimestamp())...)
+	tx.SAdd(ctx, r.formatKey("journal", "open"), j.TxHash)
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+// hash fields of a pending journal entry
+func journalFields(j *PaymentJournal, ms int64) []interface{} {
+	amounts := make([]string, len(j.Amounts))
+	for i, v := range j.Amounts {
+		amounts[i] = strconv.FormatInt(v, 10)
+	}
+	fees := make([]string, len(j.Fees))
+	for i, v := range j.Fees {
+		fees[i] = strconv.FormatInt(v, 10)
+	}
+	return []interface{}{
+		"status", JournalPending,
+		"block", j.Block,
+		"remark", j.Remark,
+		"logins", strings.Join(j.Logins, ","),
+		"addresses", strings.Join(j.Addresses, ","),
+		"amounts", strings.Join(amounts, ","),
+		"fees", strings.Join(fees, ","),
+		"fee", j.Fee,
+		"createdAt", ms,
+		"updatedAt", ms,
+	}
+}
+
+func (r *KvClient) JournalBroadcast(txHash string) error {
+	return r.client.HSet(ctx, r.formatKey("journal", txHash),
+		"status", JournalBroadcast, "updatedAt", util.MakeTimestamp()).Err()
+}
+
+// close journal entry of a transaction rejected by node
+func (r *KvClient) JournalAbort(txHash, reason string) error {
+	tx := r.client.TxPipeline()
+	tx.HSet(ctx, r.formatKey("journal", txHash),
+		"status", JournalAborted, "error", reason, "updatedAt", util.MakeTimestamp())
+	tx.SRem(ctx, r.formatKey("journal", "open"), txHash)
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+func (r *KvClient) GetJournal(txHash string) (*PaymentJournal, error) {
+	m, err := r.client.HGetAll(ctx, r.formatKey("journal", txHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return parseJournal(txHash, m), nil
+}
+
+func parseJournal(txHash string, m map[string]string) *PaymentJournal {
+	j := &PaymentJournal{
+		TxHash: txHash,
+		Block:  m["block"],
+		Remark: m["remark"],
+		Status: m["status"],
+		Error:  m["error"],
+	}
+	j.CreatedAt, _ = strconv.ParseInt(m["createdAt"], 10, 64)
+	j.RecordedAt, _ = strconv.ParseInt(m["recordedAt"], 10, 64)
+	j.UpdatedAt, _ = strconv.ParseInt(m["updatedAt"], 10, 64)
+	if m["logins"] != "" {
+		j.Logins = strings.Split(m["logins"], ",")
+	}
+	if m["addresses"] != "" {
+		j.Addresses = strings.Split(m["addresses"], ",")
+	}
+	if m["amounts"] != "" {
+		for _, v := range strings.Split(m["amounts"], ",") {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			j.Amounts = append(j.Amounts, n)
+		}
+	}
+	if m["fees"] != "" {
+		for _, v := range strings.Split(m["fees"], ",") {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			j.Fees = append(j.Fees, n)
+		}
+	}
+	j.Fee, _ = strconv.ParseInt(m["fee"], 10, 64)
+	return j
+}
+
+// journal entries not recorded or aborted yet
+func (r *KvClient) OpenJournals() ([]*PaymentJournal, error) {
+	hashes, err := r.client.SMembers(ctx, r.formatKey("journal", "open")).Result()
+	if err != nil {
+		return nil, err
+	}
+	var res []*PaymentJournal
+	for _, h := range hashes {
+		j, err := r.GetJournal(h)
+		if err != nil {
+			return nil, err
+		}
+		if j == nil {
+			util.Error.Println("payment journal entry lost", h)
+			r.client.SRem(ctx, r.formatKey("journal", "open"), h)
+			continue
+		}
+		res = append(res, j)
+	}
+	return res, nil
+}
+
+// recorded payments waiting for confirmation, oldest first
+func (r *KvClient) UnconfirmedPayments() ([]*PaymentJournal, error) {
+	hashes, err := r.client.ZRange(ctx, r.formatKey("journal", "unconfirmed"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	var res []*PaymentJournal
+	for _, h := range hashes {
+		j, err := r.GetJournal(h)
+		if err != nil {
+			return nil, err
+		}
+		if j == nil || j.Status != JournalRecorded {
+			util.Error.Println("unconfirmed payment journal entry lost", h)
+			r.client.ZRem(ctx, r.formatKey("journal", "unconfirmed"), h)
+			continue
+		}
+		res = append(res, j)
+	}
+	return res, nil
+}
+
+func (r *KvClient) ConfirmPayment(txHash string) error {
+	tx := r.client.TxPipeline()
+	tx.HSet(ctx, r.formatKey("journal", txHash),
+		"status", JournalConfirmed, "updatedAt", util.MakeTimestamp())
+	tx.ZRem(ctx, r.formatKey("journal", "unconfirmed"), txHash)
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+// revert a recorded payment never accepted by node, amounts go back to unpaid
+func (r *KvClient) FailPayment(j *PaymentJournal, reason string, ms, ts int64) error {
+	if len(j.Logins) != len(j.Amounts) {
+		return errors.New("failed payment chunck size not match")
+	}
+	var total int64
+	for _, v := range j.Amounts {
+		total += v
+	}
+	// only a recorded payment is reverted, and only once
+	return r.journalTransition(j.TxHash, func(tx redis.Pipeliner) {
+		tx.HIncrBy(ctx, r.formatKey("pool", "account"), "payment", -1*total)
+		tx.HIncrBy(ctx, r.formatKey("pool", "account"), "unpaid", total)
+		tx.HIncrBy(ctx, r.formatKey("pool", "account"), "fees", -1*j.Fee)
+
+		for i, login := range j.Logins {
+			tx.HIncrBy(ctx, r.formatKey("account", login), "payment", -1*j.Amounts[i])
+			tx.HIncrBy(ctx, r.formatKey("account", login), "unpaid", j.Amounts[i])
+			tx.ZIncrBy(ctx, r.formatKey("unpaid"), float64(j.Amounts[i]), login)
+			if i < len(j.Fees) {
+				tx.HIncrBy(ctx, r.formatKey("account", login), "fee", -1*j.Fees[i])
+			}
+			tx.ZAdd(ctx, r.formatKey("balance", login), redis.Z{Score: float64(ts),
+				Member: join("refund", pool.Amount(j.Amounts[i]), ms, j.TxHash, reason)})
+		}
+		tx.HSet(ctx, r.formatKey("journal", j.TxHash),
+			"status", JournalFailed, "error", reason, "updatedAt", ms)
+		tx.ZRem(ctx, r.formatKey("journal", "unconfirmed"), j.TxHash)
+	}, JournalRecorded)
+}
+
+// queue fn in a transaction under WATCH of the journal entry, if its status is one of status.
+// a concurrent change of the entry fails the transaction with redis.TxFailedErr
+func (r *KvClient) journalTransition(txHash string, fn func(tx redis.Pipeliner), status ...string) error {
+	key := r.formatKey("journal", txHash)
+	return r.client.Watch(ctx, func(w *redis.Tx) error {
+		cur, err := w.HGet(ctx, key, "status").Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if !journalStatusIn(cur, status) {
+			return ErrJournalStatus
+		}
+		_, err = w.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+			fn(tx)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func journalStatusIn(cur string, status []string) bool {
+	for _, s := range status {
+		if cur == s {
+			return true
+		}
+	}
+	return false
+}
+
+// journal status of transactions, empty for payments made before journaling
+func (r *KvClient) paymentStatus(txHashes []string) []string {
+	status := make([]string, len(txHashes))
+	if len(txHashes) == 0 {
+		return status
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(txHashes))
+	for i, h := range txHashes {
+		cmds[i] = pipe.HGet(ctx, r.formatKey("journal", h), "status")
+	}
+	_, _ = pipe.Exec(ctx)
+	for i, cmd := range cmds {
+		status[i] = cmd.Val()
+	}
+	return status
+}
@@ -19,8 +19,9 @@ var ctx = context.Background()
 const expireDuration = 30 * time.Minute
 
 type KvClient struct {
-	client *redis.Client
-	prefix string
+	client      *redis.Client
+	prefix      string
+	pplnsShares int64 // length of pplns share window
 }
 
 func NewKvClient(cfg *pool.StorageConfig, prefix string) *KvClient {
@@ -37,6 +38,10 @@ func (r *KvClient) Check() (string, error) {
 	return r.client.Ping(ctx).Result()
 }
 
+func (r *KvClient) Close() error {
+	return r.client.Close()
+}
+
 func (r *KvClient) WriteInvalidShare(ms, ts int64, login, id string, diff int64) error {
 	cmd := r.client.ZAdd(ctx, r.formatKey("invalidhashrate"), redis.Z{Score: float64(ts), Member: join(diff, login, id, ms)})
 	if cmd.Err() != nil {
@@ -97,6 +102,7 @@ func (r *KvClient) WriteBlock(login, id, share string, diff int64, shareU64 uint
 	tx.HIncrBy(ctx, r.formatKey("job", jobHash), login, diff)   // accumulate miners diff of the job (identified by job hash)
 	tx.Expire(ctx, r.formatKey("job", jobHash), expireDuration)
 	tx.Expire(ctx, r.formatKey("pool", jobHash), expireDuration)
+	r.writePplnsShare(tx, login, diff, ms)
 	// cmds, err := tx.Exec(ctx)
 	_, err := tx.Exec(ctx)
 	if err != nil {
@@ -116,10 +122,11 @@ func (r *KvClient) WriteBlock(login, id, share string, diff int64, shareU64 uint
 	// }
 	return false, nil
 }
-func (r *KvClient) SetMinerReward(login, txHash, jobHash string, reward float64, ms, ts int64) error {
+func (r *KvClient) SetMinerReward(login, txHash, jobHash string, reward pool.Amount, ms, ts int64) error {
 	tx := r.client.TxPipeline()
-	tx.HIncrBy(ctx, r.formatKey("account", login), "reward", int64(reward*1e9))
-	tx.HIncrBy(ctx, r.formatKey("account", login), "unpaid", int64(reward*1e9))
+	tx.HIncrBy(ctx, r.formatKey("account", login), "reward", int64(reward))
+	tx.HIncrBy(ctx, r.formatKey("account", login), "unpaid", int64(reward))
+	tx.ZIncrBy(ctx, r.formatKey("unpaid"), float64(reward), login)
 	tx.ZAdd(ctx, r.formatKey("rewards", jobHash), redis.Z{Score: float64(ts), Member: join(reward, ms, txHash, login)})
 	tx.ZAdd(ctx, r.formatKey("rewards", login), redis.Z{Score: float64(ts), Member: join(reward, ms, txHash, jobHash)})
 	tx.ZAdd(ctx, r.formatKey("balance", login), redis.Z{Score: float64(ts), Member: join("reward", reward, ms, txHash, jobHash)})
@@ -127,13 +134,6 @@ func (r *KvClient) SetMinerReward(login, txHash, jobHash string, reward float64,
 	return err
 }
 
-// func (r *KvClient) AddWaiting(jobHash string) {
-// 	_, err := r.client.SAdd(ctx, r.formatKey("waiting"), jobHash).Result()
-// 	if err != nil {
-// 		util.Error.Println("add job waiting  set error", jobHash, err)
-// 	}
-// }
-
 func (r *KvClient) SetWinReward(login string, reward pool.XdagjReward, ms, ts int64) error {
 	// res, err := r.client.SMove(ctx, r.formatKey("waiting"), r.formatKey("win"), reward.PreHash).Result()
 	// if err != nil {
@@ -144,31 +144,36 @@ func (r *KvClient) SetWinReward(login string, reward pool.XdagjReward, ms, ts in
 	// }
 
 	tx := r.client.TxPipeline()
-	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "rewards", int64(reward.Amount*1e9))
-	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "unpaid", int64(reward.Amount*1e9))
-	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "donate", int64(reward.Donate*1e9))
+	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "rewards", int64(reward.Amount))
+	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "unpaid", int64(reward.Amount))
+	r.winBlock(tx, login, reward, ms, ts)
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+// record won block, donation and its rewards entry in tx
+func (r *KvClient) winBlock(tx redis.Pipeliner, login string, reward pool.XdagjReward, ms, ts int64) {
+	diff, _ := r.client.HGet(ctx, r.formatKey("pool", reward.PreHash), "diff").Int64()
+	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "donate", int64(reward.Donate))
 	tx.ZAdd(ctx, r.formatKey("pool", "rewards"), redis.Z{Score: float64(ts),
 		Member: join(reward.Amount, reward.Fee, ms, reward.TxBlock, reward.PreHash, login, reward.Share)})
 	tx.ZAdd(ctx, r.formatKey("pool", "donate"), redis.Z{Score: float64(ts),
 		Member: join(reward.Donate, ms, reward.PreHash, reward.DonateBlock)}).Result()
 	tx.Del(ctx, r.formatKey("submit", reward.PreHash)).Result()
-	_, err := tx.Exec(ctx)
-	return err
+	tx.HSet(ctx, r.formatKey("block", reward.PreHash), resultFields(BlockWin, login, reward, diff, ms)...)
+	tx.ZAddNX(ctx, r.formatKey("blocks"), redis.Z{Score: float64(ts), Member: reward.PreHash})
+	tx.SRem(ctx, r.formatKey("blocks", "open"), reward.PreHash)
 }
 
-// func (r *KvClient) SetLostReward(login string, reward pool.XdagjReward, ms, ts int64) {
-// 	_, err := r.client.SMove(ctx, r.formatKey("waiting"), r.formatKey("lost"), reward.PreHash).Result()
-// 	if err != nil {
-// 		util.Error.Println("store lost set error", reward.PreHash, err)
-// 	}
-// }
-
-func (r *KvClient) SetPayment(login, txHash, remark string, payment float64, ms, ts int64) error {
+func (r *KvClient) SetPayment(login, txHash, remark string, payment pool.Amount, ms, ts int64) error {
 	tx := r.client.TxPipeline()
-	tx.HIncrBy(ctx, r.formatKey("account", login), "payment", int64(payment*1e9))
-	tx.HIncrBy(ctx, r.formatKey("account", login), "unpaid", -1*int64(payment*1e9))
-	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "payment", int64(payment*1e9))
-	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "unpaid", -1*int64(payment*1e9))
+	tx.HIncrBy(ctx, r.formatKey("account", login), "payment", int64(payment))
+	tx.HIncrBy(ctx, r.formatKey("account", login), "unpaid", -1*int64(payment))
+	tx.HSet(ctx, r.formatKey("account", login), "paidAt", ms)
+	tx.ZIncrBy(ctx, r.formatKey("unpaid"), -1*float64(payment), login)
+	tx.ZRemRangeByScore(ctx, r.formatKey("unpaid"), "-inf", "0")
+	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "payment", int64(payment))
+	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "unpaid", -1*int64(payment))
 	tx.ZAdd(ctx, r.formatKey("payment", login), redis.Z{Score: float64(ts),
 		Member: join(payment, ms, txHash, remark)})
 	tx.ZAdd(ctx, r.formatKey("balance", login), redis.Z{Score: float64(ts),
@@ -177,28 +182,45 @@ func (r *KvClient) SetPayment(login, txHash, remark string, payment float64, ms,
 	return err
 }
 
-func (r *KvClient) SetChunkPayment(logins []string, txHash, remark string, payments []int64, ms, ts int64) error {
-	if len(logins) != len(payments) {
+// record a batch payment: amounts are taken from unpaid of logins, fee shares of logins
+// are deducted from their outputs, the rest of transaction fee is paid by pool
+func (r *KvClient) SetChunkPayment(j *PaymentJournal, ms, ts int64) error {
+	if len(j.Logins) != len(j.Amounts) || len(j.Fees) > 0 && len(j.Fees) != len(j.Logins) {
 		return errors.New("set payment chunck size not match")
 	}
 	var total int64
-	for _, v := range payments {
+	for _, v := range j.Amounts {
 		total += v
 	}
-	tx := r.client.TxPipeline()
-	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "payment", total)
-	tx.HIncrBy(ctx, r.formatKey("pool", "account"), "unpaid", -1*total)
-
-	for i := 0; i < len(logins); i++ {
-		tx.HIncrBy(ctx, r.formatKey("account", logins[i]), "payment", payments[i])
-		tx.HIncrBy(ctx, r.formatKey("account", logins[i]), "unpaid", -1*payments[i])
+	// a journal entry is recorded once, concurrent or repeated recoveries of it are no-ops
+	err := r.journalTransition(j.TxHash, func(tx redis.Pipeliner) {
+		tx.HIncrBy(ctx, r.formatKey("pool", "account"), "payment", total)
+		tx.HIncrBy(ctx, r.formatKey("pool", "account"), "unpaid", -1*total)
+		tx.HIncrBy(ctx, r.formatKey("pool", "account"), "fees", j.Fee)
+
+		for i, login := range j.Logins {
+			tx.HIncrBy(ctx, r.formatKey("account", login), "payment", j.Amounts[i])
+			tx.HIncrBy(ctx, r.formatKey("account", login), "unpaid", -1*j.Amounts[i])
+			tx.HSet(ctx, r.formatKey("account", login), "paidAt", ms)
+			tx.ZIncrBy(ctx, r.formatKey("unpaid"), -1*float64(j.Amounts[i]), login)
+			if len(j.Fees) > 0 {
+				tx.HIncrBy(ctx, r.formatKey("account", login), "fee", j.Fees[i])
+			}
 
-		tx.ZAdd(ctx, r.formatKey("payment", logins[i]), redis.Z{Score: float64(ts),
-			Member: join(float64(payments[i])/float64(1e9), ms, txHash, remark)})
-		tx.ZAdd(ctx, r.formatKey("balance", logins[i]), redis.Z{Score: float64(ts),
-			Member: join("payment", float64(payments[i])/float64(1e9), ms, txHash, remark)})
+			tx.ZAdd(ctx, r.formatKey("payment", login), redis.Z{Score: float64(ts),
+				Member: join(pool.Amount(j.Amounts[i]), ms, j.TxHash, j.Remark)})
+			tx.ZAdd(ctx, r.formatKey("balance", login), redis.Z{Score: float64(ts),
+				Member: join("payment", pool.Amount(j.Amounts[i]), ms, j.TxHash, j.Remark)})
+		}
+		tx.ZRemRangeByScore(ctx, r.formatKey("unpaid"), "-inf", "0")
+		// close journal entry with the balances, a recovered payment is never recorded twice
+		tx.HSet(ctx, r.formatKey("journal", j.TxHash), "status", JournalRecorded, "recordedAt", ms, "updatedAt", ms)
+		tx.SRem(ctx, r.formatKey("journal", "open"), j.TxHash)
+		tx.ZAdd(ctx, r.formatKey("journal", "unconfirmed"), redis.Z{Score: float64(ms), Member: j.TxHash})
+	}, JournalPending, JournalBroadcast)
+	if err == ErrJournalStatus {
+		return nil
 	}
-	_, err := tx.Exec(ctx)
 	return err
 }
 
@@ -211,131 +233,141 @@ func (r *KvClient) SetChunkPayment(logins []string, txHash, remark string, payme
 // 	return nil
 // }
 
-// WARNING: Must run it periodically to flush out of window hashrate entries
-func (r *KvClient) FlushStaleStats(window, largeWindow time.Duration) (int64, error) {
-	now := util.MakeTimestamp() / 1000
-	max := fmt.Sprint("(", now-int64(window/time.Second))
-	total, err := r.client.ZRemRangeByScore(ctx, r.formatKey("hashrate"), "-inf", max).Result()
+// compare, store and trim min share of a job in one step, returns 1 if share is the new minimum.
+// KEYS: mini, submit; ARGV: share u64, login, share, expire seconds
+var minShareScript = redis.NewScript(`
+local z = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if #z > 0 and tonumber(z[2]) <= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SADD', KEYS[2], ARGV[3])
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+if #z == 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[4])
+	redis.call('EXPIRE', KEYS[2], ARGV[4])
+end
+redis.call('ZREMRANGEBYRANK', KEYS[1], 1, -1)
+return 1
+`)
+
+// get min share rxhash  (high 8 bytes of rxhash as uint64) of a job,
+// concurrent shares of a job are judged one at a time
+func (r *KvClient) IsMinShare(jobHash, login, share string, shareU64 uint64) bool {
+	keys := []string{r.formatKey("mini", jobHash), r.formatKey("submit", jobHash)}
+	min, err := minShareScript.Run(ctx, r.client, keys,
+		float64(shareU64), login, share, int64(expireDuration/time.Second)).Int()
 	if err != nil {
-		return total, err
+		util.Error.Printf("store %s min share failed %v", jobHash, err)
+		return false
 	}
+	return min == 1
+}
 
-	n, err := r.client.ZRemRangeByScore(ctx, r.formatKey("invalidhashrate"), "-inf", max).Result()
+func (r *KvClient) IsPoolShare(jobHash, share string) bool {
+	ok, err := r.client.SIsMember(ctx, r.formatKey("submit", jobHash), share).Result()
 	if err != nil {
-		return total, err
+		util.Error.Println("check pool submitted share error", err)
+		return false
 	}
-	total += n
+	return ok
+}
 
-	n, err = r.client.ZRemRangeByScore(ctx, r.formatKey("rejecthashrate"), "-inf", max).Result()
+// get all miners and their unpaid amount which unpaid amount bigger than threshold,
+// miner's own threshold overrides the pool's but never goes below minThreshold.
+// candidates come from the unpaid index, amounts from miner accounts
+func (r *KvClient) GetMinersToPay(threshold, minThreshold pool.Amount) []MinerPayout {
+	logins, err := r.client.ZRangeByScore(ctx, r.formatKey("unpaid"), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(int64(minThreshold), 10), Max: "+inf"}).Result()
 	if err != nil {
-		return total, err
+		util.Error.Println("range miner unpaid error", err)
+		return nil
 	}
-	total += n
-
-	var c uint64
-	miners := make(map[string]struct{})
-	max = fmt.Sprint("(", now-int64(largeWindow/time.Second))
-
-	for {
-		var keys []string
-		var err error
-		keys, c, err = r.client.Scan(ctx, c, r.formatKey("hashrate", "*"), 100).Result()
-		if err != nil {
-			return total, err
-		}
-		for _, row := range keys {
-			login := strings.Split(row, ":")[2]
-			if _, ok := miners[login]; !ok {
-				n, err := r.client.ZRemRangeByScore(ctx, r.formatKey("hashrate", login), "-inf", max).Result()
-				if err != nil {
-					return total, err
-				}
-				miners[login] = struct{}{}
-				total += n
-			}
-		}
-		if c == 0 {
-			break
-		}
+	if len(logins) == 0 {
+		return nil
 	}
-
-	return total, nil
-}
-
-// get min share rxhash  (high 8 bytes of rxhash as uint64) of a job
-func (r *KvClient) IsMinShare(jobHash, login, share string, shareU64 uint64) bool {
-
-	z, err := r.client.ZRangeWithScores(ctx, r.formatKey("mini", jobHash), 0, 0).Result()
-	if err != nil {
-		util.Error.Printf("Get %s min share failed %v", jobHash, err)
-		return false
+	// amounts and settings of all candidates in one round trip
+	pipe := r.client.Pipeline()
+	accountCmds := make([]*redis.SliceCmd, len(logins))
+	settingsCmds := make([]*redis.MapStringStringCmd, len(logins))
+	for i, login := range logins {
+		accountCmds[i] = pipe.HMGet(ctx, r.formatKey("account", login), "unpaid", "paidAt")
+		settingsCmds[i] = pipe.HGetAll(ctx, r.formatKey("settings", login))
 	}
-	if len(z) == 0 || uint64(z[0].Score) > shareU64 {
-		tx := r.client.TxPipeline()
-		tx.SAdd(ctx, r.formatKey("submit", jobHash), share) //store submitted share
-		tx.ZAdd(ctx, r.formatKey("mini", jobHash), redis.Z{Score: float64(shareU64), Member: login})
-		if len(z) == 0 {
-			tx.Expire(ctx, r.formatKey("submit", jobHash), expireDuration)
-			tx.Expire(ctx, r.formatKey("mini", jobHash), expireDuration)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		util.Error.Println("get miners unpaid error", err)
+		return nil
+	}
+	var miners []MinerPayout
+	for i, login := range logins {
+		vals, err := accountCmds[i].Result()
+		if err != nil || vals[0] == nil {
+			util.Error.Println("get miner unpaid error", login, err)
+			continue
 		}
-		_, err := tx.Exec(ctx)
+		unpaid, err := strconv.ParseInt(parseString(vals[0]), 10, 64)
 		if err != nil {
-			util.Error.Println("store submitted min share error", err)
+			util.Error.Println("get miner unpaid error", login, err)
+			continue
 		}
-		r.client.ZRemRangeByRank(ctx, r.formatKey("mini", jobHash), 1, -1).Result() // delete bigger hash, remain min hash and its miner address
-
-		return true
-	}
-	return false
-}
-
-func (r *KvClient) IsPoolShare(jobHash, share string) bool {
-	ok, err := r.client.SIsMember(ctx, r.formatKey("submit", jobHash), share).Result()
-	if err != nil {
-		util.Error.Println("check pool submitted share error", err)
-		return false
+		m, err := settingsCmds[i].Result()
+		if err != nil {
+			util.Error.Println("get miner settings error", login, err)
+			continue
+		}
+		settings := parseMinerSettings(m)
+		if pool.Amount(unpaid) <= settings.EffectiveThreshold(threshold, minThreshold) {
+			continue
+		}
+		payout := MinerPayout{Login: login, Address: login, Unpaid: pool.Amount(unpaid), PaidAt: parseInt64(vals[1])}
+		if settings != nil && settings.PayoutAddress != "" {
+			payout.Address = settings.PayoutAddress
+		}
+		miners = append(miners, payout)
 	}
-	return ok
+	return miners
 }
 
-// get all miners and their unpaid amount  which unpaid amount bigger than threshold
-func (r *KvClient) GetMinersToPay(threshold int64) map[string]int64 {
-	miners := make(map[string]int64)
-	thresholdInt := threshold * 1e9
-	iter := r.client.Scan(ctx, 0, r.formatKey("account*"), 0).Iterator()
+// rebuild unpaid index from miner accounts, for data written before the index existed
+func (r *KvClient) IndexUnpaid() (int64, error) {
+	prefix := r.formatKey("account", "")
+	var total int64
+	iter := r.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
 	for iter.Next(ctx) {
-		address := iter.Val()
 		unpaid, err := r.client.HGet(ctx, iter.Val(), "unpaid").Int64()
-		if err == nil {
-			if unpaid > thresholdInt {
-				miners[address[13:]] = unpaid
-			}
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+		login := strings.TrimPrefix(iter.Val(), prefix)
+		if unpaid <= 0 {
+			err = r.client.ZRem(ctx, r.formatKey("unpaid"), login).Err()
 		} else {
-			util.Error.Println("iter miner unpaid error", address, err)
+			err = r.client.ZAdd(ctx, r.formatKey("unpaid"), redis.Z{Score: float64(unpaid), Member: login}).Err()
+			total++
+		}
+		if err != nil {
+			return total, err
 		}
-
-	}
-	if err := iter.Err(); err != nil {
-		util.Error.Println("scan miner unpaid error", err)
-		return nil
 	}
-	return miners
+	return total, iter.Err()
 }
 
-// get all miners and their diff proportion  which participated in a job
-func (r *KvClient) GetProportion(jobHash string) map[string]float64 {
-	miners := make(map[string]float64)
+// get all miners diff and pool diff of a job
+func (r *KvClient) GetJobDiffs(jobHash string) (map[string]int64, int64) {
 	poolDiff, _ := r.client.HGet(ctx, r.formatKey("pool", jobHash), "diff").Int64()
-	fields, err := r.client.HKeys(ctx, r.formatKey("job", jobHash)).Result()
+	raw, err := r.client.HGetAll(ctx, r.formatKey("job", jobHash)).Result()
 	if err != nil {
 		util.Error.Println("get miners diff error", err)
-		return nil
+		return nil, 0
 	}
-	for _, address := range fields {
-		diff, _ := r.client.HGet(ctx, r.formatKey("job", jobHash), address).Int64()
-		miners[address] = float64(diff) / float64(poolDiff)
+	miners := make(map[string]int64, len(raw))
+	for address, v := range raw {
+		diff, _ := strconv.ParseInt(v, 10, 64)
+		miners[address] = diff
 	}
-	return miners
+	return miners, poolDiff
 }
 
 // get all miners addresses which participated in a job and max diff miner
@@ -356,8 +388,8 @@ func (r *KvClient) GetMinerName(jobHash string) []string {
 }
 
 // set lowest hash finder reward of a job
-func (r *KvClient) SetFinderReward(login string, reward pool.XdagjReward, fee float64, ms, ts int64) {
-	if fee*float64(1e9) < 1.0 {
+func (r *KvClient) SetFinderReward(login string, reward pool.XdagjReward, fee pool.Amount, ms, ts int64) {
+	if fee <= 0 {
 		return
 	}
 	// minimum hash finder
@@ -393,19 +425,13 @@ func (r *KvClient) SetFinderReward(login string, reward pool.XdagjReward, fee fl
 // 	}
 // }
 
-func (r *KvClient) DivideEqual(login string, reward pool.XdagjReward, fee, amount float64, ms, ts int64) {
-	miners := r.GetProportion(reward.PreHash)
-	if len(miners) == 0 {
+func (r *KvClient) DivideEqual(login string, reward pool.XdagjReward, fee, amount pool.Amount, ms, ts int64) {
+	diffs, _ := r.GetJobDiffs(reward.PreHash)
+	if len(diffs) == 0 {
 		util.Error.Println("equal direct reward miners count is 0", reward.PreHash)
 		return
 	}
-	var directPerMiner float64
-	if fee > 0 {
-		directPerMiner = fee / float64(len(miners))
-	}
-
-	for miner, ratio := range miners {
-		part := ratio*amount + directPerMiner
+	for miner, part := range equalParts(diffs, fee, amount) {
 		err := r.SetMinerReward(miner, reward.TxBlock, reward.PreHash, part, ms, ts)
 		if err != nil {
 			util.Error.Println("store equal direct reward error", reward.PreHash, miner, part, err)
@@ -414,6 +440,51 @@ func (r *KvClient) DivideEqual(login string, reward pool.XdagjReward, fee, amoun
 	}
 }
 
+// amount split by diffs, fee split equally
+func equalParts(diffs map[string]int64, fee, amount pool.Amount) map[string]pool.Amount {
+	parts := SplitAmount(amount, diffs)
+	if fee > 0 {
+		equal := make(map[string]int64, len(diffs))
+		for miner := range diffs {
+			equal[miner] = 1
+		}
+		for miner, part := range SplitAmount(fee, equal) {
+			parts[miner] += part
+		}
+	}
+	return parts
+}
+
+// split amount by weights rounding down, the remainder goes to the largest weight
+// (smallest address on tie) so that parts always sum to amount
+func SplitAmount(amount pool.Amount, weights map[string]int64) map[string]pool.Amount {
+	var total int64
+	var top string
+	for k, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		total += w
+		if top == "" || w > weights[top] || w == weights[top] && k < top {
+			top = k
+		}
+	}
+	parts := make(map[string]pool.Amount, len(weights))
+	if total == 0 {
+		return parts
+	}
+	var sum pool.Amount
+	for k, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		parts[k] = amount.MulDiv(w, total)
+		sum += parts[k]
+	}
+	parts[top] += amount - sum
+	return parts
+}
+
 func (r *KvClient) formatKey(args ...interface{}) string {
 	return join(r.prefix, join(args...))
 }
@@ -430,6 +501,8 @@ func join(args ...interface{}) string {
 			s[i] = strconv.FormatUint(x, 10)
 		case float64:
 			s[i] = strconv.FormatFloat(x, 'f', 9, 64)
+		case pool.Amount:
+			s[i] = x.String()
 		case bool:
 			if x {
 				s[i] = "1"
@@ -463,6 +536,12 @@ func (r *KvClient) PurgeRecords(window time.Duration) (int64, error) {
 	}
 	total += n
 
+	n, err = r.purgeBlocks(max)
+	if err != nil {
+		return total, err
+	}
+	total += n
+
 	n, err = r.client.ZRemRangeByScore(ctx, r.formatKey("rejecthashrate"), "-inf", max).Result()
 	if err != nil {
 		return total, err
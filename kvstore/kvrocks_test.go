@@ -0,0 +1,45 @@
+package kvstore
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/XDagger/xdagpool/pool"
+)
+
+func TestSplitAmount(t *testing.T) {
+	parts := SplitAmount(10, map[string]int64{"a": 1, "b": 1, "c": 1})
+	if parts["a"] != 4 || parts["b"] != 3 || parts["c"] != 3 {
+		t.Fatalf("unexpected split %v", parts)
+	}
+	parts = SplitAmount(100*pool.XDAG, map[string]int64{"a": 7, "b": 3, "c": 0})
+	if parts["a"] != 70*pool.XDAG || parts["b"] != 30*pool.XDAG || len(parts) != 2 {
+		t.Fatalf("unexpected split %v", parts)
+	}
+}
+
+func TestDivideEqualExact(t *testing.T) {
+	r, mr := newTestClient(t)
+	for i, login := range []string{"a", "b", "c"} {
+		_, err := r.WriteBlock(login, "0", "equal"+strconv.Itoa(i), 100, 0, 0, "job")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 64 xdag less 5% pool fee does not divide by 3
+	amount := 64 * pool.XDAG
+	amount -= amount.Percent(5)
+	r.DivideEqual("a", pool.XdagjReward{TxBlock: "tx", PreHash: "job"}, pool.Nano, amount, 1000, 1)
+	var sum int64
+	for _, login := range []string{"a", "b", "c"} {
+		reward, _ := strconv.ParseInt(mr.HGet("xdag:account:"+login, "reward"), 10, 64)
+		sum += reward
+	}
+	if sum != int64(amount+pool.Nano) {
+		t.Fatalf("sum of rewards %d, want %d", sum, amount+pool.Nano)
+	}
+	if reward := mr.HGet("xdag:account:b", "reward"); reward != "20266666666" {
+		t.Fatalf("reward of b %s, want 20266666666", reward)
+	}
+}
@@ -0,0 +1,74 @@
+package kvstore
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/redis/go-redis/v9"
+)
+
+const maxPayoutReports = 1000
+
+// summary of a payout run
+type PayoutReport struct {
+	Timestamp      int64       `json:"timestamp"`
+	Miners         int64       `json:"miners"` // miners paid
+	Paid           pool.Amount `json:"paid"`
+	Fees           pool.Amount `json:"fees"` // transaction fees
+	Txs            []string    `json:"txs"`
+	Deferred       int64       `json:"deferred"` // miners due but left for next run
+	DeferredAmount pool.Amount `json:"deferredAmount"`
+	Stopped        string      `json:"stopped"` // why due miners were deferred
+}
+
+func (r *KvClient) SavePayoutReport(p *PayoutReport) error {
+	tx := r.client.TxPipeline()
+	tx.ZAdd(ctx, r.formatKey("payouts", "runs"), redis.Z{Score: float64(p.Timestamp),
+		Member: payoutReportMember(p)})
+	tx.ZRemRangeByRank(ctx, r.formatKey("payouts", "runs"), 0, -maxPayoutReports-1)
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+// latest n payout reports, newest first
+func (r *KvClient) PayoutReports(n int64) ([]PayoutReport, error) {
+	val, err := r.client.ZRevRange(ctx, r.formatKey("payouts", "runs"), 0, n-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := []PayoutReport{}
+	for _, v := range val {
+		p, err := convertPayoutReport(v)
+		if err != nil {
+			continue
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+func payoutReportMember(p *PayoutReport) string {
+	return join(p.Timestamp, p.Miners, p.Paid, p.Fees, p.Deferred, p.DeferredAmount, strings.Join(p.Txs, ","), p.Stopped)
+}
+
+func convertPayoutReport(member string) (PayoutReport, error) {
+	var p PayoutReport
+	fields := strings.SplitN(member, ":", 8)
+	if len(fields) != 8 {
+		return p, errors.New("payout report data format error")
+	}
+	p.Timestamp, _ = strconv.ParseInt(fields[0], 10, 64)
+	p.Miners, _ = strconv.ParseInt(fields[1], 10, 64)
+	p.Paid, _ = pool.ParseAmount(fields[2])
+	p.Fees, _ = pool.ParseAmount(fields[3])
+	p.Deferred, _ = strconv.ParseInt(fields[4], 10, 64)
+	p.DeferredAmount, _ = pool.ParseAmount(fields[5])
+	p.Txs = []string{}
+	if fields[6] != "" {
+		p.Txs = strings.Split(fields[6], ",")
+	}
+	p.Stopped = fields[7]
+	return p, nil
+}
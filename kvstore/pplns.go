@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultPplnsShares = 10000
+
+// keep the last n shares for pplns, 0 disables the share window
+func (r *KvClient) SetPplnsShares(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	r.pplnsShares = n
+}
+
+// pplns shares of mode with config, default window if neither shares nor time window is set
+func PplnsShares(cfg *pool.PayOutConfig) int64 {
+	if cfg.Mode != "pplns" {
+		return 0
+	}
+	if cfg.PplnsShares > 0 {
+		return cfg.PplnsShares
+	}
+	return defaultPplnsShares
+}
+
+// append a share to pplns window in the transaction of WriteBlock
+func (r *KvClient) writePplnsShare(tx redis.Pipeliner, login string, diff, ms int64) {
+	if r.pplnsShares <= 0 {
+		return
+	}
+	tx.LPush(ctx, r.formatKey("pplns"), join(diff, login, ms))
+	tx.LTrim(ctx, r.formatKey("pplns"), 0, r.pplnsShares-1)
+}
+
+// difficulty proportion of miners in the last n shares submitted within window (0 for no time limit)
+func (r *KvClient) GetPplnsProportion(n int64, window time.Duration) map[string]float64 {
+	return pplnsProportion(r.pplnsDiffs(n, window))
+}
+
+func pplnsProportion(diffs map[string]int64, total int64) map[string]float64 {
+	if diffs == nil {
+		return nil
+	}
+	miners := make(map[string]float64)
+	for address, diff := range diffs {
+		miners[address] = float64(diff) / float64(total)
+	}
+	return miners
+}
+
+// difficulty sum of miners in the last n shares submitted within window
+func (r *KvClient) pplnsDiffs(n int64, window time.Duration) (map[string]int64, int64) {
+	if n <= 0 {
+		n = r.pplnsShares
+	}
+	raw, err := r.client.LRange(ctx, r.formatKey("pplns"), 0, n-1).Result()
+	if err != nil {
+		util.Error.Println("get pplns shares error", err)
+		return nil, 0
+	}
+	return sumPplnsDiffs(raw, window)
+}
+
+// difficulty sum of miners in pplns share records newer than window
+func sumPplnsDiffs(raw []string, window time.Duration) (map[string]int64, int64) {
+	var since int64
+	if window > 0 {
+		since = util.MakeTimestamp() - int64(window/time.Millisecond)
+	}
+
+	var total int64
+	diffs := make(map[string]int64)
+	for _, v := range raw {
+		fields := strings.Split(v, ":")
+		if len(fields) != 3 {
+			continue
+		}
+		diff, _ := strconv.ParseInt(fields[0], 10, 64)
+		ms, _ := strconv.ParseInt(fields[2], 10, 64)
+		if diff <= 0 || ms < since {
+			continue
+		}
+		diffs[fields[1]] += diff
+		total += diff
+	}
+	return diffs, total
+}
+
+// divide reward amount by difficulty of the last n shares
+func (r *KvClient) DividePplns(reward pool.XdagjReward, amount pool.Amount, n int64, window time.Duration, ms, ts int64) {
+	diffs, _ := r.pplnsDiffs(n, window)
+	if len(diffs) == 0 {
+		util.Error.Println("pplns reward miners count is 0", reward.PreHash)
+		return
+	}
+	for miner, part := range SplitAmount(amount, diffs) {
+		err := r.SetMinerReward(miner, reward.TxBlock, reward.PreHash, part, ms, ts)
+		if err != nil {
+			util.Error.Println("store pplns reward error", reward.PreHash, miner, part, err)
+			continue
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package kvstore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMain(m *testing.M) {
+	util.InitLog(os.DevNull, os.DevNull, os.DevNull, os.DevNull, 40)
+	util.NewMinedShares()
+	os.Exit(m.Run())
+}
+
+func newTestClient(t *testing.T) (*KvClient, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	return NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag"), mr
+}
+
+func TestPplnsWindow(t *testing.T) {
+	r, mr := newTestClient(t)
+	r.SetPplnsShares(3)
+
+	shares := []struct {
+		login string
+		diff  int64
+	}{{"a", 100}, {"b", 100}, {"a", 200}, {"b", 100}}
+	for i, s := range shares {
+		_, err := r.WriteBlock(s.login, "0", string(rune('0'+i)), s.diff, 0, 0, "job")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// first share is out of window
+	miners := r.GetPplnsProportion(0, time.Hour)
+	if len(miners) != 2 || miners["a"] != 0.5 || miners["b"] != 0.5 {
+		t.Fatalf("unexpected pplns proportion %v", miners)
+	}
+
+	r.DividePplns(pool.XdagjReward{TxBlock: "tx", PreHash: "job"}, 10*pool.XDAG, 0, 0, 1000, 1)
+	if reward := mr.HGet("xdag:account:a", "reward"); reward != "5000000000" {
+		t.Errorf("reward of a %s, want 5000000000", reward)
+	}
+	if reward := mr.HGet("xdag:account:b", "reward"); reward != "5000000000" {
+		t.Errorf("reward of b %s, want 5000000000", reward)
+	}
+}
@@ -0,0 +1,108 @@
+package kvstore
+
+import (
+	"strconv"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/redis/go-redis/v9"
+)
+
+const ppsFeeWeight = 10 // weight percent of latest block fee in fpps average
+
+// credit a pps share to miner unless pool buffer (reserve + block rewards - credited) is negative,
+// concurrent shares are checked and debited one at a time.
+// KEYS: pps, account, unpaid; ARGV: reserve, amount, login
+var ppsCreditScript = redis.NewScript(`
+local v = redis.call('HMGET', KEYS[1], 'rewards', 'credited')
+if tonumber(ARGV[1]) + (tonumber(v[1]) or 0) - (tonumber(v[2]) or 0) < 0 then
+	redis.call('HINCRBY', KEYS[1], 'skipped', 1)
+	return 0
+end
+redis.call('HINCRBY', KEYS[2], 'reward', ARGV[2])
+redis.call('HINCRBY', KEYS[2], 'unpaid', ARGV[2])
+redis.call('HINCRBY', KEYS[2], 'pps', ARGV[2])
+redis.call('ZINCRBY', KEYS[3], ARGV[2], ARGV[3])
+redis.call('HINCRBY', KEYS[1], 'credited', ARGV[2])
+redis.call('HINCRBY', KEYS[1], 'shares', 1)
+return 1
+`)
+
+func (r *KvClient) CreditPpsShare(login string, amount, reserve pool.Amount) (bool, error) {
+	keys := []string{r.formatKey("pps"), r.formatKey("account", login), r.formatKey("unpaid")}
+	ok, err := ppsCreditScript.Run(ctx, r.client, keys, int64(reserve), int64(amount), login).Int()
+	return ok == 1, err
+}
+
+// won block in pps mode funds pool buffer instead of pool account, miners are credited per share.
+// fee is averaged for fpps
+func (r *KvClient) SetPpsWinReward(login string, reward pool.XdagjReward, ms, ts int64) error {
+	avgFee, err := r.PpsAvgFee()
+	if err != nil {
+		return err
+	}
+	tx := r.client.TxPipeline()
+	tx.HIncrBy(ctx, r.formatKey("pps"), "rewards", int64(reward.Amount))
+	tx.HIncrBy(ctx, r.formatKey("pps"), "blocks", 1)
+	tx.HSet(ctx, r.formatKey("pps"), "avgFee", ppsAvgFee(avgFee, reward.Fee).String())
+	r.winBlock(tx, login, reward, ms, ts)
+	_, err = tx.Exec(ctx)
+	return err
+}
+
+// moving average of block fees
+func ppsAvgFee(avgFee, fee pool.Amount) pool.Amount {
+	if avgFee == 0 {
+		return fee
+	}
+	return avgFee - avgFee.MulDiv(ppsFeeWeight, 100) + fee.MulDiv(ppsFeeWeight, 100)
+}
+
+func (r *KvClient) PpsAvgFee() (pool.Amount, error) {
+	avgFee, err := r.client.HGet(ctx, r.formatKey("pps"), "avgFee").Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return pool.ParseAmount(avgFee)
+}
+
+// pool liability of pps credited shares against block rewards
+func (r *KvClient) GetPpsStats(reserve pool.Amount) (map[string]interface{}, error) {
+	vals, err := r.client.HMGet(ctx, r.formatKey("pps"), ppsStatsFields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return ppsStats(reserve, vals), nil
+}
+
+var ppsStatsFields = []string{"rewards", "credited", "shares", "skipped", "blocks", "avgFee"}
+
+func ppsStats(reserve pool.Amount, vals []interface{}) map[string]interface{} {
+	rewards := pool.Amount(parseInt64(vals[0]))
+	credited := pool.Amount(parseInt64(vals[1]))
+	avgFee, _ := pool.ParseAmount(parseString(vals[5]))
+	buffer := reserve + rewards - credited
+	return map[string]interface{}{
+		"reserve":   reserve,
+		"rewards":   rewards,
+		"credited":  credited,
+		"buffer":    buffer,
+		"suspended": buffer < 0,
+		"shares":    parseInt64(vals[2]),
+		"skipped":   parseInt64(vals[3]),
+		"blocks":    parseInt64(vals[4]),
+		"avgFee":    avgFee,
+	}
+}
+
+func parseString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func parseInt64(v interface{}) int64 {
+	n, _ := strconv.ParseInt(parseString(v), 10, 64)
+	return n
+}
@@ -1,8 +1,13 @@
 package kvstore
 
-import "github.com/XDagger/xdagpool/util"
+import (
+	"strings"
 
-func (r *KvClient) GetTotalDonate() (float64, int64, error) {
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+func (r *KvClient) GetTotalDonate() (pool.Amount, int64, error) {
 	val, err := r.client.HGet(ctx, r.formatKey("pool", "account"), "donate").Int64()
 	if err != nil {
 		util.Error.Println("get pool total donate error", err)
@@ -11,9 +16,9 @@ func (r *KvClient) GetTotalDonate() (float64, int64, error) {
 	count, err := r.client.ZCard(ctx, r.formatKey("pool", "donate")).Result()
 	if err != nil {
 		util.Error.Println("get pool donate count error", err)
-		return float64(val) / 1e9, 0, err
+		return pool.Amount(val), 0, err
 	}
-	return float64(val) / 1e9, count, nil
+	return pool.Amount(val), count, nil
 }
 
 func (r *KvClient) GetDonateList(start, end int64) ([]DonateData, error) {
@@ -33,7 +38,7 @@ func (r *KvClient) GetDonateList(start, end int64) ([]DonateData, error) {
 	return list, nil
 }
 
-func (r *KvClient) GetPoolAccount() (float64, float64, float64, float64, error) {
+func (r *KvClient) GetPoolAccount() (pool.Amount, pool.Amount, pool.Amount, pool.Amount, error) {
 	donate, err := r.client.HGet(ctx, r.formatKey("pool", "account"), "donate").Int64()
 	if err != nil {
 		util.Error.Println("get pool total donate error", err)
@@ -54,10 +59,10 @@ func (r *KvClient) GetPoolAccount() (float64, float64, float64, float64, error)
 		util.Error.Println("get pool total unpaid error", err)
 		return 0, 0, 0, 0, err
 	}
-	return float64(rewards) / 1e9, float64(payment) / 1e9, float64(unpaid) / 1e9, float64(donate) / 1e9, nil
+	return pool.Amount(rewards), pool.Amount(payment), pool.Amount(unpaid), pool.Amount(donate), nil
 }
 
-func (r *KvClient) GetTotalPoolRewards() (float64, int64, error) {
+func (r *KvClient) GetTotalPoolRewards() (pool.Amount, int64, error) {
 	val, err := r.client.HGet(ctx, r.formatKey("pool", "account"), "rewards").Int64()
 	if err != nil {
 		util.Error.Println("get pool total rewards error", err)
@@ -66,9 +71,9 @@ func (r *KvClient) GetTotalPoolRewards() (float64, int64, error) {
 	count, err := r.client.ZCard(ctx, r.formatKey("pool", "rewards")).Result()
 	if err != nil {
 		util.Error.Println("get pool rewards count error", err)
-		return float64(val) / 1e9, 0, err
+		return pool.Amount(val), 0, err
 	}
-	return float64(val) / 1e9, count, nil
+	return pool.Amount(val), count, nil
 }
 
 func (r *KvClient) GetPoolRewardsList(start, end int64) ([]PoolRewardsData, error) {
@@ -88,7 +93,7 @@ func (r *KvClient) GetPoolRewardsList(start, end int64) ([]PoolRewardsData, erro
 	return list, nil
 }
 
-func (r *KvClient) GetMinerAccount(address string) (float64, float64, float64, error) {
+func (r *KvClient) GetMinerAccount(address string) (pool.Amount, pool.Amount, pool.Amount, error) {
 	reward, err := r.client.HGet(ctx, r.formatKey("account", address), "reward").Int64()
 	if err != nil {
 		util.Error.Println("get miner total reward error", err, address)
@@ -98,28 +103,28 @@ func (r *KvClient) GetMinerAccount(address string) (float64, float64, float64, e
 	unpaid, err := r.client.HGet(ctx, r.formatKey("account", address), "unpaid").Int64()
 	if err != nil {
 		util.Error.Println("get miner total unpaid error", err, address)
-		return float64(reward) / 1e9, 0, 0, nil
+		return pool.Amount(reward), 0, 0, nil
 	}
 
 	payment, err := r.client.HGet(ctx, r.formatKey("account", address), "payment").Int64()
 	if err != nil {
 		util.Error.Println("get miner total payment error", err, address)
-		return float64(reward) / 1e9, 0, float64(unpaid) / 1e9, nil
+		return pool.Amount(reward), 0, pool.Amount(unpaid), nil
 	}
-	return float64(reward) / 1e9, float64(payment) / 1e9, float64(unpaid) / 1e9, nil
+	return pool.Amount(reward), pool.Amount(payment), pool.Amount(unpaid), nil
 }
 
-func (r *KvClient) GetMinerUnpaid(address string) float64 {
+func (r *KvClient) GetMinerUnpaid(address string) pool.Amount {
 
 	unpaid, err := r.client.HGet(ctx, r.formatKey("account", address), "unpaid").Int64()
 	if err != nil {
 		util.Error.Println("get pool total unpaid error", err)
 		return 0
 	}
-	return float64(unpaid) / 1e9
+	return pool.Amount(unpaid)
 }
 
-func (r *KvClient) MinerTotalRewards(address string) (float64, int64, error) {
+func (r *KvClient) MinerTotalRewards(address string) (pool.Amount, int64, error) {
 	rewards, err := r.client.HGet(ctx, r.formatKey("account", address), "reward").Int64()
 	if err != nil {
 		util.Error.Println("get miner total rewards error", err)
@@ -128,9 +133,9 @@ func (r *KvClient) MinerTotalRewards(address string) (float64, int64, error) {
 	count, err := r.client.ZCard(ctx, r.formatKey("rewards", address)).Result()
 	if err != nil {
 		util.Error.Println("get miner rewards count error", err)
-		return float64(rewards) / 1e9, 0, err
+		return pool.Amount(rewards), 0, err
 	}
-	return float64(rewards) / 1e9, count, nil
+	return pool.Amount(rewards), count, nil
 }
 
 func (r *KvClient) MinerRewardsList(address string, start, end int64) ([]MinerRewardsData, error) {
@@ -150,7 +155,7 @@ func (r *KvClient) MinerRewardsList(address string, start, end int64) ([]MinerRe
 	return list, nil
 }
 
-func (r *KvClient) MinerTotalPayment(address string) (float64, int64, error) {
+func (r *KvClient) MinerTotalPayment(address string) (pool.Amount, int64, error) {
 	payment, err := r.client.HGet(ctx, r.formatKey("account", address), "payment").Int64()
 	if err != nil {
 		util.Error.Println("get miner total payment error", err)
@@ -159,9 +164,9 @@ func (r *KvClient) MinerTotalPayment(address string) (float64, int64, error) {
 	count, err := r.client.ZCard(ctx, r.formatKey("payment", address)).Result()
 	if err != nil {
 		util.Error.Println("get miner payment count error", err)
-		return float64(payment) / 1e9, 0, err
+		return pool.Amount(payment), 0, err
 	}
-	return float64(payment) / 1e9, count, nil
+	return pool.Amount(payment), count, nil
 }
 
 func (r *KvClient) MinerPaymentList(address string, start, end int64) ([]MinerPaymentData, error) {
@@ -178,6 +183,13 @@ func (r *KvClient) MinerPaymentList(address string, start, end int64) ([]MinerPa
 		}
 		list = append(list, d)
 	}
+	hashes := make([]string, len(list))
+	for i := range list {
+		hashes[i] = list[i].TxBlock
+	}
+	for i, status := range r.paymentStatus(hashes) {
+		list[i].Status = status
+	}
 	return list, nil
 }
 
@@ -202,5 +214,71 @@ func (r *KvClient) MinerBalanceList(address string, start, end int64) (int64, []
 		}
 		list = append(list, d)
 	}
+	var hashes []string
+	var index []int
+	for i := range list {
+		if list[i].Action == "payment" {
+			hashes = append(hashes, list[i].TxBlock)
+			index = append(index, i)
+		}
+	}
+	for i, status := range r.paymentStatus(hashes) {
+		list[index[i]].Status = status
+	}
 	return count, list, nil
 }
+
+// pool aggregate and sum of miner accounts, amounts should agree
+type Ledger struct {
+	Rewards      pool.Amount
+	Payment      pool.Amount
+	Unpaid       pool.Amount
+	Fees         pool.Amount // transaction fees of payments
+	PpsRewards   pool.Amount // block rewards funding pps buffer
+	MinerRewards pool.Amount
+	MinerPayment pool.Amount
+	MinerUnpaid  pool.Amount
+	Accounts     int
+	Mismatched   []string // accounts whose unpaid is not reward - payment
+}
+
+func (r *KvClient) GetLedger() (*Ledger, error) {
+	vals, err := r.client.HMGet(ctx, r.formatKey("pool", "account"), "rewards", "payment", "unpaid", "fees").Result()
+	if err != nil {
+		return nil, err
+	}
+	l := &Ledger{
+		Rewards: pool.Amount(parseInt64(vals[0])),
+		Payment: pool.Amount(parseInt64(vals[1])),
+		Unpaid:  pool.Amount(parseInt64(vals[2])),
+		Fees:    pool.Amount(parseInt64(vals[3])),
+	}
+	pps, err := r.client.HMGet(ctx, r.formatKey("pps"), "rewards").Result()
+	if err != nil {
+		return nil, err
+	}
+	l.PpsRewards = pool.Amount(parseInt64(pps[0]))
+
+	iter := r.client.Scan(ctx, 0, r.formatKey("account", "*"), 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		vals, err := r.client.HMGet(ctx, key, "reward", "payment", "unpaid").Result()
+		if err != nil {
+			return nil, err
+		}
+		reward := pool.Amount(parseInt64(vals[0]))
+		payment := pool.Amount(parseInt64(vals[1]))
+		unpaid := pool.Amount(parseInt64(vals[2]))
+		l.MinerRewards += reward
+		l.MinerPayment += payment
+		l.MinerUnpaid += unpaid
+		l.Accounts++
+		if unpaid != reward-payment {
+			l.Mismatched = append(l.Mismatched, strings.TrimPrefix(key, r.formatKey("account", "")))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,157 @@
+package kvstore
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XDagger/xdagpool/util"
+)
+
+// kind of submitted share difficulty in share series
+const (
+	ShareAccepted = "accepted"
+	ShareStale    = "stale"
+	ShareInvalid  = "invalid"
+)
+
+const SeriesPool = "pool"
+
+// downsampled share difficulty of pool, miners and workers, hash "series:<resolution>:<series>"
+// with field "<bucket ts>:<kind>" for every bucket within retention
+type Resolution struct {
+	Name      string
+	Bucket    time.Duration
+	Retention time.Duration
+}
+
+var Resolutions = []Resolution{
+	{Name: "5m", Bucket: 5 * time.Minute, Retention: 24 * time.Hour},
+	{Name: "1h", Bucket: time.Hour, Retention: 30 * 24 * time.Hour},
+}
+
+func FindResolution(name string) (Resolution, error) {
+	for _, res := range Resolutions {
+		if res.Name == name {
+			return res, nil
+		}
+	}
+	return Resolution{}, errors.New("unknown series resolution " + name)
+}
+
+func MinerSeries(login string) string {
+	return join("miner", login)
+}
+
+func WorkerSeries(login, id string) string {
+	return join("worker", login+"."+id)
+}
+
+// start of bucket holding ts in seconds
+func (res Resolution) bucket(ts int64) int64 {
+	size := int64(res.Bucket / time.Second)
+	return ts - ts%size
+}
+
+func seriesField(bucket int64, kind string) string {
+	return join(bucket, kind)
+}
+
+// bucket ts of a series field
+func fieldBucket(field string) (int64, error) {
+	return strconv.ParseInt(strings.SplitN(field, ":", 2)[0], 10, 64)
+}
+
+// chart point of a series bucket, difficulty sums and accepted difficulty per second
+type HashratePoint struct {
+	Timestamp int64   `json:"timestamp"` // bucket start in seconds
+	Accepted  int64   `json:"accepted"`
+	Stale     int64   `json:"stale"`
+	Invalid   int64   `json:"invalid"`
+	Hashrate  float64 `json:"hashrate"`
+}
+
+// every bucket within retention up to now, oldest first, empty buckets are zero
+func buildSeries(fields map[string]string, res Resolution, now int64) []HashratePoint {
+	size := int64(res.Bucket / time.Second)
+	last := res.bucket(now)
+	first := res.bucket(now - int64(res.Retention/time.Second) + size)
+	points := make([]HashratePoint, 0, (last-first)/size+1)
+	for b := first; b <= last; b += size {
+		p := HashratePoint{Timestamp: b}
+		p.Accepted, _ = strconv.ParseInt(fields[seriesField(b, ShareAccepted)], 10, 64)
+		p.Stale, _ = strconv.ParseInt(fields[seriesField(b, ShareStale)], 10, 64)
+		p.Invalid, _ = strconv.ParseInt(fields[seriesField(b, ShareInvalid)], 10, 64)
+		elapsed := size
+		if b == last {
+			elapsed = now - b + 1 // current bucket is partial
+		}
+		p.Hashrate = float64(p.Accepted) / float64(elapsed)
+		points = append(points, p)
+	}
+	return points
+}
+
+// fields of buckets out of retention
+func staleFields(fields []string, res Resolution, now int64) []string {
+	min := res.bucket(now - int64(res.Retention/time.Second))
+	var stale []string
+	for _, f := range fields {
+		if b, err := fieldBucket(f); err != nil || b < min {
+			stale = append(stale, f)
+		}
+	}
+	return stale
+}
+
+// add share difficulty to pool, miner and worker series of every resolution
+func (r *KvClient) WriteShareStats(login, id, kind string, diff, ts int64) error {
+	tx := r.client.Pipeline()
+	for _, res := range Resolutions {
+		field := seriesField(res.bucket(ts), kind)
+		for _, series := range []string{SeriesPool, MinerSeries(login), WorkerSeries(login, id)} {
+			key := r.formatKey("series", res.Name, series)
+			tx.HIncrBy(ctx, key, field, diff)
+			tx.Expire(ctx, key, res.Retention) // series of gone miners expire
+		}
+	}
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+func (r *KvClient) ShareSeries(series string, res Resolution) ([]HashratePoint, error) {
+	fields, err := r.client.HGetAll(ctx, r.formatKey("series", res.Name, series)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return buildSeries(fields, res, util.MakeTimestamp()/1000), nil
+}
+
+// delete series buckets out of retention
+func (r *KvClient) PurgeShareStats() (int64, error) {
+	now := util.MakeTimestamp() / 1000
+	var total int64
+	for _, res := range Resolutions {
+		iter := r.client.Scan(ctx, 0, r.formatKey("series", res.Name, "*"), 100).Iterator()
+		for iter.Next(ctx) {
+			fields, err := r.client.HKeys(ctx, iter.Val()).Result()
+			if err != nil {
+				return total, err
+			}
+			stale := staleFields(fields, res, now)
+			if len(stale) == 0 {
+				continue
+			}
+			n, err := r.client.HDel(ctx, iter.Val(), stale...).Result()
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+		if err := iter.Err(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,102 @@
+package kvstore
+
+import (
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// payout settings of a miner, signed by the key of its mining address
+type MinerSettings struct {
+	Threshold     pool.Amount `json:"threshold"`     // 0 for pool default
+	PayoutAddress string      `json:"payoutAddress"` // empty for mining address
+	Timestamp     int64       `json:"timestamp"`     // signed time in seconds, increases with every update
+}
+
+// miner unpaid balance due for payout
+type MinerPayout struct {
+	Login   string      `json:"login"`
+	Address string      `json:"address"` // payout address
+	Unpaid  pool.Amount `json:"unpaid"`
+	PaidAt  int64       `json:"paidAt"` // last payment in ms, 0 if never paid
+}
+
+func (r *KvClient) SetMinerSettings(login string, s *MinerSettings) error {
+	return r.client.HSet(ctx, r.formatKey("settings", login),
+		"threshold", int64(s.Threshold),
+		"payoutAddress", s.PayoutAddress,
+		"timestamp", s.Timestamp).Err()
+}
+
+// settings of miner, nil if never set
+func (r *KvClient) GetMinerSettings(login string) (*MinerSettings, error) {
+	m, err := r.client.HGetAll(ctx, r.formatKey("settings", login)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseMinerSettings(m), nil
+}
+
+func parseMinerSettings(m map[string]string) *MinerSettings {
+	if len(m) == 0 {
+		return nil
+	}
+	return &MinerSettings{
+		Threshold:     pool.Amount(parseInt64(m["threshold"])),
+		PayoutAddress: m["payoutAddress"],
+		Timestamp:     parseInt64(m["timestamp"]),
+	}
+}
+
+// payout threshold of miner settings, never below pool minimum
+func (s *MinerSettings) EffectiveThreshold(threshold, minThreshold pool.Amount) pool.Amount {
+	if s != nil && s.Threshold > 0 {
+		threshold = s.Threshold
+	}
+	if threshold < minThreshold {
+		threshold = minThreshold
+	}
+	return threshold
+}
+
+// maintenance switch of payout runs
+type PayoutsState struct {
+	Paused   bool   `json:"paused"`
+	Reason   string `json:"reason"`
+	PausedAt int64  `json:"pausedAt"`
+}
+
+func (r *KvClient) PausePayouts(reason string) error {
+	return r.client.HSet(ctx, r.formatKey("payouts"),
+		"paused", 1,
+		"reason", reason,
+		"pausedAt", util.MakeTimestamp()/1000).Err()
+}
+
+func (r *KvClient) ResumePayouts() error {
+	return r.client.Del(ctx, r.formatKey("payouts")).Err()
+}
+
+func (r *KvClient) GetPayoutsState() (*PayoutsState, error) {
+	m, err := r.client.HGetAll(ctx, r.formatKey("payouts")).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parsePayoutsState(m), nil
+}
+
+func parsePayoutsState(m map[string]string) *PayoutsState {
+	return &PayoutsState{
+		Paused:   m["paused"] == "1",
+		Reason:   m["reason"],
+		PausedAt: parseInt64(m["pausedAt"]),
+	}
+}
+
+func (r *KvClient) PayoutsPaused() (bool, error) {
+	s, err := r.client.HGet(ctx, r.formatKey("payouts"), "paused").Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return s == "1", err
+}
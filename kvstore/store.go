@@ -0,0 +1,108 @@
+package kvstore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+)
+
+// storage engines of pool.StorageConfig
+const (
+	EngineKvrocks = "kvrocks" // redis protocol server, default
+	EngineBolt    = "bolt"    // embedded bbolt file
+)
+
+// Store keeps shares, rewards, payments and accounts of pool
+type Store interface {
+	Check() (string, error)
+	Close() error
+
+	// shares
+	WriteInvalidShare(ms, ts int64, login, id string, diff int64) error
+	WriteRejectShare(ms, ts int64, login, id string, diff int64) error
+	WriteBlock(login, id, share string, diff int64, shareU64 uint64, timestamp uint64, jobHash string) (bool, error)
+	// compare, store and trim min share of a job atomically
+	IsMinShare(jobHash, login, share string, shareU64 uint64) bool
+	IsPoolShare(jobHash, share string) bool
+	SetPplnsShares(n int64)
+	PurgeRecords(window time.Duration) (int64, error)
+
+	// rewards
+	SetMinerReward(login, txHash, jobHash string, reward pool.Amount, ms, ts int64) error
+	SetWinReward(login string, reward pool.XdagjReward, ms, ts int64) error
+	SetFinderReward(login string, reward pool.XdagjReward, fee pool.Amount, ms, ts int64)
+	GetJobDiffs(jobHash string) (map[string]int64, int64)
+	DivideEqual(login string, reward pool.XdagjReward, fee, amount pool.Amount, ms, ts int64)
+	GetPplnsProportion(n int64, window time.Duration) map[string]float64
+	DividePplns(reward pool.XdagjReward, amount pool.Amount, n int64, window time.Duration, ms, ts int64)
+	CreditPpsShare(login string, amount, reserve pool.Amount) (bool, error)
+	SetPpsWinReward(login string, reward pool.XdagjReward, ms, ts int64) error
+	PpsAvgFee() (pool.Amount, error)
+	GetPpsStats(reserve pool.Amount) (map[string]interface{}, error)
+
+	// found blocks
+	WriteCandidate(jobHash, login, share string, ms, ts int64) error
+	SetLostReward(login string, reward pool.XdagjReward, ms, ts int64) error
+	ExpireCandidates(rounds, ms int64) (int64, error)
+	GetBlocks(start, end int64) (int64, []BlockData, error)
+	BlocksSince(ts int64) ([]BlockData, error)
+
+	// share series
+	WriteShareStats(login, id, kind string, diff, ts int64) error
+	ShareSeries(series string, res Resolution) ([]HashratePoint, error)
+	PurgeShareStats() (int64, error)
+
+	// payments
+	SetPayment(login, txHash, remark string, payment pool.Amount, ms, ts int64) error
+	SetChunkPayment(j *PaymentJournal, ms, ts int64) error
+	GetMinersToPay(threshold, minThreshold pool.Amount) []MinerPayout
+	IndexUnpaid() (int64, error)
+	JournalPending(j *PaymentJournal) error
+	JournalBroadcast(txHash string) error
+	JournalAbort(txHash, reason string) error
+	GetJournal(txHash string) (*PaymentJournal, error)
+	OpenJournals() ([]*PaymentJournal, error)
+	UnconfirmedPayments() ([]*PaymentJournal, error)
+	ConfirmPayment(txHash string) error
+	FailPayment(j *PaymentJournal, reason string, ms, ts int64) error
+	SetMinerSettings(login string, s *MinerSettings) error
+	GetMinerSettings(login string) (*MinerSettings, error)
+	PausePayouts(reason string) error
+	ResumePayouts() error
+	GetPayoutsState() (*PayoutsState, error)
+	PayoutsPaused() (bool, error)
+	SavePayoutReport(p *PayoutReport) error
+	PayoutReports(n int64) ([]PayoutReport, error)
+
+	// queries
+	GetTotalDonate() (pool.Amount, int64, error)
+	GetDonateList(start, end int64) ([]DonateData, error)
+	GetPoolAccount() (pool.Amount, pool.Amount, pool.Amount, pool.Amount, error)
+	GetTotalPoolRewards() (pool.Amount, int64, error)
+	GetPoolRewardsList(start, end int64) ([]PoolRewardsData, error)
+	GetMinerAccount(address string) (pool.Amount, pool.Amount, pool.Amount, error)
+	GetMinerUnpaid(address string) pool.Amount
+	MinerTotalRewards(address string) (pool.Amount, int64, error)
+	MinerRewardsList(address string, start, end int64) ([]MinerRewardsData, error)
+	MinerTotalPayment(address string) (pool.Amount, int64, error)
+	MinerPaymentList(address string, start, end int64) ([]MinerPaymentData, error)
+	MinerBalanceList(address string, start, end int64) (int64, []MinerBalanceData, error)
+	GetLedger() (*Ledger, error)
+}
+
+var (
+	_ Store = (*KvClient)(nil)
+	_ Store = (*BoltStore)(nil)
+)
+
+// open store of configured engine
+func NewStore(cfg *pool.StorageConfig, prefix string) (Store, error) {
+	switch cfg.Engine {
+	case "", EngineKvrocks:
+		return NewKvClient(cfg, prefix), nil
+	case EngineBolt:
+		return NewBoltStore(cfg.Path, prefix)
+	}
+	return nil, errors.New("unknown storage engine " + cfg.Engine)
+}
@@ -0,0 +1,440 @@
+package kvstore
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/alicebob/miniredis/v2"
+)
+
+// same scenario on both engines
+func testStores(t *testing.T, fn func(t *testing.T, s Store)) {
+	t.Run("kvrocks", func(t *testing.T) {
+		r, _ := newTestClient(t)
+		fn(t, r)
+	})
+	t.Run("bolt", func(t *testing.T) {
+		b, err := NewBoltStore(filepath.Join(t.TempDir(), "pool.db"), "xdag")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		fn(t, b)
+	})
+}
+
+func TestStoreMinShare(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if !s.IsMinShare("job", "a", "s1", 100) {
+			t.Fatal("first share is not min share")
+		}
+		if s.IsMinShare("job", "b", "s2", 200) {
+			t.Fatal("bigger share is min share")
+		}
+		if !s.IsMinShare("job", "c", "s3", 50) {
+			t.Fatal("smaller share is not min share")
+		}
+		if !s.IsPoolShare("job", "s1") || s.IsPoolShare("job", "s2") {
+			t.Fatal("unexpected submitted shares")
+		}
+
+		s.SetFinderReward("a", pool.XdagjReward{TxBlock: "tx", PreHash: "job"}, pool.XDAG, 1000, 1)
+		if unpaid := s.GetMinerUnpaid("c"); unpaid != pool.XDAG {
+			t.Fatalf("finder unpaid %s, want 1", unpaid)
+		}
+	})
+}
+
+func TestStoreMinShareConcurrent(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// share of m0 is the lowest
+				v := uint64((i*37)%50+1) * 1000
+				s.IsMinShare("job", "m"+strconv.Itoa(i), "s"+strconv.Itoa(i), v)
+			}(i)
+		}
+		wg.Wait()
+		if s.IsMinShare("job", "late", "late", 1000) {
+			t.Fatal("share equal to min share is min share")
+		}
+		s.SetFinderReward("", pool.XdagjReward{TxBlock: "tx", PreHash: "job"}, pool.XDAG, 1000, 1)
+		if unpaid := s.GetMinerUnpaid("m0"); unpaid != pool.XDAG {
+			t.Fatalf("finder of lowest share unpaid %s, want 1", unpaid)
+		}
+	})
+}
+
+func TestStorePpsCreditConcurrent(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		// reserve 10 covers 10 shares of 1
+		var wg sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				s.CreditPpsShare("m"+strconv.Itoa(i%3), pool.XDAG, 10*pool.XDAG)
+			}(i)
+		}
+		wg.Wait()
+		stats, err := s.GetPpsStats(10 * pool.XDAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// buffer is checked before each credit, so at most one share overdraws it
+		if stats["credited"] != 11*pool.XDAG || stats["shares"] != int64(11) || stats["skipped"] != int64(19) {
+			t.Fatalf("unexpected pps stats %v", stats)
+		}
+
+		win := pool.XdagjReward{TxBlock: "tx", PreHash: "job", Amount: 64 * pool.XDAG, Fee: pool.XDAG}
+		if err := s.SetPpsWinReward("m0", win, 1000, 1); err != nil {
+			t.Fatal(err)
+		}
+		l, err := s.GetLedger()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Rewards != 0 || l.Unpaid != 0 || l.PpsRewards != 64*pool.XDAG || l.MinerUnpaid != 11*pool.XDAG {
+			t.Fatalf("unexpected ledger %+v", l)
+		}
+		if _, blocks, _ := s.GetBlocks(0, -1); len(blocks) != 1 || blocks[0].Status != BlockWin {
+			t.Fatalf("unexpected blocks %v", blocks)
+		}
+	})
+}
+
+func TestStorePayments(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		s.SetPplnsShares(10)
+		for i, login := range []string{"a", "b", "a"} {
+			// mined shares are global, share must differ per engine
+			if _, err := s.WriteBlock(login, "0", t.Name()+string(rune('0'+i)), 100, 0, 0, "job"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		diffs, poolDiff := s.GetJobDiffs("job")
+		if poolDiff != 300 || diffs["a"] != 200 || diffs["b"] != 100 {
+			t.Fatalf("unexpected job diffs %v %d", diffs, poolDiff)
+		}
+		reward := pool.XdagjReward{TxBlock: "tx", PreHash: "job", Amount: 30 * pool.XDAG}
+		if err := s.SetWinReward("a", reward, 1000, 1); err != nil {
+			t.Fatal(err)
+		}
+		s.DividePplns(reward, 30*pool.XDAG, 0, time.Hour, 1000, 1)
+
+		if err := s.SetMinerSettings("b", &MinerSettings{Threshold: 20 * pool.XDAG, Timestamp: 1}); err != nil {
+			t.Fatal(err)
+		}
+		miners := s.GetMinersToPay(pool.XDAG, pool.XDAG)
+		if len(miners) != 1 || miners[0].Login != "a" || miners[0].Unpaid != 20*pool.XDAG {
+			t.Fatalf("unexpected miners to pay %v", miners)
+		}
+
+		j := &PaymentJournal{TxHash: "pay", Remark: "r", Logins: []string{"a"}, Addresses: []string{"a"},
+			Amounts: []int64{int64(20 * pool.XDAG)}, Fees: []int64{int64(pool.XDAG / 10)}, Fee: int64(pool.XDAG / 10)}
+		if err := s.JournalPending(j); err != nil {
+			t.Fatal(err)
+		}
+		open, err := s.OpenJournals()
+		if err != nil || len(open) != 1 || !reflect.DeepEqual(open[0].Amounts, j.Amounts) {
+			t.Fatalf("unexpected open journals %v %v", open, err)
+		}
+		// concurrent recoveries of the journal record it once
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = s.SetChunkPayment(j, 2000, 2)
+			}()
+		}
+		wg.Wait()
+		if err := s.SetChunkPayment(j, 2000, 2); err != nil {
+			t.Fatal(err)
+		}
+		if open, _ := s.OpenJournals(); len(open) != 0 {
+			t.Fatalf("journal still open %v", open)
+		}
+		rewards, payment, unpaid, _ := s.GetMinerAccount("a")
+		if rewards != 20*pool.XDAG || payment != 20*pool.XDAG || unpaid != 0 {
+			t.Fatalf("unexpected account %s %s %s", rewards, payment, unpaid)
+		}
+		_, list, err := s.MinerBalanceList("a", 0, -1)
+		if err != nil || len(list) != 2 || list[1].Action != "payment" || list[1].Status != JournalRecorded {
+			t.Fatalf("unexpected balance list %v %v", list, err)
+		}
+
+		unconfirmed, err := s.UnconfirmedPayments()
+		if err != nil || len(unconfirmed) != 1 {
+			t.Fatalf("unexpected unconfirmed payments %v %v", unconfirmed, err)
+		}
+		if err := s.FailPayment(unconfirmed[0], "lost", 3000, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.FailPayment(unconfirmed[0], "lost", 3000, 3); err != ErrJournalStatus {
+			t.Fatalf("failed payment reverted twice %v", err)
+		}
+		l, err := s.GetLedger()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Payment != 0 || l.Fees != 0 || l.MinerUnpaid != 30*pool.XDAG || l.Accounts != 2 || len(l.Mismatched) != 0 {
+			t.Fatalf("unexpected ledger %+v", l)
+		}
+	})
+}
+
+func TestStorePayoutReports(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		for i := int64(1); i <= 3; i++ {
+			err := s.SavePayoutReport(&PayoutReport{Timestamp: i, Miners: i, Paid: pool.XDAG, Txs: []string{"a", "b"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		reports, err := s.PayoutReports(2)
+		if err != nil || len(reports) != 2 || reports[0].Timestamp != 3 || len(reports[1].Txs) != 2 {
+			t.Fatalf("unexpected payout reports %v %v", reports, err)
+		}
+
+		if err := s.PausePayouts("upgrade"); err != nil {
+			t.Fatal(err)
+		}
+		if paused, _ := s.PayoutsPaused(); !paused {
+			t.Fatal("payouts not paused")
+		}
+		if err := s.ResumePayouts(); err != nil {
+			t.Fatal(err)
+		}
+		state, _ := s.GetPayoutsState()
+		if state.Paused {
+			t.Fatal("payouts still paused")
+		}
+	})
+}
+
+func TestBoltZset(t *testing.T) {
+	b, err := NewBoltStore(filepath.Join(t.TempDir(), "pool.db"), "xdag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	err = b.update(func(tx *boltTx) error {
+		for i, score := range []float64{3, -1, 2.5, 0, 1e12} {
+			if err := tx.zAdd("z", score, string(rune('a'+i))); err != nil {
+				return err
+			}
+		}
+		return tx.zAdd("z", 4, "a") // update score
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = b.view(func(tx *boltTx) error {
+		members := tx.zRangeMembers("z", 0, -1, false)
+		if !reflect.DeepEqual(members, []string{"b", "d", "c", "a", "e"}) {
+			t.Errorf("unexpected zset order %v", members)
+		}
+		members = tx.zRangeMembers("z", -2, -1, true)
+		if !reflect.DeepEqual(members, []string{"d", "b"}) {
+			t.Errorf("unexpected reverse range %v", members)
+		}
+		return nil
+	})
+}
+
+func TestStoreBlocks(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		now := time.Now().Unix()
+		if err := s.WriteCandidate("old", "a", "s0", 1000, now-7200); err != nil {
+			t.Fatal(err)
+		}
+		for i, job := range []string{"j1", "j2", "j3"} {
+			if err := s.WriteCandidate(job, "a", "s"+job, (now+int64(i))*1000, now+int64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// lower share of j1 replaces finder
+		if err := s.WriteCandidate("j1", "b", "s1b", now*1000, now); err != nil {
+			t.Fatal(err)
+		}
+		win := pool.XdagjReward{PreHash: "j1", TxBlock: "tx1", Amount: 64 * pool.XDAG}
+		if err := s.SetWinReward("b", win, now*1000, now); err != nil {
+			t.Fatal(err)
+		}
+		for _, job := range []string{"j1", "j2", "unknown"} {
+			lost := pool.XdagjReward{PreHash: job, TxBlock: "tx", Amount: 64 * pool.XDAG}
+			if err := s.SetLostReward("other", lost, now*1000, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		count, blocks, err := s.GetBlocks(0, 1)
+		if err != nil || count != 4 || len(blocks) != 2 || blocks[0].JobHash != "j3" || blocks[1].Status != BlockLost {
+			t.Fatalf("unexpected blocks %d %v %v", count, blocks, err)
+		}
+		blocks, err = s.BlocksSince(now - 3600)
+		if err != nil || len(blocks) != 3 {
+			t.Fatalf("unexpected blocks since %v %v", blocks, err)
+		}
+		if b := blocks[0]; b.Status != BlockWin || b.Finder != "b" || b.Winner != "b" || b.Reward != 64*pool.XDAG {
+			t.Fatalf("unexpected won block %+v", b)
+		}
+		luck := NewLuckStats(blocks, time.Hour)
+		if luck.Wins != 1 || luck.Lost != 1 || luck.Candidates != 1 || luck.Luck != 0.5 || luck.Effort != 2 {
+			t.Fatalf("unexpected luck %+v", luck)
+		}
+
+		// j3 got no reward message within 2 task rounds, a late candidate write keeps result of j1
+		for i := 0; i < 2; i++ {
+			n, err := s.ExpireCandidates(2, now*1000)
+			if err != nil || n != int64(i)*2 {
+				t.Fatalf("expired %d %v in round %d", n, err, i)
+			}
+		}
+		if err := s.WriteCandidate("j1", "c", "s1c", now*1000, now); err != nil {
+			t.Fatal(err)
+		}
+		blocks, _ = s.BlocksSince(now - 3600)
+		if blocks[0].Status != BlockWin || blocks[2].JobHash != "j3" || blocks[2].Status != BlockLost {
+			t.Fatalf("unexpected blocks after expiry %+v", blocks)
+		}
+		if n, _ := s.ExpireCandidates(1, now*1000); n != 1 {
+			t.Fatalf("resolved j1 should only leave open set, closed %d", n)
+		}
+
+		if _, err := s.PurgeRecords(time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if count, _, _ := s.GetBlocks(0, -1); count != 3 {
+			t.Fatalf("old block not purged, %d blocks", count)
+		}
+	})
+}
+
+func TestStoreShareSeries(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		now := time.Now().Unix()
+		res := Resolutions[0]
+		size := int64(res.Bucket / time.Second)
+		writes := []struct {
+			login, id, kind string
+			diff, ts        int64
+		}{
+			{"a", "w1", ShareAccepted, 100, now},
+			{"a", "w2", ShareAccepted, 50, now},
+			{"a", "w1", ShareStale, 10, now},
+			{"b", "w1", ShareInvalid, 5, now},
+			{"a", "w1", ShareAccepted, 300, now - size},
+			{"a", "w1", ShareAccepted, 7, now - 2*int64(res.Retention/time.Second)}, // out of retention
+		}
+		for _, w := range writes {
+			if err := s.WriteShareStats(w.login, w.id, w.kind, w.diff, w.ts); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		points, err := s.ShareSeries(SeriesPool, res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != int(res.Retention/res.Bucket) {
+			t.Fatalf("points = %d", len(points))
+		}
+		last, prev := points[len(points)-1], points[len(points)-2]
+		if last.Timestamp != res.bucket(now) || last.Accepted != 150 || last.Stale != 10 || last.Invalid != 5 {
+			t.Fatalf("last point = %+v", last)
+		}
+		if prev.Accepted != 300 || prev.Hashrate != float64(300)/float64(size) {
+			t.Fatalf("prev point = %+v", prev)
+		}
+
+		points, err = s.ShareSeries(WorkerSeries("a", "w1"), res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := points[len(points)-1]; p.Accepted != 100 || p.Stale != 10 || p.Invalid != 0 {
+			t.Fatalf("worker point = %+v", p)
+		}
+		points, err = s.ShareSeries(MinerSeries("b"), res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := points[len(points)-1]; p.Accepted != 0 || p.Invalid != 5 {
+			t.Fatalf("miner point = %+v", p)
+		}
+
+		// old bucket of pool, miner a and worker a.w1 in 5m resolution
+		n, err := s.PurgeShareStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Fatalf("purged = %d", n)
+		}
+	})
+}
+
+func TestStoreUnpaidIndex(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if err := s.SetMinerReward("a", "tx1", "job1", 5*pool.XDAG, 1000, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetMinerReward("b", "tx1", "job1", 3*pool.XDAG, 1000, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetPayment("b", "tx2", "r", 3*pool.XDAG, 2000, 2); err != nil {
+			t.Fatal(err)
+		}
+		miners := s.GetMinersToPay(pool.XDAG, pool.XDAG)
+		if len(miners) != 1 || miners[0].Login != "a" || miners[0].Unpaid != 5*pool.XDAG {
+			t.Fatalf("unexpected miners to pay %v", miners)
+		}
+
+		// accounts written before the index existed
+		switch st := s.(type) {
+		case *KvClient:
+			st.client.Del(ctx, st.formatKey("unpaid"))
+		case *BoltStore:
+			_ = st.update(func(t *boltTx) error { return t.del(st.formatKey("unpaid")) })
+		}
+		if miners := s.GetMinersToPay(pool.XDAG, pool.XDAG); len(miners) != 0 {
+			t.Fatalf("unexpected miners to pay without index %v", miners)
+		}
+		n, err := s.IndexUnpaid()
+		if err != nil || n != 1 {
+			t.Fatalf("indexed %d %v", n, err)
+		}
+		miners = s.GetMinersToPay(pool.XDAG, pool.XDAG)
+		if len(miners) != 1 || miners[0].Login != "a" {
+			t.Fatalf("unexpected miners to pay after indexing %v", miners)
+		}
+	})
+}
+
+// logins are not cut from keys by a fixed prefix length
+func TestMinersToPayPrefix(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "testnet")
+	if err := r.SetMinerReward("a", "tx1", "job1", 5*pool.XDAG, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+	miners := r.GetMinersToPay(pool.XDAG, pool.XDAG)
+	if len(miners) != 1 || miners[0].Login != "a" || miners[0].Unpaid != 5*pool.XDAG {
+		t.Fatalf("unexpected miners to pay %v", miners)
+	}
+	if n, err := r.IndexUnpaid(); err != nil || n != 1 {
+		t.Fatalf("indexed %d %v", n, err)
+	}
+	if v, _ := r.client.ZScore(ctx, r.formatKey("unpaid"), "a").Result(); v != float64(5*pool.XDAG) {
+		t.Fatalf("unexpected index score %v", v)
+	}
+}
@@ -44,8 +44,9 @@ var (
 )
 
 var cfg pool.Config
-var backend *kvstore.KvClient = nil
+var backend kvstore.Store = nil
 var msgChan chan pool.Message
+var indexUnpaid bool
 
 func startStratum() {
 	if cfg.Threads > 0 {
@@ -127,6 +128,18 @@ func startFrontend(cfg *pool.Config, s *stratum.StratumServer) {
 	apiServer.Add("xdag_minerHashrate", s.XdagMinerHashrate)
 	apiServer.Add("xdag_poolHashrate", s.XdagPoolHashrate)
 	apiServer.Add("xdag_poolVersion", s.XdagPoolVersion)
+	apiServer.Add("xdag_reconcile", s.XdagReconcile)
+	apiServer.Add("xdag_minerSettings", s.XdagMinerSettings)
+	apiServer.Add("xdag_setMinerSettings", s.XdagSetMinerSettings)
+	apiServer.Add("xdag_runPayouts", s.XdagRunPayouts)
+	apiServer.Add("xdag_pausePayouts", s.XdagPausePayouts)
+	apiServer.Add("xdag_resumePayouts", s.XdagResumePayouts)
+	apiServer.Add("xdag_payoutsState", s.XdagPayoutsState)
+	apiServer.Add("xdag_payoutReports", s.XdagPayoutReports)
+	apiServer.Add("xdag_poolBlocks", s.XdagPoolBlocks)
+	apiServer.Add("xdag_poolLuck", s.XdagPoolLuck)
+	apiServer.Add("xdag_poolHashrateSeries", s.XdagPoolHashrateSeries)
+	apiServer.Add("xdag_minerHashrateSeries", s.XdagMinerHashrateSeries)
 
 	err := apiServer.Run(cfg.Frontend.Listen)
 	if err != nil {
@@ -147,8 +160,8 @@ func startFrontend(cfg *pool.Config, s *stratum.StratumServer) {
 
 func readConfig(cfg *pool.Config) {
 	configFileName := "config.json"
-	if len(os.Args) > 1 {
-		configFileName = os.Args[1]
+	if flag.NArg() > 0 {
+		configFileName = flag.Arg(0)
 	}
 	configFileName, _ = filepath.Abs(configFileName)
 	log.Printf("Loading config: %v", configFileName)
@@ -218,13 +231,14 @@ func decryptPoolConfigure(cfg *pool.Config, passBytes []byte) error {
 		return errors.New("decryptPoolConfigure: ValidateAddress")
 	}
 
-	// if cfg.Redis.Enabled {
-	b, err = util.Ae64Decode(cfg.KvRocks.PasswordEncrypted, passBytes)
-	if err != nil {
-		return err
+	// embedded store has no password
+	if cfg.KvRocks.Engine != kvstore.EngineBolt {
+		b, err = util.Ae64Decode(cfg.KvRocks.PasswordEncrypted, passBytes)
+		if err != nil {
+			return err
+		}
+		cfg.KvRocks.Password = string(b)
 	}
-	cfg.KvRocks.Password = string(b)
-	// }
 
 	// if cfg.RedisFailover.Enabled {
 	// 	b, err = util.Ae64Decode(cfg.RedisFailover.PasswordEncrypted, passBytes)
@@ -234,12 +248,14 @@ func decryptPoolConfigure(cfg *pool.Config, passBytes []byte) error {
 	// 	cfg.RedisFailover.Password = string(b)
 	// }
 
-	// if cfg.Redis.Enabled {
-	b, err = util.Ae64Decode(cfg.WalletEncrypted, passBytes)
-	if err != nil {
-		return err
+	// offline signing keeps wallet password off pool host
+	if cfg.PayOut.SigningDir == "" {
+		b, err = util.Ae64Decode(cfg.WalletEncrypted, passBytes)
+		if err != nil {
+			return err
+		}
+		cfg.WalletPswd = string(b)
 	}
-	cfg.WalletPswd = string(b)
 
 	return nil
 }
@@ -247,6 +263,7 @@ func decryptPoolConfigure(cfg *pool.Config, passBytes []byte) error {
 func OptionParse() {
 	var showVer bool
 	flag.BoolVar(&showVer, "v", false, "show build version")
+	flag.BoolVar(&indexUnpaid, "index-unpaid", false, "build unpaid index of existing miner accounts and exit")
 
 	flag.Parse()
 
@@ -296,25 +313,36 @@ func main() {
 		util.Error.Fatal("Decrypt Pool Configure error: ", err.Error())
 	}
 
+	if indexUnpaid {
+		runIndexUnpaid()
+		return
+	}
+
 	// walletPass, err := readWalletPass()
 	// if err != nil {
 	// 	util.Error.Fatal("Read Wallet Password error: ", err.Error())
 	// }
 
-	hasWallet, bipAddress := connectBipWallet(cfg.WalletPswd) //string(walletPass[:]))
-	if !hasWallet {
-		util.Error.Fatal("Read Wallet files error")
-	}
+	if cfg.PayOut.SigningDir == "" {
+		hasWallet, bipAddress := connectBipWallet(cfg.WalletPswd) //string(walletPass[:]))
+		if !hasWallet {
+			util.Error.Fatal("Read Wallet files error")
+		}
 
-	if bipAddress != cfg.Address {
-		util.Error.Fatal("Wallet Account Address and Pool Address in Config File are not equal.")
+		if bipAddress != cfg.Address {
+			util.Error.Fatal("Wallet Account Address and Pool Address in Config File are not equal.")
+		}
+	} else {
+		// wallet stays locked, payout batches are signed by tools/signer
+		util.Info.Println("offline signing, payout batches are queued in", cfg.PayOut.SigningDir)
 	}
 
-	backend = kvstore.NewKvClient(&cfg.KvRocks, cfg.Coin)
-
-	if backend == nil {
-		util.Error.Fatal("Backend is Nil: maybe redis/redisFailover config is invalid")
+	backend, err = kvstore.NewStore(&cfg.KvRocks, cfg.Coin)
+	if err != nil {
+		util.Error.Fatal("Open storage backend error: ", err.Error())
 	}
+	defer backend.Close()
+	backend.SetPplnsShares(kvstore.PplnsShares(&cfg.PayOut))
 
 	pong, err := backend.Check()
 	if err != nil {
@@ -331,14 +359,15 @@ func main() {
 	util.NewMinedShares()
 	// util.NewHashrateRank(15)
 	payouts.Cfg = &cfg
+	msgChan = make(chan pool.Message, 512)
+	ws.NewUpstreams(cfg.UpstreamNodes(), cfg.WsReconnect, msgChan)
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		payouts.PaymentTask(ctx, &cfg, backend)
 	}()
 
-	msgChan = make(chan pool.Message, 512)
-	ws.NewClient(cfg.NodeWs, cfg.WsSsl, msgChan)
 	//startNewrelic()
 	startStratum()
 
@@ -346,6 +375,21 @@ func main() {
 	fmt.Println("Stratum server shutdown.")
 }
 
+// one-time migration of data written before the unpaid index existed
+func runIndexUnpaid() {
+	store, err := kvstore.NewStore(&cfg.KvRocks, cfg.Coin)
+	if err != nil {
+		util.Error.Fatal("Open storage backend error: ", err.Error())
+	}
+	defer store.Close()
+	n, err := store.IndexUnpaid()
+	if err != nil {
+		util.Error.Fatal("Build unpaid index error: ", err.Error())
+	}
+	util.Info.Printf("Unpaid index built, %v miners with unpaid balance", n)
+	fmt.Printf("Unpaid index built, %v miners with unpaid balance\n", n)
+}
+
 func connectBipWallet(password string) (bool, string) {
 	util.Info.Println("Initializing cryptography...")
 	util.Info.Println("Reading wallet...")
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"strconv"
 	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
 )
 
 func TestChunk(t *testing.T) {
@@ -45,3 +48,36 @@ func chunkReset(batchAddress *[]string, batchAmount *[]int64) {
 	*batchAddress = (*batchAddress)[:0]
 	*batchAmount = (*batchAmount)[:0]
 }
+
+func TestPlanBatches(t *testing.T) {
+	miners := []kvstore.MinerPayout{
+		{Login: "e", Unpaid: 1 * pool.XDAG, PaidAt: 100},
+		{Login: "a", Unpaid: 3 * pool.XDAG, PaidAt: 200},
+		{Login: "d", Unpaid: 2 * pool.XDAG, PaidAt: 0},
+		{Login: "c", Unpaid: 3 * pool.XDAG, PaidAt: 100},
+		{Login: "b", Unpaid: 3 * pool.XDAG, PaidAt: 100},
+		{Login: "f", Unpaid: 1 * pool.XDAG, PaidAt: 50},
+	}
+	sortPayouts(miners)
+	var order string
+	for _, m := range miners {
+		order += m.Login
+	}
+	if order != "bcadfe" {
+		t.Fatalf("payout order %s, want bcadfe", order)
+	}
+
+	batches, stopped := planBatches(miners, payoutLimits{chunkSize: 2})
+	if len(batches) != 3 || stopped != "" || batches[0].Total != 6*pool.XDAG {
+		t.Fatalf("unexpected batches %+v %s", batches, stopped)
+	}
+	batches, stopped = planBatches(miners, payoutLimits{chunkSize: 2, maxTxs: 2})
+	if len(batches) != 2 || stopped != StopMaxTxs {
+		t.Fatalf("unexpected batches %+v %s", batches, stopped)
+	}
+	// stops at first miner over the cap, smaller balances are not paid ahead
+	batches, stopped = planBatches(miners, payoutLimits{chunkSize: 11, maxTotal: 10 * pool.XDAG})
+	if len(batches) != 1 || len(batches[0].Miners) != 3 || stopped != StopMaxTotal {
+		t.Fatalf("unexpected batches %+v %s", batches, stopped)
+	}
+}
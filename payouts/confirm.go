@@ -0,0 +1,79 @@
+package payouts
+
+import (
+	"time"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+const (
+	defaultConfirmInterval = time.Minute
+	defaultConfirmTimeout  = time.Hour
+)
+
+func confirmDurations(cfg *pool.PayOutConfig) (interval, timeout time.Duration) {
+	interval, err := time.ParseDuration(cfg.ConfirmInterval)
+	if err != nil || interval <= 0 {
+		interval = defaultConfirmInterval
+	}
+	timeout, err = time.ParseDuration(cfg.ConfirmTimeout)
+	if err != nil || timeout <= 0 {
+		timeout = defaultConfirmTimeout
+	}
+	return
+}
+
+// poll node for recorded payments, confirm accepted ones and re-credit rejected ones or ones node
+// still does not know after timeout. a pending transaction may still be included, it is never
+// re-credited but reported after timeout
+func checkConfirmations(backend kvstore.Store, timeout time.Duration) {
+	journals, err := backend.UnconfirmedPayments()
+	if err != nil {
+		util.Error.Println("kv store get unconfirmed payments error", err)
+		return
+	}
+
+	now := util.MakeTimestamp()
+	for _, j := range journals {
+		state, err := txState(j.TxHash)
+		if err != nil {
+			util.Error.Println("check payment confirmation error", j.TxHash, err)
+			return // node unreachable, try next time
+		}
+
+		timedOut := now-j.RecordedAt > int64(timeout/time.Millisecond)
+		switch state {
+		case "Accepted", "Main":
+			err = backend.ConfirmPayment(j.TxHash)
+			if err != nil {
+				util.Error.Println("kv store confirm payment error", j.TxHash, err)
+				continue
+			}
+			util.Info.Println("payment confirmed", j.TxHash)
+		case "Rejected":
+			failPayment(backend, j, "rejected")
+		case "":
+			if timedOut {
+				failPayment(backend, j, "not found")
+			}
+		default:
+			if timedOut {
+				util.Error.Printf("ALERT payment %s still %s after %v, check node, it is not re-credited",
+					j.TxHash, state, timeout)
+			}
+		}
+	}
+}
+
+func failPayment(backend kvstore.Store, j *kvstore.PaymentJournal, reason string) {
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+	err := backend.FailPayment(j, reason, ms, ts)
+	if err != nil {
+		util.Error.Println("kv store fail payment error", j.TxHash, err)
+		return
+	}
+	util.Warn.Println("payment", reason, j.TxHash, "re-credit", j.Logins, j.Amounts)
+}
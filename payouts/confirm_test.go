@@ -0,0 +1,97 @@
+package payouts
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdagjtest"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestCheckConfirmations(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+
+	poolAddress, poolKey := newTestAddress(t)
+	Cfg = &pool.Config{Address: poolAddress, NodeRpc: node.RpcURL}
+	BipKey = poolKey
+
+	var miners, hashes []string
+	for i := 0; i < 3; i++ {
+		miner, _ := newTestAddress(t)
+		miners = append(miners, miner)
+		mr.HSet("xdag:account:"+miner, "unpaid", "5000000000")
+		batch := []kvstore.MinerPayout{{Login: miner, Address: miner, Unpaid: 5000000000}}
+		if _, err := PayChunk(backend, &batch, "", FeePolicy{}); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, xdagjtest.TxHash(node.Transactions()[i]))
+	}
+	node.SetTxState(hashes[0], "Accepted")
+	node.SetTxState(hashes[1], "Rejected")
+
+	// hashes[2] stays pending within timeout
+	checkConfirmations(backend, time.Hour)
+	want := []string{kvstore.JournalConfirmed, kvstore.JournalFailed, kvstore.JournalRecorded}
+	unpaid := []string{"0", "5000000000", "0"}
+	for i, h := range hashes {
+		if j, _ := backend.GetJournal(h); j.Status != want[i] {
+			t.Fatalf("payment %d status %s, want %s", i, j.Status, want[i])
+		}
+		if u := mr.HGet("xdag:account:"+miners[i], "unpaid"); u != unpaid[i] {
+			t.Fatalf("payment %d unpaid %s, want %s", i, u, unpaid[i])
+		}
+	}
+
+	// still pending after timeout may be included later, never re-credited
+	time.Sleep(5 * time.Millisecond)
+	checkConfirmations(backend, time.Millisecond)
+	if j, _ := backend.GetJournal(hashes[2]); j.Status != kvstore.JournalRecorded {
+		t.Fatalf("pending payment status %s, want recorded", j.Status)
+	}
+	if u := mr.HGet("xdag:account:"+miners[2], "unpaid"); u != "0" {
+		t.Fatalf("pending payment unpaid %s, want 0", u)
+	}
+
+	// rpc error of node after timeout is retried, never re-credited
+	node.HandleRpc("xdag_getBlockByHash", func(params []string) (interface{}, error) {
+		return nil, errors.New("node is syncing")
+	})
+	checkConfirmations(backend, time.Millisecond)
+	if j, _ := backend.GetJournal(hashes[2]); j.Status != kvstore.JournalRecorded {
+		t.Fatalf("payment status %s after rpc error, want recorded", j.Status)
+	}
+	if u := mr.HGet("xdag:account:"+miners[2], "unpaid"); u != "0" {
+		t.Fatalf("unpaid %s after rpc error, want 0", u)
+	}
+
+	// unknown to node after timeout, re-credited once
+	node.HandleRpc("xdag_getBlockByHash", func(params []string) (interface{}, error) {
+		return nil, errors.New("Block not found")
+	})
+	checkConfirmations(backend, time.Millisecond)
+	checkConfirmations(backend, time.Millisecond)
+	if j, _ := backend.GetJournal(hashes[2]); j.Status != kvstore.JournalFailed || j.Error != "not found" {
+		t.Fatalf("payment status %s %s, want failed not found", j.Status, j.Error)
+	}
+	if u := mr.HGet("xdag:account:"+miners[2], "unpaid"); u != "5000000000" {
+		t.Fatalf("unpaid %s, want 5000000000", u)
+	}
+	if p := mr.HGet("xdag:account:"+miners[2], "payment"); p != "0" {
+		t.Fatalf("payment %s, want 0", p)
+	}
+
+	list, _ := backend.MinerPaymentList(miners[0], 0, -1)
+	if len(list) != 1 || list[0].Status != kvstore.JournalConfirmed {
+		t.Fatalf("payment history %+v", list)
+	}
+	_, balance, _ := backend.MinerBalanceList(miners[1], 0, -1)
+	if len(balance) != 2 || balance[0].Status != kvstore.JournalFailed || balance[1].Action != "refund" {
+		t.Fatalf("balance history %+v", balance)
+	}
+}
@@ -0,0 +1,54 @@
+package payouts
+
+import (
+	"fmt"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+)
+
+// who pays transaction fee of a payout
+const (
+	FeePoolPays = "pool"  // pool wallet pays fee on top of miners unpaid
+	FeeSplit    = "split" // fee is deducted from recipients in proportion to their amounts
+)
+
+type FeePolicy struct {
+	Fee   pool.Amount // per transaction
+	Split bool
+}
+
+func Fees(cfg *pool.PayOutConfig) FeePolicy {
+	return FeePolicy{
+		Fee:   pool.AmountFromFloat(cfg.TxFee),
+		Split: cfg.FeePolicy == FeeSplit,
+	}
+}
+
+// fee paid by pool wallet on top of batch total
+func (f FeePolicy) poolFee() pool.Amount {
+	if f.Split {
+		return 0
+	}
+	return f.Fee
+}
+
+// fee share deducted from every recipient of batch, nil if pool pays
+func (f FeePolicy) shares(batch []kvstore.MinerPayout) ([]int64, error) {
+	if !f.Split || f.Fee <= 0 {
+		return nil, nil
+	}
+	weights := make(map[string]int64, len(batch))
+	for _, m := range batch {
+		weights[m.Login] = int64(m.Unpaid)
+	}
+	parts := kvstore.SplitAmount(f.Fee, weights)
+	shares := make([]int64, len(batch))
+	for i, m := range batch {
+		shares[i] = int64(parts[m.Login])
+		if shares[i] >= int64(m.Unpaid) {
+			return nil, fmt.Errorf("fee share %s exceeds payment of %s", parts[m.Login], m.Login)
+		}
+	}
+	return shares, nil
+}
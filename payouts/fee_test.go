@@ -0,0 +1,96 @@
+package payouts
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdagjtest"
+	xdagoUtils "github.com/XDagger/xdagpool/xdago/utils"
+	"github.com/alicebob/miniredis/v2"
+)
+
+// little endian amount of block at hex offset
+func blockAmount(t *testing.T, block string, offset int) uint64 {
+	b, err := hex.DecodeString(block[offset : offset+16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func TestTransactionFee(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+
+	poolAddress, poolKey := newTestAddress(t)
+	big, _ := newTestAddress(t)
+	small, _ := newTestAddress(t)
+	cfg := &pool.Config{Address: poolAddress, NodeRpc: node.RpcURL,
+		PayOut: pool.PayOutConfig{Threshold: 0, TxFee: 0.1}}
+	Cfg = cfg
+	BipKey = poolKey
+	mr.HSet("xdag:account:"+big, "reward", "3000000000", "payment", "0", "unpaid", "3000000000")
+	mr.HSet("xdag:account:"+small, "reward", "2000000000", "payment", "0", "unpaid", "2000000000")
+	if _, err := backend.IndexUnpaid(); err != nil {
+		t.Fatal(err)
+	}
+	node.SetBalance(poolAddress, "5.000000000")
+
+	// pool pays fee on top of unpaid, wallet cannot cover it
+	run, err := RunPayouts(cfg, backend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Batches) != 0 || run.Stopped != StopBalance {
+		t.Fatalf("unexpected run %+v", run)
+	}
+
+	cfg.PayOut.FeePolicy = FeeSplit
+	run, err = RunPayouts(cfg, backend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Batches) != 1 || run.Batches[0].Fee != pool.XDAG/10 {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	block := node.Transactions()[0]
+	fee := xdagoUtils.Nano2Amount(1e8)
+	out0 := xdagoUtils.Nano2Amount(3e9 - 60e6)
+	out1 := xdagoUtils.Nano2Amount(2e9 - 40e6)
+	// header fee, input value, output values
+	if blockAmount(t, block, 48) != fee || blockAmount(t, block, 112) != out0+out1+fee ||
+		blockAmount(t, block, 176) != out0 || blockAmount(t, block, 240) != out1 {
+		t.Fatal("unexpected fee or amounts in transaction block")
+	}
+
+	if u := mr.HGet("xdag:account:"+big, "unpaid"); u != "0" {
+		t.Fatalf("unpaid %s, want 0", u)
+	}
+	if f := mr.HGet("xdag:account:"+big, "fee"); f != "60000000" {
+		t.Fatalf("miner fee %s, want 60000000", f)
+	}
+	if f := mr.HGet("xdag:pool:account", "fees"); f != "100000000" {
+		t.Fatalf("pool fees %s, want 100000000", f)
+	}
+	reports, _ := backend.PayoutReports(1)
+	if len(reports) != 1 || reports[0].Fees != pool.XDAG/10 || reports[0].Paid != 5*pool.XDAG {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+
+	// failed payment reverts fees
+	j, _ := backend.GetJournal(run.Batches[0].TxHash)
+	if err := backend.FailPayment(j, "test", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if f := mr.HGet("xdag:pool:account", "fees"); f != "0" {
+		t.Fatalf("pool fees %s, want 0", f)
+	}
+	if f := mr.HGet("xdag:account:"+small, "fee"); f != "0" {
+		t.Fatalf("miner fee %s, want 0", f)
+	}
+}
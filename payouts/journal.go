@@ -0,0 +1,124 @@
+package payouts
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/buger/jsonparser"
+)
+
+// reconcile open payment journal entries against node, return false if any is left unresolved.
+// a pending transaction known by node is recorded, an unknown one is sent again with the same
+// signed block so it can never be paid twice.
+func RecoverPayments(backend kvstore.Store) bool {
+	journals, err := backend.OpenJournals()
+	if err != nil {
+		util.Error.Println("kv store get payment journal error", err)
+		return false
+	}
+
+	resolved := true
+	for _, j := range journals {
+		util.Warn.Println("recover payment", j.TxHash, j.Status, j.Logins, j.Amounts)
+		if j.Status == kvstore.JournalPending && !recoverPending(backend, j) {
+			resolved = false
+			continue
+		}
+
+		ms := util.MakeTimestamp()
+		ts := ms / 1000
+		err = backend.SetChunkPayment(j, ms, ts)
+		if err != nil {
+			util.Error.Println("kv store set recovered payment error", j.TxHash, err)
+			resolved = false
+			continue
+		}
+		util.Info.Println("recovered payment recorded", j.TxHash)
+	}
+	return resolved
+}
+
+// make sure a pending transaction reached node, return false if it is aborted or node is unreachable
+func recoverPending(backend kvstore.Store, j *kvstore.PaymentJournal) bool {
+	exists, err := txExists(j.TxHash)
+	if err != nil {
+		util.Error.Println("check pending payment error", j.TxHash, err)
+		return false
+	}
+
+	if !exists {
+		if j.Block == "" {
+			abortJournal(backend, j.TxHash, "transaction block lost")
+			return false
+		}
+		err = SendTx(j.Block, j.TxHash)
+		var rpcErr *RpcError
+		if errors.As(err, &rpcErr) {
+			// node refuses a block it already holds as well, abort only if it is unknown
+			exists, checkErr := txExists(j.TxHash)
+			if checkErr != nil {
+				util.Error.Println("check refused payment error", j.TxHash, checkErr)
+				return false
+			}
+			if !exists {
+				abortJournal(backend, j.TxHash, err.Error())
+				return false
+			}
+			util.Warn.Println("resent payment refused but known by node", j.TxHash, err)
+		} else if err != nil {
+			util.Error.Println("resend pending payment error", j.TxHash, err)
+			return false
+		}
+	}
+
+	err = backend.JournalBroadcast(j.TxHash)
+	if err != nil {
+		util.Error.Println("kv store journal payment broadcast error", j.TxHash, err)
+	}
+	return true
+}
+
+func abortJournal(backend kvstore.Store, txHash, reason string) {
+	util.Error.Println("abort payment", txHash, reason)
+	err := backend.JournalAbort(txHash, reason)
+	if err != nil {
+		util.Error.Println("kv store abort payment error", txHash, err)
+	}
+}
+
+// whether node knows transaction block of hash
+func txExists(hash string) (bool, error) {
+	state, err := txState(hash)
+	return state != "", err
+}
+
+// error message of xdag_getBlockByHash for an unknown block
+const blockNotFound = "block not found"
+
+// state of transaction block in node (Pending, Accepted, Rejected or Main), empty if not found.
+// only a null result or a not found error means not found, other rpc errors (syncing, rate limit,
+// node without the block after failover) are returned so the check is retried
+func txState(hash string) (string, error) {
+	body, err := xdagjRpcRaw("xdag_getBlockByHash", hash)
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) && strings.Contains(strings.ToLower(rpcErr.Message), blockNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, dataType, _, err := jsonparser.Get(body, "result")
+	if err != nil {
+		return "", err
+	}
+	if dataType == jsonparser.Null {
+		return "", nil
+	}
+	state, err := jsonparser.GetString(body, "result", "state")
+	if err != nil || state == "" {
+		return "Pending", nil
+	}
+	return state, nil
+}
@@ -0,0 +1,129 @@
+package payouts
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdagjtest"
+	"github.com/XDagger/xdagpool/xdago/base58"
+	"github.com/XDagger/xdagpool/xdago/cryptography"
+	"github.com/XDagger/xdagpool/xdago/secp256k1"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestAddress(t *testing.T) (string, *secp256k1.PrivateKey) {
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := cryptography.ToBytesAddress(key)
+	return base58.ChkEnc(b[:]), key
+}
+
+// journal a payment of amount to miner without sending it, as if pool crashed
+func journalPayment(t *testing.T, backend kvstore.Store, miner string, amount int64) string {
+	block, txHash, err := transfer2chunk([]string{miner}, "", []int64{amount}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.JournalPending(&kvstore.PaymentJournal{TxHash: txHash, Block: block,
+		Logins: []string{miner}, Amounts: []int64{amount}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txHash
+}
+
+func TestRecoverPayments(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+
+	poolAddress, poolKey := newTestAddress(t)
+	miner, _ := newTestAddress(t)
+	Cfg = &pool.Config{Address: poolAddress, NodeRpc: node.RpcURL}
+	BipKey = poolKey
+	account := "xdag:account:" + miner
+	mr.HSet(account, "unpaid", "10000000000")
+
+	// sent before crash, must not be sent again
+	sent := journalPayment(t, backend, miner, 1000000000)
+	block, _ := backend.GetJournal(sent)
+	if err := SendTx(block.Block, sent); err != nil {
+		t.Fatal(err)
+	}
+	// never reached node, sent again
+	unsent := journalPayment(t, backend, miner, 2000000000)
+
+	if !RecoverPayments(backend) {
+		t.Fatal("payments not recovered")
+	}
+	if txs := node.Transactions(); len(txs) != 2 {
+		t.Fatalf("expect 2 transactions, got %d", len(txs))
+	}
+	for _, h := range []string{sent, unsent} {
+		if j, _ := backend.GetJournal(h); j.Status != kvstore.JournalRecorded {
+			t.Fatalf("journal %s status %s", h, j.Status)
+		}
+	}
+	if unpaid := mr.HGet(account, "unpaid"); unpaid != "7000000000" {
+		t.Fatalf("unpaid %s, want 7000000000", unpaid)
+	}
+
+	// recovered again, nothing is paid twice
+	if !RecoverPayments(backend) {
+		t.Fatal("payments not recovered")
+	}
+	if unpaid := mr.HGet(account, "unpaid"); unpaid != "7000000000" {
+		t.Fatalf("unpaid %s, want 7000000000", unpaid)
+	}
+
+	// refused by node, aborted
+	node.HandleRpc("xdag_sendRawTransaction", func(params []string) (interface{}, error) {
+		return nil, errors.New("balance not enough")
+	})
+	refused := journalPayment(t, backend, miner, 3000000000)
+	RecoverPayments(backend)
+	if j, _ := backend.GetJournal(refused); j.Status != kvstore.JournalAborted {
+		t.Fatalf("journal status %s, want aborted", j.Status)
+	}
+	if unpaid := mr.HGet(account, "unpaid"); unpaid != "7000000000" {
+		t.Fatalf("unpaid %s, want 7000000000", unpaid)
+	}
+
+	// known by node but missed by first lookup, resend is refused and it is recorded
+	var lookups int32
+	node.HandleRpc("xdag_getBlockByHash", func(params []string) (interface{}, error) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			return nil, nil
+		}
+		return map[string]string{"hash": params[0], "state": "Accepted"}, nil
+	})
+	node.HandleRpc("xdag_sendRawTransaction", func(params []string) (interface{}, error) {
+		return nil, errors.New("block already exists")
+	})
+	known := journalPayment(t, backend, miner, 1000000000)
+	if !RecoverPayments(backend) {
+		t.Fatal("known payment not recovered")
+	}
+	if j, _ := backend.GetJournal(known); j.Status != kvstore.JournalRecorded {
+		t.Fatalf("journal status %s, want recorded", j.Status)
+	}
+	if unpaid := mr.HGet(account, "unpaid"); unpaid != "6000000000" {
+		t.Fatalf("unpaid %s, want 6000000000", unpaid)
+	}
+
+	// node unreachable, left pending
+	Cfg.NodeRpc = "http://127.0.0.1:1"
+	pending := journalPayment(t, backend, miner, 4000000000)
+	if RecoverPayments(backend) {
+		t.Fatal("unreachable node resolved payment")
+	}
+	if j, _ := backend.GetJournal(pending); j.Status != kvstore.JournalPending {
+		t.Fatalf("journal status %s, want pending", j.Status)
+	}
+}
@@ -0,0 +1,290 @@
+package payouts
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/XDagger/xdagpool/xdago/secp256k1"
+	xdagoUtils "github.com/XDagger/xdagpool/xdago/utils"
+)
+
+// offline signing: pool writes <id>.unsigned.json to signing dir, signer tool writes
+// <id>.signed.json next to it, pool broadcasts and records it and moves both to done/
+const (
+	unsignedSuffix = ".unsigned.json"
+	signedSuffix   = ".signed.json"
+	doneDir        = "done"
+)
+
+// payout batch waiting for signature
+type SigningBatch struct {
+	ID        string        `json:"id"`
+	CreatedAt int64         `json:"createdAt"`
+	From      string        `json:"from"`
+	Remark    string        `json:"remark"`
+	Fee       pool.Amount   `json:"fee"`
+	Logins    []string      `json:"logins"`
+	Addresses []string      `json:"addresses"`
+	Amounts   []pool.Amount `json:"amounts"`         // taken from unpaid of logins
+	Fees      []pool.Amount `json:"fees,omitempty"`  // fee share of logins
+	Outputs   []pool.Amount `json:"outputs"`         // paid to addresses
+	Block     string        `json:"block,omitempty"` // signed transaction block, set by signer
+	TxHash    string        `json:"txHash,omitempty"`
+}
+
+func ReadSigningBatch(path string) (*SigningBatch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b SigningBatch
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &b, nil
+}
+
+// write file atomically, a reader never sees half a batch
+func WriteSigningBatch(path string, b *SigningBatch) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func UnsignedPath(dir, id string) string {
+	return filepath.Join(dir, id+unsignedSuffix)
+}
+
+func SignedPath(dir, id string) string {
+	return filepath.Join(dir, id+signedSuffix)
+}
+
+// ids of batches in signing dir not broadcast yet
+func UnsignedBatches(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+unsignedSuffix))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = strings.TrimSuffix(filepath.Base(f), unsignedSuffix)
+	}
+	return ids, nil
+}
+
+// create and sign transaction of batch with wallet key
+func SignBatch(b *SigningBatch, key *secp256k1.PrivateKey) error {
+	if len(b.Addresses) != len(b.Outputs) || len(b.Addresses) == 0 {
+		return errors.New("signing batch size error")
+	}
+	outputs := make([]int64, len(b.Outputs))
+	for i, v := range b.Outputs {
+		outputs[i] = int64(v)
+	}
+	block, txHash, err := BuildChunkTx(outputs, int64(b.Fee), b.From, b.Addresses, b.Remark, key)
+	if err != nil {
+		return err
+	}
+	b.Block, b.TxHash = block, txHash
+	return checkChunkBlock(b)
+}
+
+// write batch for offline signing, return its id
+func queueBatch(dir, id string, batch []kvstore.MinerPayout, remark string, fees FeePolicy) (string, error) {
+	j, outputs, err := chunkJournal(batch, remark, fees)
+	if err != nil {
+		return "", err
+	}
+	b := &SigningBatch{
+		ID:        id,
+		CreatedAt: util.MakeTimestamp(),
+		From:      Cfg.Address,
+		Remark:    remark,
+		Fee:       pool.Amount(j.Fee),
+		Logins:    j.Logins,
+		Addresses: j.Addresses,
+		Amounts:   make([]pool.Amount, len(batch)),
+		Outputs:   make([]pool.Amount, len(batch)),
+	}
+	for i := range batch {
+		b.Amounts[i] = pool.Amount(j.Amounts[i])
+		b.Outputs[i] = pool.Amount(outputs[i])
+	}
+	for _, v := range j.Fees {
+		b.Fees = append(b.Fees, pool.Amount(v))
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := WriteSigningBatch(UnsignedPath(dir, id), b); err != nil {
+		return "", err
+	}
+	util.Info.Println("payout batch", id, "waiting for offline signature")
+	return id, nil
+}
+
+// broadcast and record signed batches, left in place if node is unreachable
+func broadcastSigned(backend kvstore.Store, dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+signedSuffix))
+	if err != nil {
+		util.Error.Println("list signed payout batches error", err)
+		return
+	}
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), signedSuffix)
+		b, err := signedBatch(dir, id)
+		if err != nil {
+			util.Error.Println("signed payout batch", id, "rejected:", err)
+			continue
+		}
+		// journaled before, recovery of payment journal takes it over
+		if j, err := backend.GetJournal(b.TxHash); err != nil {
+			util.Error.Println("kv store get payment journal error", b.TxHash, err)
+			continue
+		} else if j != nil {
+			doneBatch(dir, id)
+			continue
+		}
+		err = sendChunk(backend, b.journal())
+		var rpcErr *RpcError
+		if err != nil && !errors.As(err, &rpcErr) {
+			continue
+		}
+		doneBatch(dir, id)
+	}
+}
+
+// scheduled broadcast of signed batches, skipped during a payout run
+func sendSignedBatches(cfg *pool.Config, backend kvstore.Store) {
+	cfg.RLock()
+	dir := cfg.PayOut.SigningDir
+	cfg.RUnlock()
+	if dir == "" || !payMu.TryLock() {
+		return
+	}
+	defer payMu.Unlock()
+	broadcastSigned(backend, dir)
+}
+
+// signed batch checked against the batch queued by pool
+func signedBatch(dir, id string) (*SigningBatch, error) {
+	unsigned, err := ReadSigningBatch(UnsignedPath(dir, id))
+	if err != nil {
+		return nil, err
+	}
+	signed, err := ReadSigningBatch(SignedPath(dir, id))
+	if err != nil {
+		return nil, err
+	}
+	queued := *signed
+	queued.Block, queued.TxHash = "", ""
+	if !reflect.DeepEqual(&queued, unsigned) {
+		return nil, errors.New("signed batch differs from queued batch")
+	}
+	if blockHash(signed.Block) != signed.TxHash {
+		return nil, errors.New("transaction hash does not match block")
+	}
+	if err := checkChunkBlock(signed); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// transaction block pays exactly the outputs and fee of batch
+func checkChunkBlock(b *SigningBatch) error {
+	const header, field = 64, 64 // hex length
+	if len(b.Addresses) != len(b.Outputs) {
+		return errors.New("signing batch size error")
+	}
+	if len(b.Block) < header+field*(len(b.Outputs)+1) {
+		return errors.New("transaction block too short")
+	}
+	if b.Block[48:64] != amountHex(b.Fee) {
+		return errors.New("transaction fee does not match batch")
+	}
+	from, err := checkBase58Address(b.From)
+	if err != nil {
+		return err
+	}
+	var input uint64
+	if b.Fee > 0 {
+		input = xdagoUtils.Nano2Amount(uint64(b.Fee))
+	}
+	for i, out := range b.Outputs {
+		if out <= 0 {
+			return errors.New("transaction output is zero")
+		}
+		to, err := checkBase58Address(b.Addresses[i])
+		if err != nil {
+			return err
+		}
+		offset := header + field*(i+1)
+		if b.Block[offset:offset+48] != to || b.Block[offset+48:offset+field] != amountHex(out) {
+			return fmt.Errorf("transaction output %d does not match batch", i)
+		}
+		input += xdagoUtils.Nano2Amount(uint64(out))
+	}
+	var inputBytes [8]byte
+	binary.LittleEndian.PutUint64(inputBytes[:], input)
+	if b.Block[header:header+48] != from || b.Block[header+48:header+field] != hex.EncodeToString(inputBytes[:]) {
+		return errors.New("transaction input does not match batch")
+	}
+	return nil
+}
+
+// block field of an amount
+func amountHex(v pool.Amount) string {
+	var b [8]byte
+	if v > 0 {
+		binary.LittleEndian.PutUint64(b[:], xdagoUtils.Nano2Amount(uint64(v)))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func (b *SigningBatch) journal() *kvstore.PaymentJournal {
+	j := &kvstore.PaymentJournal{
+		TxHash:    b.TxHash,
+		Block:     b.Block,
+		Remark:    b.Remark,
+		Logins:    b.Logins,
+		Addresses: b.Addresses,
+		Amounts:   make([]int64, len(b.Amounts)),
+		Fee:       int64(b.Fee),
+	}
+	for i, v := range b.Amounts {
+		j.Amounts[i] = int64(v)
+	}
+	for _, v := range b.Fees {
+		j.Fees = append(j.Fees, int64(v))
+	}
+	return j
+}
+
+func doneBatch(dir, id string) {
+	done := filepath.Join(dir, doneDir)
+	if err := os.MkdirAll(done, 0700); err != nil {
+		util.Error.Println("move payout batch error", id, err)
+		return
+	}
+	for _, f := range []string{UnsignedPath(dir, id), SignedPath(dir, id)} {
+		if err := os.Rename(f, filepath.Join(done, filepath.Base(f))); err != nil {
+			util.Error.Println("move payout batch error", id, err)
+		}
+	}
+}
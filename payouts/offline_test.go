@@ -0,0 +1,81 @@
+package payouts
+
+import (
+	"os"
+	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdagjtest"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestOfflineSigning(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+
+	dir := t.TempDir()
+	poolAddress, poolKey := newTestAddress(t)
+	cfg := &pool.Config{Address: poolAddress, NodeRpc: node.RpcURL,
+		PayOut: pool.PayOutConfig{Threshold: 1, SigningDir: dir}}
+	Cfg = cfg
+	BipKey = nil // wallet is not in pool
+	miner, _ := newTestAddress(t)
+	mr.HSet("xdag:account:"+miner, "reward", "3000000000", "payment", "0", "unpaid", "3000000000")
+	if _, err := backend.IndexUnpaid(); err != nil {
+		t.Fatal(err)
+	}
+	node.SetBalance(poolAddress, "10.000000000")
+
+	run, err := RunPayouts(cfg, backend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Batches) != 1 || run.Batches[0].Queued == "" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	id := run.Batches[0].Queued
+	if _, err := RunPayouts(cfg, backend, false); err == nil {
+		t.Fatal("expect error while batch is unsigned")
+	}
+
+	b, err := ReadSigningBatch(UnsignedPath(dir, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tampered batch is never broadcast
+	b.Outputs[0] = 30 * pool.XDAG
+	if err := SignBatch(b, poolKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteSigningBatch(SignedPath(dir, id), b); err != nil {
+		t.Fatal(err)
+	}
+	sendSignedBatches(cfg, backend)
+	if txs := node.Transactions(); len(txs) != 0 {
+		t.Fatalf("tampered batch sent %d transactions", len(txs))
+	}
+
+	b, _ = ReadSigningBatch(UnsignedPath(dir, id))
+	if err := SignBatch(b, poolKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteSigningBatch(SignedPath(dir, id), b); err != nil {
+		t.Fatal(err)
+	}
+	sendSignedBatches(cfg, backend)
+	if txs := node.Transactions(); len(txs) != 1 || xdagjtest.TxHash(txs[0]) != b.TxHash {
+		t.Fatalf("expect signed transaction sent, got %d", len(txs))
+	}
+	if u := mr.HGet("xdag:account:"+miner, "unpaid"); u != "0" {
+		t.Fatalf("unpaid %s, want 0", u)
+	}
+	if _, err := os.Stat(SignedPath(dir, id)); !os.IsNotExist(err) {
+		t.Fatal("signed batch not moved to done")
+	}
+	if ids, _ := UnsignedBatches(dir); len(ids) != 0 {
+		t.Fatalf("unsigned batches left %v", ids)
+	}
+}
@@ -2,76 +2,346 @@ package payouts
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/XDagger/xdagpool/kvstore"
 	"github.com/XDagger/xdagpool/pool"
 	"github.com/XDagger/xdagpool/util"
 )
 
-func batchPayMiners(cfg *pool.Config, backend *kvstore.KvClient) {
-	var threshold int64
+var payMu sync.Mutex // one payout run at a time
+
+// why due miners were deferred to next run
+const (
+	StopBalance  = "balance"  // wallet balance cannot cover next batch
+	StopMaxTotal = "maxTotal" // run reached maxPayoutTotal
+	StopMaxTxs   = "maxTxs"   // run reached maxPayoutTxs
+	StopError    = "error"    // payment of a batch failed
+)
+
+// a batch transaction of a payout run
+type PayoutBatch struct {
+	Miners []kvstore.MinerPayout `json:"miners"`
+	Total  pool.Amount           `json:"total"` // taken from miners unpaid
+	Fee    pool.Amount           `json:"fee"`
+	Queued string                `json:"queued,omitempty"` // id of batch waiting for offline signature
+	TxHash string                `json:"txHash,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+type PayoutRun struct {
+	DryRun         bool          `json:"dryRun"`
+	Balance        pool.Amount   `json:"balance"` // wallet balance before run
+	Batches        []PayoutBatch `json:"batches"`
+	Deferred       int           `json:"deferred"` // miners due but left for next run
+	DeferredAmount pool.Amount   `json:"deferredAmount"`
+	Stopped        string        `json:"stopped,omitempty"`
+}
+
+// per run limits of payouts
+type payoutLimits struct {
+	chunkSize int
+	maxTotal  pool.Amount
+	maxTxs    int
+}
+
+// scheduled payout run
+func batchPayMiners(cfg *pool.Config, backend kvstore.Store) {
+	_, err := RunPayouts(cfg, backend, false)
+	if err != nil {
+		util.Warn.Println("skip payouts:", err)
+	}
+}
+
+// pay miners over threshold in batches, a dry run only returns the batches that would be sent
+func RunPayouts(cfg *pool.Config, backend kvstore.Store, dryRun bool) (*PayoutRun, error) {
+	if !payMu.TryLock() {
+		return nil, errors.New("payout run in progress")
+	}
+	defer payMu.Unlock()
+
+	if !dryRun {
+		paused, err := backend.PayoutsPaused()
+		if err != nil {
+			return nil, err
+		}
+		if paused {
+			return nil, errors.New("payouts paused")
+		}
+		// unpaid balances are not final while a journaled payment is unresolved
+		if !RecoverPayments(backend) {
+			return nil, errors.New("unresolved payment journal")
+		}
+	}
+
+	var threshold, minThreshold pool.Amount
 	var remark string
+	var limits payoutLimits
+	var fees FeePolicy
+	var signingDir string
 	cfg.RLock()
-	threshold = cfg.PayOut.Threshold
+	threshold, minThreshold = Thresholds(&cfg.PayOut)
 	remark = cfg.PayOut.PaymentRemark
+	limits.maxTotal = pool.AmountFromFloat(cfg.PayOut.MaxPayoutTotal)
+	limits.maxTxs = cfg.PayOut.MaxPayoutTxs
+	fees = Fees(&cfg.PayOut)
+	signingDir = cfg.PayOut.SigningDir
 	cfg.RUnlock()
 
-	// find miners balance more than payment threshold
-	miners := backend.GetMinersToPay(threshold)
-	if len(miners) == 0 {
-		return
+	if !dryRun && signingDir != "" {
+		broadcastSigned(backend, signingDir)
+		queued, err := UnsignedBatches(signingDir)
+		if err != nil {
+			return nil, err
+		}
+		// unpaid balances are not final while queued batches are unsigned
+		if len(queued) > 0 {
+			return nil, fmt.Errorf("%d payout batches waiting for offline signature", len(queued))
+		}
 	}
-	var chunkSize int
 	if len(remark) > 0 {
-		chunkSize = 10
+		limits.chunkSize = 10
 	} else {
-		chunkSize = 11
+		limits.chunkSize = 11
+	}
+
+	run := &PayoutRun{DryRun: dryRun, Batches: []PayoutBatch{}}
+	// find miners balance more than payment threshold
+	miners := backend.GetMinersToPay(threshold, minThreshold)
+	if len(miners) == 0 {
+		return run, nil
+	}
+	balance, err := walletBalance()
+	if err != nil {
+		return nil, fmt.Errorf("get pool wallet balance error: %v", err)
 	}
-	batchAddress := make([]string, 0, chunkSize)
-	batchAmount := make([]int64, 0, chunkSize)
-	for address, amount := range miners {
+	run.Balance = balance
 
-		if amount > 0 {
-			batchAddress = append(batchAddress, address)
-			batchAmount = append(batchAmount, amount)
+	sortPayouts(miners)
+	batches, stopped := planBatches(miners, limits)
+	run.Stopped = stopped
+	for _, batch := range batches {
+		batch.Fee = fees.Fee
+		if !coverBatch(&balance, batch.Total+fees.poolFee()) {
+			run.Stopped = StopBalance
+			break
 		}
-		if len(batchAddress) == chunkSize {
-			PayChunk(backend, &batchAddress, &batchAmount, remark)
+		if dryRun {
+			run.Batches = append(run.Batches, batch)
+			continue
 		}
+		if signingDir != "" {
+			id := fmt.Sprintf("%d-%d", util.MakeTimestamp(), len(run.Batches))
+			batch.Queued, err = queueBatch(signingDir, id, batch.Miners, remark, fees)
+			if err != nil {
+				util.Error.Println("queue payout batch error", err)
+				batch.Error = err.Error()
+				run.Batches = append(run.Batches, batch)
+				run.Stopped = StopError
+				break
+			}
+			run.Batches = append(run.Batches, batch)
+			continue
+		}
+		miners := batch.Miners
+		batch.TxHash, err = PayChunk(backend, &miners, remark, fees)
+		if err != nil {
+			batch.Error = err.Error()
+			run.Batches = append(run.Batches, batch)
+			run.Stopped = StopError
+			break
+		}
+		run.Batches = append(run.Batches, batch)
 	}
 
-	if len(batchAddress) > 0 {
-		PayChunk(backend, &batchAddress, &batchAmount, remark)
+	report := run.report(miners)
+	run.Deferred = int(report.Deferred)
+	run.DeferredAmount = report.DeferredAmount
+	if !dryRun {
+		if err := backend.SavePayoutReport(report); err != nil {
+			util.Error.Println("kv store save payout report error", err)
+		}
 	}
+	return run, nil
+}
 
+// summary of paid and deferred miners of a run
+func (run *PayoutRun) report(due []kvstore.MinerPayout) *kvstore.PayoutReport {
+	p := &kvstore.PayoutReport{Timestamp: util.MakeTimestamp(), Txs: []string{}, Stopped: run.Stopped}
+	var dueTotal pool.Amount
+	var dueMiners int64
+	for _, m := range due {
+		if m.Unpaid > 0 {
+			dueTotal += m.Unpaid
+			dueMiners++
+		}
+	}
+	for _, b := range run.Batches {
+		if run.DryRun || b.TxHash != "" || b.Queued != "" {
+			p.Miners += int64(len(b.Miners))
+			p.Paid += b.Total
+			p.Fees += b.Fee
+		}
+		if b.TxHash != "" {
+			p.Txs = append(p.Txs, b.TxHash)
+		}
+	}
+	p.Deferred = dueMiners - p.Miners
+	p.DeferredAmount = dueTotal - p.Paid
+	return p
 }
 
-func PayChunk(backend *kvstore.KvClient, batchAddress *[]string, batchAmount *[]int64, remark string) {
-	ms := util.MakeTimestamp()
-	ts := ms / 1000
-	txHash, err := transfer2chunk(*batchAddress, remark, *batchAmount)
+// largest unpaid first, then longest since last payment
+func sortPayouts(miners []kvstore.MinerPayout) {
+	sort.Slice(miners, func(i, j int) bool {
+		a, b := miners[i], miners[j]
+		if a.Unpaid != b.Unpaid {
+			return a.Unpaid > b.Unpaid
+		}
+		if a.PaidAt != b.PaidAt {
+			return a.PaidAt < b.PaidAt
+		}
+		return a.Login < b.Login
+	})
+}
+
+// split sorted miners into batches of a transaction within run limits
+func planBatches(miners []kvstore.MinerPayout, limits payoutLimits) (batches []PayoutBatch, stopped string) {
+	var batch PayoutBatch
+	var total pool.Amount
+	for _, miner := range miners {
+		if miner.Unpaid <= 0 {
+			continue
+		}
+		if limits.maxTxs > 0 && len(batches) == limits.maxTxs {
+			stopped = StopMaxTxs
+			break
+		}
+		// keep order, a miner never gets paid before a larger balance
+		if limits.maxTotal > 0 && total+miner.Unpaid > limits.maxTotal {
+			stopped = StopMaxTotal
+			break
+		}
+		total += miner.Unpaid
+		batch.Miners = append(batch.Miners, miner)
+		batch.Total += miner.Unpaid
+		if len(batch.Miners) == limits.chunkSize {
+			batches = append(batches, batch)
+			batch = PayoutBatch{}
+		}
+	}
+	if len(batch.Miners) > 0 {
+		batches = append(batches, batch)
+	}
+	return
+}
+
+// take batch cost from remaining wallet balance, false if balance cannot cover it
+func coverBatch(balance *pool.Amount, cost pool.Amount) bool {
+	if cost > *balance {
+		util.Warn.Println("wallet balance", *balance, "cannot cover payment batch", cost, "skip payouts")
+		return false
+	}
+	*balance -= cost
+	return true
+}
+
+// journal, send and record a batch payment
+func PayChunk(backend kvstore.Store, batch *[]kvstore.MinerPayout, remark string, fees FeePolicy) (string, error) {
+	defer func() {
+		*batch = (*batch)[:0]
+	}()
+	j, outputs, err := chunkJournal(*batch, remark, fees)
+	if err != nil {
+		util.Error.Println("split chunk transaction fee error", err)
+		return "", err
+	}
+	j.Block, j.TxHash, err = transfer2chunk(j.Addresses, remark, outputs, j.Fee)
+	if err != nil {
+		util.Error.Println("create chunk transaction to miners error", err)
+		return "", err
+	}
+	if err = sendChunk(backend, j); err != nil {
+		return "", err
+	}
+	return j.TxHash, nil
+}
+
+// journal entry of a batch without transaction, outputs are amounts less fee shares
+func chunkJournal(batch []kvstore.MinerPayout, remark string, fees FeePolicy) (*kvstore.PaymentJournal, []int64, error) {
+	shares, err := fees.shares(batch)
+	if err != nil {
+		return nil, nil, err
+	}
+	j := &kvstore.PaymentJournal{
+		Remark:    remark,
+		Logins:    make([]string, len(batch)),
+		Addresses: make([]string, len(batch)),
+		Amounts:   make([]int64, len(batch)),
+		Fees:      shares,
+		Fee:       int64(fees.Fee),
+	}
+	outputs := make([]int64, len(batch))
+	for i, m := range batch {
+		j.Logins[i] = m.Login
+		j.Addresses[i] = m.Address
+		j.Amounts[i] = int64(m.Unpaid)
+		outputs[i] = j.Amounts[i]
+		if shares != nil {
+			outputs[i] -= shares[i]
+		}
+	}
+	return j, outputs, nil
+}
+
+// journal, send and record the signed transaction of a batch
+func sendChunk(backend kvstore.Store, j *kvstore.PaymentJournal) error {
+	txHash := j.TxHash
+	err := backend.JournalPending(j)
+	if err != nil {
+		util.Error.Println("kv store journal chunk payment error", txHash, err)
+		return err
+	}
+
+	err = SendTx(j.Block, txHash)
+	if err != nil {
+		util.Error.Println("transfer chunk reward to miners error", txHash, err)
+		var rpcErr *RpcError
+		if errors.As(err, &rpcErr) {
+			// refused by node, nothing is paid
+			if err := backend.JournalAbort(txHash, err.Error()); err != nil {
+				util.Error.Println("kv store abort chunk payment error", txHash, err)
+			}
+		}
+		return err
+	}
+	err = backend.JournalBroadcast(txHash)
 	if err != nil {
-		util.Error.Println("transfer chunk reward to miners error", err)
-		return
+		util.Error.Println("kv store journal chunk broadcast error", txHash, err)
 	}
-	err = backend.SetChunkPayment(*batchAddress, txHash, remark, *batchAmount, ms, ts)
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+	err = backend.SetChunkPayment(j, ms, ts)
 	if err != nil {
 		util.Error.Println("kv store set chunk payment error", txHash, err)
+		return err
 	}
-	*batchAddress = (*batchAddress)[:0]
-	*batchAmount = (*batchAmount)[:0]
+	return nil
 }
 
-func transfer2chunk(miners []string, remark string, amounts []int64) (txHash string, err error) {
+func transfer2chunk(miners []string, remark string, amounts []int64, fee int64) (block, txHash string, err error) {
 	if len(remark) > 0 && !ValidateRemark(remark) {
-		return "", errors.New("remark error")
+		return "", "", errors.New("remark error")
 	}
 
 	if len(miners) != len(amounts) || len(miners) > 11 || (len(miners) == 11 && len(remark) > 0) {
-		return "", errors.New("transfer chunck size error")
+		return "", "", errors.New("transfer chunck size error")
 	}
 
-	txHash, err = TransferChunkRpc(amounts, Cfg.Address, miners, remark, BipKey)
+	block, txHash, err = BuildChunkTx(amounts, fee, Cfg.Address, miners, remark, BipKey)
 	return
 	// fmt.Println(amount, Cfg.Address, miner, remark)
 	// return getUuid(), nil
@@ -9,45 +9,77 @@ import (
 	"github.com/XDagger/xdagpool/util"
 )
 
-// var backend *kvstore.KvClient = nil
+// var backend kvstore.Store = nil
 
-func PaymentTask(ctx context.Context, cfg *pool.Config, backend *kvstore.KvClient) {
+func PaymentTask(ctx context.Context, cfg *pool.Config, backend kvstore.Store) {
 	interval, err := time.ParseDuration(cfg.PayOut.PaymentInterval)
 	if err != nil {
 		interval = 10 * time.Minute
 	}
-	ticker := time.NewTicker(interval) // every 10 minutes
+	var schedule *Schedule
+	if cfg.PayOut.PaymentSchedule != "" {
+		schedule, err = ParseSchedule(cfg.PayOut.PaymentSchedule)
+		if err != nil {
+			util.Error.Println("payment schedule error, use payment interval", err)
+		}
+	}
+	// finish payments interrupted by a crash before paying again,
+	// under the same lock as xdag_runPayouts and payout runs
+	payMu.Lock()
+	RecoverPayments(backend)
+	payMu.Unlock()
+	timer := time.NewTimer(nextPayment(schedule, interval, time.Now()))
+	confirmInterval, confirmTimeout := confirmDurations(&cfg.PayOut)
+	confirmTicker := time.NewTicker(confirmInterval)
+	reconcileTicker := time.NewTicker(reconcileInterval(&cfg.PayOut))
 	for {
 		select {
 		case <-ctx.Done():
 			util.Info.Println("exit payment task")
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			// payMiners(cfg, backend)
 			batchPayMiners(cfg, backend)
+			timer.Reset(nextPayment(schedule, interval, time.Now()))
+		case <-confirmTicker.C:
+			sendSignedBatches(cfg, backend)
+			checkConfirmations(backend, confirmTimeout)
+		case <-reconcileTicker.C:
+			reconcileLedger(cfg, backend)
+		}
+	}
+}
+
+// wait until next payout run, by schedule if set
+func nextPayment(schedule *Schedule, interval time.Duration, now time.Time) time.Duration {
+	if schedule != nil {
+		if next := schedule.Next(now); !next.IsZero() {
+			return next.Sub(now)
 		}
 	}
+	return interval
 }
 
-func payMiners(cfg *pool.Config, backend *kvstore.KvClient) {
+func payMiners(cfg *pool.Config, backend kvstore.Store) {
 	cfg.RLock()
 	defer cfg.RUnlock()
 	// find miners balance more than payment threshold
-	miners := backend.GetMinersToPay(cfg.PayOut.Threshold)
+	miners := backend.GetMinersToPay(Thresholds(&cfg.PayOut))
 	if len(miners) == 0 {
 		return
 	}
-	for address, amount := range miners {
-		if amount > 0 {
-			payMiner(backend, address, cfg.PayOut.PaymentRemark, float64(amount)/float64(1e9))
+	for _, miner := range miners {
+		if miner.Unpaid > 0 {
+			payMiner(backend, miner.Login, miner.Address, cfg.PayOut.PaymentRemark, miner.Unpaid, Fees(&cfg.PayOut).Fee)
 		}
 	}
 }
 
-func payMiner(backend *kvstore.KvClient, miner, remark string, amount float64) {
+// pool pays fee of a single transfer
+func payMiner(backend kvstore.Store, miner, address, remark string, amount, fee pool.Amount) {
 	ms := util.MakeTimestamp()
 	ts := ms / 1000
-	txHash, err := transfer2miner(miner, remark, amount)
+	txHash, err := transfer2miner(address, remark, amount, fee)
 	if err != nil {
 		util.Error.Println("transfer reward to miner error", miner, amount, err)
 		return
@@ -58,7 +90,7 @@ func payMiner(backend *kvstore.KvClient, miner, remark string, amount float64) {
 	}
 }
 
-// func payFund(backend *kvstore.KvClient, fund, jobHash, remark string, amount float64) {
+// func payFund(backend kvstore.Store, fund, jobHash, remark string, amount float64) {
 // 	ms := util.MakeTimestamp()
 // 	ts := ms / 1000
 // 	txHash, err := transfer2miner(fund, remark, amount)
@@ -72,8 +104,8 @@ func payMiner(backend *kvstore.KvClient, miner, remark string, amount float64) {
 // 	}
 // }
 
-func transfer2miner(miner, remark string, amount float64) (txHash string, err error) {
-	txHash, err = TransferRpc(amount, Cfg.Address, miner, remark, BipKey)
+func transfer2miner(miner, remark string, amount, fee pool.Amount) (txHash string, err error) {
+	txHash, err = TransferRpc(amount, fee, Cfg.Address, miner, remark, BipKey)
 	return
 	// fmt.Println(amount, Cfg.Address, miner, remark)
 	// return getUuid(), nil
@@ -0,0 +1,64 @@
+package payouts
+
+import (
+	"sync/atomic"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+const defaultPpsBlockReward = 64 * pool.XDAG
+
+var ppsSuspended int32
+
+func IsPpsMode(mode string) bool {
+	return mode == "pps" || mode == "fpps"
+}
+
+// pool buffer reserve funded by operator
+func PpsReserve(cfg *pool.PayOutConfig) pool.Amount {
+	return pool.AmountFromFloat(cfg.PpsReserve)
+}
+
+// credit a valid share at submission in pps/fpps mode:
+// (block reward [+ average block fee for fpps]) * diff / network diff * (1 - pool fee)
+func CreditShare(cfg *pool.Config, backend kvstore.Store, login string, diff int64) {
+	cfg.RLock()
+	mode := cfg.PayOut.Mode
+	netDiff := cfg.PayOut.PpsNetworkDiff
+	blockReward := pool.AmountFromFloat(cfg.PayOut.PpsBlockReward)
+	poolRation := cfg.PayOut.PoolRation
+	reserve := PpsReserve(&cfg.PayOut)
+	cfg.RUnlock()
+
+	if !IsPpsMode(mode) || netDiff <= 0 || diff <= 0 {
+		return
+	}
+	if blockReward <= 0 {
+		blockReward = defaultPpsBlockReward
+	}
+	if mode == "fpps" {
+		avgFee, err := backend.PpsAvgFee()
+		if err != nil {
+			util.Error.Println("get fpps average fee error", err)
+		}
+		blockReward += avgFee
+	}
+
+	amount := blockReward.MulDiv(diff, netDiff)
+	amount -= amount.Percent(poolRation)
+	if amount <= 0 {
+		return
+	}
+	ok, err := backend.CreditPpsShare(login, amount, reserve)
+	if err != nil {
+		util.Error.Println("credit pps share error", login, diff, err)
+		return
+	}
+	if !ok && atomic.CompareAndSwapInt32(&ppsSuspended, 0, 1) {
+		util.Warn.Println("pps buffer is negative, share crediting suspended")
+	} else if ok && atomic.CompareAndSwapInt32(&ppsSuspended, 1, 0) {
+		util.Info.Println("pps buffer recovered, share crediting resumed")
+	}
+}
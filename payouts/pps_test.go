@@ -0,0 +1,53 @@
+package payouts
+
+import (
+	"os"
+	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMain(m *testing.M) {
+	util.InitLog(os.DevNull, os.DevNull, os.DevNull, os.DevNull, 40)
+	os.Exit(m.Run())
+}
+
+func TestPpsBuffer(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+	cfg := &pool.Config{PayOut: pool.PayOutConfig{Mode: "pps", PoolRation: 10,
+		PpsNetworkDiff: 1000, PpsBlockReward: 100, PpsReserve: 15}}
+
+	// 100 * 100 / 1000 * 0.9 = 9 xdag per share
+	CreditShare(cfg, backend, "a", 100)
+	CreditShare(cfg, backend, "a", 100)
+	// buffer 15 - 18 < 0, suspended
+	CreditShare(cfg, backend, "a", 100)
+	if unpaid := mr.HGet("xdag:account:a", "unpaid"); unpaid != "18000000000" {
+		t.Fatalf("unpaid %s, want 18000000000", unpaid)
+	}
+	stats, _ := backend.GetPpsStats(PpsReserve(&cfg.PayOut))
+	if stats["suspended"] != true || stats["skipped"] != int64(1) {
+		t.Fatalf("pps should be suspended: %v", stats)
+	}
+
+	// block reward refills buffer
+	reward := pool.XdagjReward{PreHash: "job", Amount: 100 * pool.XDAG, Fee: 2 * pool.XDAG}
+	if err := backend.SetPpsWinReward("a", reward, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	CreditShare(cfg, backend, "a", 100)
+	if unpaid := mr.HGet("xdag:account:a", "unpaid"); unpaid != "27000000000" {
+		t.Fatalf("unpaid %s, want 27000000000", unpaid)
+	}
+
+	// fpps adds average block fee, (100 + 2) * 100 / 1000 * 0.9 = 9.18
+	cfg.PayOut.Mode = "fpps"
+	CreditShare(cfg, backend, "b", 100)
+	if unpaid := mr.HGet("xdag:account:b", "unpaid"); unpaid != "9180000000" {
+		t.Fatalf("unpaid %s, want 9180000000", unpaid)
+	}
+}
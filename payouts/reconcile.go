@@ -0,0 +1,119 @@
+package payouts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+const defaultReconcileInterval = time.Hour
+
+// kv store ledger checked against pool wallet balance on chain
+type Reconciliation struct {
+	Timestamp     int64       `json:"timestamp"`
+	Address       string      `json:"address"`
+	Balance       pool.Amount `json:"balance"`     // pool wallet on chain
+	Liabilities   pool.Amount `json:"liabilities"` // unpaid owed to miners
+	Surplus       pool.Amount `json:"surplus"`     // balance - liabilities
+	PoolRewards   pool.Amount `json:"poolRewards"`
+	PoolPayment   pool.Amount `json:"poolPayment"`
+	PoolUnpaid    pool.Amount `json:"poolUnpaid"`
+	PoolFees      pool.Amount `json:"poolFees"` // transaction fees of payments
+	PpsRewards    pool.Amount `json:"ppsRewards"`
+	MinerRewards  pool.Amount `json:"minerRewards"`
+	MinerPayment  pool.Amount `json:"minerPayment"`
+	MinerUnpaid   pool.Amount `json:"minerUnpaid"`
+	Accounts      int         `json:"accounts"`
+	Discrepancies []string    `json:"discrepancies"`
+}
+
+func (r *Reconciliation) discrepancy(format string, args ...interface{}) {
+	r.Discrepancies = append(r.Discrepancies, fmt.Sprintf(format, args...))
+}
+
+func Reconcile(cfg *pool.Config, backend kvstore.Store) (*Reconciliation, error) {
+	cfg.RLock()
+	ppsReserve := pool.Amount(0)
+	if IsPpsMode(cfg.PayOut.Mode) {
+		ppsReserve = PpsReserve(&cfg.PayOut)
+	}
+	cfg.RUnlock()
+
+	ledger, err := backend.GetLedger()
+	if err != nil {
+		return nil, err
+	}
+	balance, err := walletBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reconciliation{
+		Timestamp:     util.MakeTimestamp(),
+		Address:       Cfg.Address,
+		Balance:       balance,
+		Liabilities:   ledger.MinerUnpaid,
+		Surplus:       balance - ledger.MinerUnpaid,
+		PoolRewards:   ledger.Rewards,
+		PoolPayment:   ledger.Payment,
+		PoolUnpaid:    ledger.Unpaid,
+		PoolFees:      ledger.Fees,
+		PpsRewards:    ledger.PpsRewards,
+		MinerRewards:  ledger.MinerRewards,
+		MinerPayment:  ledger.MinerPayment,
+		MinerUnpaid:   ledger.MinerUnpaid,
+		Accounts:      ledger.Accounts,
+		Discrepancies: []string{},
+	}
+
+	if ledger.Payment != ledger.MinerPayment {
+		r.discrepancy("pool payment %s, sum of miners payment %s", ledger.Payment, ledger.MinerPayment)
+	}
+	if ledger.Unpaid != ledger.Rewards-ledger.Payment {
+		r.discrepancy("pool unpaid %s, pool rewards - payment %s", ledger.Unpaid, ledger.Rewards-ledger.Payment)
+	}
+	// pool fees are kept, pps shares are credited from pps block rewards and operator reserve
+	if funds := ledger.Rewards + ledger.PpsRewards + ppsReserve; ledger.MinerRewards > funds {
+		r.discrepancy("miners rewards %s exceed pool rewards %s", ledger.MinerRewards, funds)
+	}
+	for _, login := range ledger.Mismatched {
+		r.discrepancy("account %s unpaid is not reward - payment", login)
+	}
+	if balance < ledger.MinerUnpaid {
+		r.discrepancy("wallet balance %s does not cover miners unpaid %s", balance, ledger.MinerUnpaid)
+	}
+	return r, nil
+}
+
+func reconcileInterval(cfg *pool.PayOutConfig) time.Duration {
+	interval, err := time.ParseDuration(cfg.ReconcileInterval)
+	if err != nil || interval <= 0 {
+		return defaultReconcileInterval
+	}
+	return interval
+}
+
+// periodic reconciliation in payment task, discrepancies are logged
+func reconcileLedger(cfg *pool.Config, backend kvstore.Store) {
+	r, err := Reconcile(cfg, backend)
+	if err != nil {
+		util.Error.Println("reconcile ledger error", err)
+		return
+	}
+	for _, d := range r.Discrepancies {
+		util.Warn.Println("reconcile:", d)
+	}
+	util.Info.Println("reconcile: balance", r.Balance, "liabilities", r.Liabilities, "surplus", r.Surplus)
+}
+
+// pool wallet balance on chain
+func walletBalance() (pool.Amount, error) {
+	value, err := BalanceRpc(Cfg.Address)
+	if err != nil {
+		return 0, err
+	}
+	return pool.ParseAmount(value)
+}
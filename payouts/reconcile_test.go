@@ -0,0 +1,113 @@
+package payouts
+
+import (
+	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdagjtest"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestReconcile(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+
+	poolAddress, poolKey := newTestAddress(t)
+	miner, _ := newTestAddress(t)
+	cfg := &pool.Config{Address: poolAddress, NodeRpc: node.RpcURL,
+		PayOut: pool.PayOutConfig{Threshold: 1}}
+	Cfg = cfg
+	BipKey = poolKey
+
+	// block reward 10, pool fee 1, miner reward 9
+	mr.HSet("xdag:pool:account", "rewards", "10000000000", "payment", "0", "unpaid", "10000000000")
+	mr.HSet("xdag:account:"+miner, "reward", "9000000000", "payment", "0", "unpaid", "9000000000")
+	if _, err := backend.IndexUnpaid(); err != nil {
+		t.Fatal(err)
+	}
+	node.SetBalance(poolAddress, "8.000000000")
+
+	r, err := Reconcile(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Liabilities != 9*pool.XDAG || r.Surplus != -pool.XDAG || len(r.Discrepancies) != 1 {
+		t.Fatalf("unexpected reconciliation %+v", r)
+	}
+
+	// wallet cannot cover the batch
+	batchPayMiners(cfg, backend)
+	if txs := node.Transactions(); len(txs) != 0 {
+		t.Fatalf("expect no transaction, got %d", len(txs))
+	}
+
+	node.SetBalance(poolAddress, "10.000000000")
+	batchPayMiners(cfg, backend)
+	if txs := node.Transactions(); len(txs) != 1 {
+		t.Fatalf("expect 1 transaction, got %d", len(txs))
+	}
+	r, err = Reconcile(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Liabilities != 0 || r.PoolPayment != 9*pool.XDAG || len(r.Discrepancies) != 0 {
+		t.Fatalf("unexpected reconciliation %+v", r)
+	}
+
+	// account changed outside of a transaction
+	mr.HSet("xdag:account:"+miner, "unpaid", "1")
+	r, _ = Reconcile(cfg, backend)
+	if len(r.Discrepancies) != 1 {
+		t.Fatalf("unexpected discrepancies %v", r.Discrepancies)
+	}
+}
+
+func TestReconcilePps(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+
+	poolAddress, poolKey := newTestAddress(t)
+	cfg := &pool.Config{Address: poolAddress, NodeRpc: node.RpcURL,
+		PayOut: pool.PayOutConfig{Mode: "pps", Threshold: 1, PoolRation: 10,
+			PpsNetworkDiff: 1000, PpsBlockReward: 100, PpsReserve: 20}}
+	Cfg = cfg
+	BipKey = poolKey
+
+	// 9 xdag per share, credited from reserve before any block is won
+	CreditShare(cfg, backend, "a", 100)
+	CreditShare(cfg, backend, "a", 100)
+	node.SetBalance(poolAddress, "18.000000000")
+	r, err := Reconcile(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Liabilities != 18*pool.XDAG || r.PoolRewards != 0 || len(r.Discrepancies) != 0 {
+		t.Fatalf("unexpected reconciliation %+v", r)
+	}
+
+	// won block funds pps buffer, not pool account
+	reward := pool.XdagjReward{PreHash: "job", Amount: 100 * pool.XDAG}
+	if err := backend.SetPpsWinReward("a", reward, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	node.SetBalance(poolAddress, "118.000000000")
+	r, err = Reconcile(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Liabilities != 18*pool.XDAG || r.PoolUnpaid != 0 || r.PpsRewards != 100*pool.XDAG || len(r.Discrepancies) != 0 {
+		t.Fatalf("unexpected reconciliation %+v", r)
+	}
+
+	// credits beyond block rewards and reserve
+	mr.HSet("xdag:account:b", "reward", "200000000000", "payment", "0", "unpaid", "200000000000")
+	r, _ = Reconcile(cfg, backend)
+	if len(r.Discrepancies) != 2 {
+		t.Fatalf("unexpected discrepancies %v", r.Discrepancies)
+	}
+}
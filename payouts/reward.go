@@ -3,6 +3,7 @@ package payouts
 import (
 	"encoding/hex"
 	"errors"
+	"time"
 
 	"github.com/XDagger/xdagpool/kvstore"
 	"github.com/XDagger/xdagpool/pool"
@@ -18,7 +19,10 @@ var BipKey *secp256k1.PrivateKey
 
 const CommunityAddress = "4duPWMbYUgAifVYkKDCWxLvRRkSByf5gb"
 
-func ProcessReward(cfg *pool.Config, backend *kvstore.KvClient, reward pool.XdagjReward) {
+// rewards arrive from every upstream node and are processed one at a time, a duplicate is
+// ignored as winning share is removed from submit set by SetWinReward and SetLostReward
+// only resolves open candidates
+func ProcessReward(cfg *pool.Config, backend kvstore.Store, reward pool.XdagjReward) {
 	ms := util.MakeTimestamp()
 	ts := ms / 1000
 	login, err := addressFromShare(reward.Share)
@@ -29,7 +33,20 @@ func ProcessReward(cfg *pool.Config, backend *kvstore.KvClient, reward pool.Xdag
 
 	// is the reward's share submitted by this pool?
 	if !backend.IsPoolShare(reward.PreHash, reward.Share) {
-		// backend.SetLostReward(login, reward, ms, ts)
+		if err := backend.SetLostReward(login, reward, ms, ts); err != nil {
+			util.Error.Println("store lost block error", reward.PreHash, err)
+		}
+		return
+	}
+
+	cfg.RLock()
+	pps := IsPpsMode(cfg.PayOut.Mode)
+	cfg.RUnlock()
+	if pps {
+		// miners are credited at share submission, block reward funds pool buffer
+		if err := backend.SetPpsWinReward(login, reward, ms, ts); err != nil {
+			util.Error.Println("store pps reward error", reward.PreHash, err)
+		}
 		return
 	}
 
@@ -43,12 +60,12 @@ func ProcessReward(cfg *pool.Config, backend *kvstore.KvClient, reward pool.Xdag
 
 }
 
-func dividend(cfg *pool.Config, backend *kvstore.KvClient, login string, reward pool.XdagjReward, ms, ts int64) {
+func dividend(cfg *pool.Config, backend kvstore.Store, login string, reward pool.XdagjReward, ms, ts int64) {
 	cfg.RLock()
 	defer cfg.RUnlock()
-	poolFee := reward.Amount * cfg.PayOut.PoolRation / 100.0     // for pool owner
-	rewardFee := reward.Amount * cfg.PayOut.RewardRation / 100.0 // reward to lowest hash finder
-	// directFee := reward.Amount * cfg.PayOut.DirectRation / 100.0 // divided equally to every miner
+	poolFee := reward.Amount.Percent(cfg.PayOut.PoolRation)     // for pool owner
+	rewardFee := reward.Amount.Percent(cfg.PayOut.RewardRation) // reward to lowest hash finder
+	// directFee := reward.Amount.Percent(cfg.PayOut.DirectRation) // divided equally to every miner
 
 	divideAmount := reward.Amount - poolFee //- directFee
 	if cfg.PayOut.Mode == "solo" {
@@ -60,7 +77,10 @@ func dividend(cfg *pool.Config, backend *kvstore.KvClient, login string, reward
 	// if cfg.PayOut.Mode == "solo" && cfg.PayOut.DirectRation > 0 {
 	// 	backend.DivideSolo(login, reward, directFee, ms, ts)
 	// } else
-	if cfg.PayOut.Mode == "equal" {
+	if cfg.PayOut.Mode == "pplns" {
+		window, _ := time.ParseDuration(cfg.PayOut.PplnsWindow)
+		backend.DividePplns(reward, divideAmount-rewardFee, kvstore.PplnsShares(&cfg.PayOut), window, ms, ts)
+	} else if cfg.PayOut.Mode == "equal" {
 		divideAmount = divideAmount - rewardFee
 		// if cfg.PayOut.DirectRation > 0 {
 		// 	backend.DivideEqual(login, reward, directFee, divideAmount, ms, ts)
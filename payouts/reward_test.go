@@ -0,0 +1,46 @@
+package payouts
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdago/cryptography"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestDuplicateReward(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+	cfg := &pool.Config{PayOut: pool.PayOutConfig{Mode: "solo", PoolRation: 10}}
+
+	miner, key := newTestAddress(t)
+	b := cryptography.ToBytesAddress(key)
+	share := hex.EncodeToString(b[:]) + "000000000000000000000001"
+	if !backend.IsMinShare("job", miner, share, 1) {
+		t.Fatal("share is not min share")
+	}
+	if err := backend.WriteCandidate("job", miner, share, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// same reward from two upstream nodes
+	reward := pool.XdagjReward{TxBlock: "tx", PreHash: "job", Share: share, Amount: 64 * pool.XDAG}
+	ProcessReward(cfg, backend, reward)
+	ProcessReward(cfg, backend, reward)
+
+	if unpaid := backend.GetMinerUnpaid(miner); unpaid != 64*pool.XDAG-64*pool.XDAG/10 {
+		t.Fatalf("miner unpaid %s, want 57.6", unpaid)
+	}
+	l, err := backend.GetLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Rewards != 64*pool.XDAG {
+		t.Fatalf("pool rewards %s, want 64", l.Rewards)
+	}
+	if _, blocks, _ := backend.GetBlocks(0, -1); len(blocks) != 1 || blocks[0].Status != kvstore.BlockWin {
+		t.Fatalf("unexpected blocks %v", blocks)
+	}
+}
@@ -0,0 +1,129 @@
+package payouts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdagjtest"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestScheduleNext(t *testing.T) {
+	now := time.Date(2024, 1, 31, 10, 30, 20, 0, time.UTC) // wednesday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 8-9,12 * * *", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 * *", time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// restricted day of month and day of week match either
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatal(tt.spec, err)
+		}
+		if got := s.Next(now); !got.Equal(tt.want) {
+			t.Errorf("%s: next %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "0 0 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q: expect error", spec)
+		}
+	}
+}
+
+func TestRunPayouts(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+
+	poolAddress, poolKey := newTestAddress(t)
+	cfg := &pool.Config{Address: poolAddress, NodeRpc: node.RpcURL,
+		PayOut: pool.PayOutConfig{Threshold: 1}}
+	Cfg = cfg
+	BipKey = poolKey
+	node.SetBalance(poolAddress, "100.000000000")
+	for i := 0; i < 12; i++ {
+		miner, _ := newTestAddress(t)
+		mr.HSet("xdag:account:"+miner, "reward", "2000000000", "payment", "0", "unpaid", "2000000000")
+	}
+	if _, err := backend.IndexUnpaid(); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := RunPayouts(cfg, backend, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Batches) != 2 || len(run.Batches[0].Miners) != 11 || run.Batches[1].Total != 2*pool.XDAG {
+		t.Fatalf("unexpected dry run %+v", run)
+	}
+	if txs := node.Transactions(); len(txs) != 0 {
+		t.Fatalf("dry run sent %d transactions", len(txs))
+	}
+
+	if err := backend.PausePayouts("maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunPayouts(cfg, backend, false); err == nil {
+		t.Fatal("expect paused error")
+	}
+	// dry run is allowed while paused
+	if _, err := RunPayouts(cfg, backend, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.ResumePayouts(); err != nil {
+		t.Fatal(err)
+	}
+	run, err = RunPayouts(cfg, backend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Batches) != 2 || run.Batches[0].TxHash == "" || run.Batches[1].TxHash == "" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if txs := node.Transactions(); len(txs) != 2 {
+		t.Fatalf("expect 2 transactions, got %d", len(txs))
+	}
+	reports, err := backend.PayoutReports(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Miners != 12 || reports[0].Paid != 24*pool.XDAG || len(reports[0].Txs) != 2 {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+
+	// capped run defers the rest to next run
+	cfg.PayOut.MaxPayoutTxs = 1
+	for i := 0; i < 12; i++ {
+		miner, _ := newTestAddress(t)
+		mr.HSet("xdag:account:"+miner, "reward", "2000000000", "payment", "0", "unpaid", "2000000000")
+	}
+	if _, err := backend.IndexUnpaid(); err != nil {
+		t.Fatal(err)
+	}
+	run, err = RunPayouts(cfg, backend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Batches) != 1 || run.Deferred != 1 || run.DeferredAmount != 2*pool.XDAG || run.Stopped != StopMaxTxs {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	reports, _ = backend.PayoutReports(10)
+	if len(reports) != 2 || reports[0].Deferred != 1 || reports[0].Stopped != StopMaxTxs {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+}
@@ -0,0 +1,94 @@
+package payouts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/XDagger/xdagpool/xdago/base58"
+	"github.com/XDagger/xdagpool/xdago/cryptography"
+	"github.com/XDagger/xdagpool/xdago/secp256k1"
+	"github.com/XDagger/xdagpool/xdago/secp256k1/ecdsa"
+)
+
+const (
+	defaultMinThreshold = pool.XDAG
+	settingsMaxAge      = 10 * time.Minute // allowed clock difference of signed settings
+)
+
+// pool default and minimum payout threshold
+func Thresholds(cfg *pool.PayOutConfig) (threshold, minThreshold pool.Amount) {
+	threshold = pool.Amount(cfg.Threshold) * pool.XDAG
+	minThreshold = pool.AmountFromFloat(cfg.MinThreshold)
+	if minThreshold <= 0 {
+		minThreshold = defaultMinThreshold
+	}
+	return
+}
+
+// text signed by miner to change its settings
+func SettingsMessage(login string, s *kvstore.MinerSettings) string {
+	return fmt.Sprintf("xdagpool:%s:%s:%s:%d", login, s.Threshold, s.PayoutAddress, s.Timestamp)
+}
+
+// compact signature in hex of settings by the key of mining address
+func SignSettings(key *secp256k1.PrivateKey, login string, s *kvstore.MinerSettings) string {
+	hash := sha256.Sum256([]byte(SettingsMessage(login, s)))
+	return hex.EncodeToString(ecdsa.SignCompact(key, hash[:], true))
+}
+
+// check that settings are signed by the key of login address
+func VerifySettings(login string, s *kvstore.MinerSettings, signature string) error {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("signature is not hex")
+	}
+	hash := sha256.Sum256([]byte(SettingsMessage(login, s)))
+	pubKey, _, err := ecdsa.RecoverCompact(sig, hash[:])
+	if err != nil {
+		return err
+	}
+	address := cryptography.Sha256Hash160(pubKey.SerializeCompressed())
+	if base58.ChkEnc(address[:]) != login {
+		return errors.New("signature does not match miner address")
+	}
+	return nil
+}
+
+// validate and store signed settings of a miner
+func UpdateMinerSettings(cfg *pool.Config, backend kvstore.Store, login string, s *kvstore.MinerSettings, signature string) error {
+	if !util.ValidateAddress(login) {
+		return errors.New("miner address is invalid")
+	}
+	if s.PayoutAddress != "" && !ValidateBipAddress(s.PayoutAddress) {
+		return errors.New("payout address is invalid")
+	}
+	cfg.RLock()
+	_, minThreshold := Thresholds(&cfg.PayOut)
+	cfg.RUnlock()
+	if s.Threshold != 0 && s.Threshold < minThreshold {
+		return fmt.Errorf("threshold is less than pool minimum %s", minThreshold)
+	}
+	age := time.Since(time.Unix(s.Timestamp, 0))
+	if age > settingsMaxAge || age < -settingsMaxAge {
+		return errors.New("settings timestamp expired")
+	}
+
+	if err := VerifySettings(login, s, signature); err != nil {
+		return err
+	}
+
+	old, err := backend.GetMinerSettings(login)
+	if err != nil {
+		return err
+	}
+	if old != nil && s.Timestamp <= old.Timestamp {
+		return errors.New("settings timestamp is not newer than current")
+	}
+	return backend.SetMinerSettings(login, s)
+}
@@ -0,0 +1,52 @@
+package payouts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMinerSettings(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr()}, "xdag")
+	cfg := &pool.Config{PayOut: pool.PayOutConfig{Threshold: 3, MinThreshold: 0.5}}
+
+	miner, key := newTestAddress(t)
+	other, otherKey := newTestAddress(t)
+	s := &kvstore.MinerSettings{Threshold: pool.XDAG, PayoutAddress: other, Timestamp: time.Now().Unix()}
+
+	if err := UpdateMinerSettings(cfg, backend, miner, s, SignSettings(otherKey, miner, s)); err == nil {
+		t.Fatal("settings signed by other key accepted")
+	}
+	if err := UpdateMinerSettings(cfg, backend, miner, s, SignSettings(key, miner, s)); err != nil {
+		t.Fatal(err)
+	}
+	// replayed
+	if err := UpdateMinerSettings(cfg, backend, miner, s, SignSettings(key, miner, s)); err == nil {
+		t.Fatal("replayed settings accepted")
+	}
+	low := &kvstore.MinerSettings{Threshold: pool.XDAG / 10, Timestamp: s.Timestamp + 1}
+	if err := UpdateMinerSettings(cfg, backend, miner, low, SignSettings(key, miner, low)); err == nil {
+		t.Fatal("threshold below pool minimum accepted")
+	}
+
+	// own threshold 1 instead of pool 3, paid to other address
+	mr.HSet("xdag:account:"+miner, "unpaid", "2000000000")
+	mr.HSet("xdag:account:"+other, "unpaid", "2000000000")
+	if _, err := backend.IndexUnpaid(); err != nil {
+		t.Fatal(err)
+	}
+	miners := backend.GetMinersToPay(Thresholds(&cfg.PayOut))
+	if len(miners) != 1 || miners[0].Login != miner || miners[0].Address != other {
+		t.Fatalf("unexpected miners to pay %+v", miners)
+	}
+
+	// pool minimum raised above miner threshold
+	cfg.PayOut.MinThreshold = 2
+	if miners := backend.GetMinersToPay(Thresholds(&cfg.PayOut)); len(miners) != 0 {
+		t.Fatalf("unexpected miners to pay %+v", miners)
+	}
+}
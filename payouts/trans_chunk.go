@@ -6,6 +6,7 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/XDagger/xdagpool/pool"
 	"github.com/XDagger/xdagpool/util"
 	"github.com/XDagger/xdagpool/xdago/common"
 	"github.com/XDagger/xdagpool/xdago/cryptography"
@@ -14,40 +15,56 @@ import (
 )
 
 // batch transfer awards to miners
-func TransferChunkRpc(amount []int64, from string, to []string, remark string, key *secp256k1.PrivateKey) (string, error) {
+func TransferChunkRpc(amount []int64, fee int64, from string, to []string, remark string, key *secp256k1.PrivateKey) (string, error) {
+	blockHexStr, txHash, err := BuildChunkTx(amount, fee, from, to, remark, key)
+	if err != nil {
+		return "", err
+	}
+	err = SendTx(blockHexStr, txHash)
+	if err != nil {
+		return "", err
+	}
+	return txHash, nil
+}
 
-	blockHexStr, total := TransactionChunkBlock(from, to, remark, amount, key)
+// create and sign batch transaction block paying fee from input, return block hex and its hash
+func BuildChunkTx(amount []int64, fee int64, from string, to []string, remark string, key *secp256k1.PrivateKey) (string, string, error) {
+	blockHexStr, total := TransactionChunkBlock(from, to, remark, amount, fee, key)
 	util.Debug.Println(blockHexStr)
 	if blockHexStr == "" {
-		return "", errors.New("chunk create transaction block error")
+		return "", "", errors.New("chunk create transaction block error")
 	}
 
 	txHash := blockHash(blockHexStr)
-	util.Info.Println(from, float64(total)/float64(1e9), remark, "transaction:", txHash)
+	util.Info.Println(from, pool.Amount(total), "fee", pool.Amount(fee), remark, "transaction:", txHash)
+	return blockHexStr, txHash, nil
+}
 
+// send transaction block to node, node must return the block hash
+func SendTx(blockHexStr, txHash string) error {
 	hash, err := xdagjRpc("xdag_sendRawTransaction", blockHexStr)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	if hash == "" {
-		return "", errors.New("chunk transaction rpc return empty hash")
+		return errors.New("chunk transaction rpc return empty hash")
 	}
 
 	if !ValidateXdagAddress(hash) {
-		return "", errors.New(hash)
+		return &RpcError{Message: hash}
 	}
 
 	if hash != txHash {
 		util.Error.Println("want", txHash, "get", hash)
-		return "", errors.New("chunk transaction block hash error")
+		return errors.New("chunk transaction block hash error")
 	}
 
-	return hash, nil
+	return nil
 }
 
-// batch transactions block
-func TransactionChunkBlock(from string, to []string, remark string, value []int64, key *secp256k1.PrivateKey) (string, int64) {
+// batch transactions block, input covers outputs and fee
+func TransactionChunkBlock(from string, to []string, remark string, value []int64, fee int64, key *secp256k1.PrivateKey) (string, int64) {
 	if key == nil {
 		util.Error.Println("transaction default key error")
 		return "", 0
@@ -86,24 +103,32 @@ func TransactionChunkBlock(from string, to []string, remark string, value []int6
 		total += v
 	}
 
-	var amount float64
+	var amount uint64 // input covers the rounded outputs and fee exactly
 
 	valBytes := make([][8]byte, len(value))
 	for i, val := range value {
 		if val > 0 {
-			f := float64(val) / float64(1e9)
-			transVal := xdagoUtils.Xdag2Amount(f)
+			transVal := xdagoUtils.Nano2Amount(uint64(val))
 			binary.LittleEndian.PutUint64(valBytes[i][:], transVal)
-			amount += f
+			amount += transVal
 		} else {
 			util.Error.Println("transaction value is zero")
 			return "", 0
 		}
 	}
 
+	var feeBytes [8]byte
+	if fee < 0 {
+		util.Error.Println("transaction fee is negative")
+		return "", 0
+	} else if fee > 0 {
+		transFee := xdagoUtils.Nano2Amount(uint64(fee))
+		binary.LittleEndian.PutUint64(feeBytes[:], transFee)
+		amount += transFee
+	}
+
 	var amountBytes [8]byte
-	transVal := xdagoUtils.Xdag2Amount(amount)
-	binary.LittleEndian.PutUint64(amountBytes[:], transVal)
+	binary.LittleEndian.PutUint64(amountBytes[:], amount)
 
 	t := xdagoUtils.GetCurrentTimestamp()
 	var timeBytes [8]byte
@@ -122,7 +147,7 @@ func TransactionChunkBlock(from string, to []string, remark string, value []int6
 	// header: timestamp
 	sb.WriteString(hex.EncodeToString(timeBytes[:]))
 	// header: fee
-	sb.WriteString("0000000000000000")
+	sb.WriteString(hex.EncodeToString(feeBytes[:]))
 
 	// input field: input address
 	sb.WriteString(inAddress)
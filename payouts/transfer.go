@@ -13,6 +13,7 @@ import (
 
 	"github.com/XDagger/xdagpool/pool"
 	"github.com/XDagger/xdagpool/util"
+	"github.com/XDagger/xdagpool/ws"
 	xdagoUtils "github.com/XDagger/xdagpool/xdago/utils"
 
 	"golang.org/x/exp/utf8string"
@@ -26,8 +27,29 @@ import (
 
 var Cfg *pool.Config
 
+// error message returned by node, the request reached node and was refused
+type RpcError struct {
+	Message string
+}
+
+func (e *RpcError) Error() string {
+	return e.Message
+}
+
 func xdagjRpc(method string, params string) (string, error) {
-	url := Cfg.NodeRpc
+	body, err := xdagjRpcRaw(method, params)
+	if err != nil {
+		return "", err
+	}
+	return jsonparser.GetString(body, "result")
+}
+
+// call node rpc, return response body
+func xdagjRpcRaw(method string, params string) ([]byte, error) {
+	url := ws.ActiveRpc() // follow active upstream node
+	if url == "" {
+		url = Cfg.NodeRpc
+	}
 	//fmt.Println(url)
 	var sb strings.Builder
 	sb.WriteString(`{"jsonrpc":"2.0","id":1,"method":"`)
@@ -40,7 +62,7 @@ func xdagjRpc(method string, params string) (string, error) {
 	jsonData := []byte(sb.String())
 	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	request.Header.Set("Content-Type", "application/json")
 	client := &http.Client{
@@ -48,24 +70,24 @@ func xdagjRpc(method string, params string) (string, error) {
 	}
 	response, err := client.Do(request)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer response.Body.Close()
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	util.Info.Println(string(body))
 	errMsg, err := jsonparser.GetString(body, "error", "message")
 	if err == nil {
-		return "", errors.New(errMsg)
+		return nil, &RpcError{Message: errMsg}
 	}
-	return jsonparser.GetString(body, "result")
+	return body, nil
 }
 
-func TransferRpc(amount float64, from, to, remark string, key *secp256k1.PrivateKey) (string, error) {
+func TransferRpc(amount, fee pool.Amount, from, to, remark string, key *secp256k1.PrivateKey) (string, error) {
 
-	blockHexStr := transactionBlock(from, to, remark, amount, key)
+	blockHexStr := transactionBlock(from, to, remark, amount, fee, key)
 	util.Debug.Println(blockHexStr)
 	if blockHexStr == "" {
 		return "", errors.New("create transaction block error")
@@ -100,7 +122,8 @@ func BalanceRpc(address string) (string, error) {
 	return xdagjRpc("xdag_getBalance", address)
 }
 
-func transactionBlock(from, to, remark string, value float64, key *secp256k1.PrivateKey) string {
+// input covers value and fee
+func transactionBlock(from, to, remark string, value, fee pool.Amount, key *secp256k1.PrivateKey) string {
 	if key == nil {
 		util.Error.Println("transaction default key error")
 		return ""
@@ -136,10 +159,20 @@ func transactionBlock(from, to, remark string, value float64, key *secp256k1.Pri
 		}
 	}
 
-	var valBytes [8]byte
-	if value > 0.0 {
-		transVal := xdagoUtils.Xdag2Amount(value)
+	var valBytes, feeBytes, inBytes [8]byte
+	if value > 0 {
+		transVal := xdagoUtils.Nano2Amount(uint64(value))
 		binary.LittleEndian.PutUint64(valBytes[:], transVal)
+		if fee < 0 {
+			util.Error.Println("transaction fee is negative")
+			return ""
+		}
+		var transFee uint64
+		if fee > 0 {
+			transFee = xdagoUtils.Nano2Amount(uint64(fee))
+		}
+		binary.LittleEndian.PutUint64(feeBytes[:], transFee)
+		binary.LittleEndian.PutUint64(inBytes[:], transVal+transFee)
 	} else {
 		util.Error.Println("transaction value is zero")
 		return ""
@@ -162,12 +195,12 @@ func transactionBlock(from, to, remark string, value float64, key *secp256k1.Pri
 	// header: timestamp
 	sb.WriteString(hex.EncodeToString(timeBytes[:]))
 	// header: fee
-	sb.WriteString("0000000000000000")
+	sb.WriteString(hex.EncodeToString(feeBytes[:]))
 
 	// input field: input address
 	sb.WriteString(inAddress)
 	// input field: input value
-	sb.WriteString(hex.EncodeToString(valBytes[:]))
+	sb.WriteString(hex.EncodeToString(inBytes[:]))
 	// output field: output address
 	sb.WriteString(outAddress)
 	// output field: out value
@@ -0,0 +1,134 @@
+package pool
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is xdag in nano (1e-9 xdag), json encoded as an exact decimal number of xdag
+type Amount int64
+
+const (
+	Nano Amount = 1
+	XDAG Amount = 1e9
+)
+
+// parse decimal xdag text, more than 9 decimals is an error
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, errors.New("invalid amount: " + s)
+	}
+	if len(fracPart) > 9 {
+		if strings.TrimRight(fracPart[9:], "0") != "" {
+			return 0, errors.New("amount precision exceeds 1e-9: " + s)
+		}
+		fracPart = fracPart[:9]
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	fracPart += strings.Repeat("0", 9-len(fracPart))
+	i, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	f, _ := strconv.ParseInt(fracPart, 10, 64)
+	if i > (1<<63-1-f)/int64(XDAG) {
+		return 0, errors.New("amount overflow: " + s)
+	}
+	a := Amount(i*int64(XDAG) + f)
+	if neg {
+		a = -a
+	}
+	return a, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// amount of a float xdag value, rounded to 9 decimals (config values)
+func AmountFromFloat(f float64) Amount {
+	a, _ := ParseAmount(strconv.FormatFloat(f, 'f', 9, 64))
+	return a
+}
+
+// decimal xdag with 9 decimals
+func (a Amount) String() string {
+	sign := ""
+	n := uint64(a)
+	if a < 0 {
+		sign = "-"
+		n = uint64(-a)
+	}
+	frac := strconv.FormatUint(n%uint64(XDAG), 10)
+	return sign + strconv.FormatUint(n/uint64(XDAG), 10) + "." + strings.Repeat("0", 9-len(frac)) + frac
+}
+
+// approximate xdag, for display and hashrate-like statistics only
+func (a Amount) Xdag() float64 {
+	return float64(a) / float64(XDAG)
+}
+
+// a * num / den rounded down, without intermediate overflow
+func (a Amount) MulDiv(num, den int64) Amount {
+	if den == 0 {
+		return 0
+	}
+	x := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(num))
+	x.Quo(x, big.NewInt(den))
+	return Amount(x.Int64())
+}
+
+// percent of a rounded down, percent is a decimal config value like 5 or 2.5
+func (a Amount) Percent(percent float64) Amount {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(percent, 'f', -1, 64))
+	if !ok {
+		return 0
+	}
+	r.Mul(r, new(big.Rat).SetInt64(int64(a)))
+	r.Quo(r, big.NewRat(100, 1))
+	return Amount(new(big.Int).Quo(r.Num(), r.Denom()).Int64())
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// accept json number or string without going through float64
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" {
+		return nil
+	}
+	if strings.ContainsAny(s, "eE") {
+		// exponent form, e.g. 1e-9
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return errors.New("invalid amount: " + s)
+		}
+		r.Mul(r, new(big.Rat).SetInt64(int64(XDAG)))
+		if !r.IsInt() {
+			return errors.New("amount precision exceeds 1e-9: " + s)
+		}
+		*a = Amount(r.Num().Int64())
+		return nil
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
@@ -0,0 +1,63 @@
+package pool
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	cases := map[string]Amount{
+		"0":            0,
+		"1":            XDAG,
+		"0.1":          100000000,
+		".5":           500000000,
+		"64.000000000": 64 * XDAG,
+		"-1.000000001": -1000000001,
+		"1.5000000000": 1500000000,
+	}
+	for s, want := range cases {
+		a, err := ParseAmount(s)
+		if err != nil || a != want {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d", s, a, err, want)
+		}
+	}
+	for _, s := range []string{"", ".", "1.0000000001", "1e9", "--1", "1.-1", "99999999999999999999"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q) should fail", s)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var r XdagjReward
+	err := json.Unmarshal([]byte(`{"amount":64.1,"fee":"0.000000001","donate":1E-1}`), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Amount != 64100000000 || r.Fee != Nano || r.Donate != 100000000 {
+		t.Fatalf("unexpected reward %+v", r)
+	}
+	b, _ := json.Marshal(map[string]Amount{"a": -1, "b": 3 * XDAG})
+	if string(b) != `{"a":-0.000000001,"b":3.000000000}` {
+		t.Fatalf("unexpected json %s", b)
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	a := 64 * XDAG
+	if p := a.Percent(2.5); p != 1600000000 {
+		t.Errorf("2.5%% of 64 = %s", p)
+	}
+	if p := Amount(10).Percent(33.3); p != 3 {
+		t.Errorf("33.3%% of 10 nano = %d", p)
+	}
+	if m := a.MulDiv(1, 3); m != 21333333333 {
+		t.Errorf("64 / 3 = %s", m)
+	}
+	if m := Amount(1<<62).MulDiv(4, 8); m != 1<<61 {
+		t.Errorf("overflow in MulDiv: %d", m)
+	}
+	if f := AmountFromFloat(0.1); f != 100000000 {
+		t.Errorf("AmountFromFloat(0.1) = %d", f)
+	}
+}
@@ -9,6 +9,8 @@ const Version = "0.1.2"
 const PoolKey = "" // it can make pool boot/reboot without interfering.
 
 type StorageConfig struct {
+	Engine            string `json:"engine"` // "kvrocks" (default) or "bolt"
+	Path              string `json:"path"`   // bolt: database file
 	Endpoint          string `json:"endpoint"`
 	PasswordEncrypted string `json:"passwordEncrypted"`
 	Password          string `json:"-"`
@@ -17,14 +19,29 @@ type StorageConfig struct {
 }
 
 type PayOutConfig struct {
-	PoolRation      float64 `json:"poolRation"`
-	RewardRation    float64 `json:"rewardRation"`
-	DirectRation    float64 `json:"directRation"`
-	PoolFeeAddress  string  `json:"poolFeeAddress"`
-	Threshold       int64   `json:"threshold"`
-	PaymentInterval string  `json:"paymentInterval"`
-	Mode            string  `json:"mode"`
-	PaymentRemark   string  `json:"paymentRemark"`
+	PoolRation        float64 `json:"poolRation"`
+	RewardRation      float64 `json:"rewardRation"`
+	DirectRation      float64 `json:"directRation"`
+	PoolFeeAddress    string  `json:"poolFeeAddress"`
+	Threshold         int64   `json:"threshold"`
+	MinThreshold      float64 `json:"minThreshold"` // lowest threshold miners may set, default 1
+	PaymentInterval   string  `json:"paymentInterval"`
+	PaymentSchedule   string  `json:"paymentSchedule"` // cron spec in UTC, e.g. "0 0 * * *", overrides paymentInterval
+	MaxPayoutTotal    float64 `json:"maxPayoutTotal"`  // xdag paid in a payout run, 0 for no limit
+	MaxPayoutTxs      int     `json:"maxPayoutTxs"`    // transactions sent in a payout run, 0 for no limit
+	TxFee             float64 `json:"txFee"`           // xdag fee of a payout transaction
+	FeePolicy         string  `json:"feePolicy"`       // "pool" pays fee, "split" deducts it from recipients by amount
+	SigningDir        string  `json:"signingDir"`      // offline signing: payout batches are queued here for tools/signer, empty to sign in pool
+	Mode              string  `json:"mode"`
+	PaymentRemark     string  `json:"paymentRemark"`
+	PplnsShares       int64   `json:"pplnsShares"`       // pplns mode: last n shares, default 10000
+	PplnsWindow       string  `json:"pplnsWindow"`       // pplns mode: only shares within window, empty for no limit
+	PpsNetworkDiff    int64   `json:"ppsNetworkDiff"`    // pps/fpps mode: expected difficulty of a block
+	PpsBlockReward    float64 `json:"ppsBlockReward"`    // pps/fpps mode: xdag of a block, default 64
+	PpsReserve        float64 `json:"ppsReserve"`        // pps/fpps mode: buffer funded by pool operator
+	ConfirmInterval   string  `json:"confirmInterval"`   // poll node for payment confirmations, default 1m
+	ConfirmTimeout    string  `json:"confirmTimeout"`    // re-credit payments node does not know after timeout, default 1h
+	ReconcileInterval string  `json:"reconcileInterval"` // check ledger against wallet balance, default 1h
 }
 
 type Config struct {
@@ -36,6 +53,7 @@ type Config struct {
 	StratumTls       StratumTls `json:"stratumTls"`
 	EstimationWindow string     `json:"estimationWindow"`
 	LuckWindow       string     `json:"luckWindow"`
+	CandidateRounds  int64      `json:"candidateRounds"` // tasks to wait for reward of a submitted block, default 32
 	// LargeLuckWindow  string     `json:"largeLuckWindow"`
 
 	PurgeInterval string `json:"purgeInterval"`
@@ -52,8 +70,13 @@ type Config struct {
 	NodeRpc  string `json:"node_rpc"`
 	NodeWs   string `json:"node_ws"`
 	WsSsl    bool   `json:"ws_ssl"`
+	Nodes    []Node `json:"nodes"` // upstream nodes in order of priority
+
+	WsReconnect WsReconnect `json:"ws_reconnect"`
 
 	RxMode          string `json:"rx_mode"`
+	RxVms           int    `json:"rx_vms"`          // vms to verify shares concurrently, default threads
+	RxInitThreads   int    `json:"rx_init_threads"` // goroutines to initialize dataset of new seed, default 4
 	WalletEncrypted string `json:"walletEncrypted"`
 	WalletPswd      string `json:"-"`
 
@@ -67,10 +90,21 @@ type Stratum struct {
 }
 
 type Port struct {
-	Difficulty int64  `json:"diff"` //default 300000
-	Host       string `json:"host"`
-	Port       int    `json:"port"`
-	MaxConn    int    `json:"maxConn"`
+	Difficulty int64   `json:"diff"` //default 300000
+	MinDiff    int64   `json:"minDiff"`
+	MaxDiff    int64   `json:"maxDiff"` // 0 means no upper bound
+	Host       string  `json:"host"`
+	Port       int     `json:"port"`
+	MaxConn    int     `json:"maxConn"`
+	VarDiff    VarDiff `json:"varDiff"`
+}
+
+// variable difficulty, retarget every session by its share timing
+type VarDiff struct {
+	Enabled         bool    `json:"enabled"`
+	TargetTime      string  `json:"targetTime"`      // expected time between shares, default 15s
+	RetargetTime    string  `json:"retargetTime"`    // minimum time between retargets, default 90s
+	VariancePercent float64 `json:"variancePercent"` // allowed deviation from target time, default 30
 }
 
 type StratumTls struct {
@@ -88,6 +122,30 @@ type Upstream struct {
 	Timeout string `json:"timeout"`
 }
 
+// xdagj node with websocket for tasks and shares, http for rpc
+type Node struct {
+	Name string `json:"name"`
+	Ws   string `json:"ws"`
+	Rpc  string `json:"rpc"`
+	Ssl  bool   `json:"ssl"`
+}
+
+// websocket reconnection backoff and dead connection detection
+type WsReconnect struct {
+	MinBackoff   string `json:"minBackoff"`   // default 1s
+	MaxBackoff   string `json:"maxBackoff"`   // default 1m
+	PingInterval string `json:"pingInterval"` // default 30s
+	PongTimeout  string `json:"pongTimeout"`  // default 90s
+}
+
+// upstream nodes, single node_* options if no nodes configured
+func (c *Config) UpstreamNodes() []Node {
+	if len(c.Nodes) > 0 {
+		return c.Nodes
+	}
+	return []Node{{Name: c.NodeName, Ws: c.NodeWs, Rpc: c.NodeRpc, Ssl: c.WsSsl}}
+}
+
 type Frontend struct {
 	Enabled  bool   `json:"enabled"`
 	Listen   string `json:"listen"`
@@ -102,13 +160,13 @@ type Log struct {
 
 // ws reward message from xdaj
 type XdagjReward struct {
-	TxBlock     string  `json:"txBlock"`
-	PreHash     string  `json:"preHash"`
-	Share       string  `json:"share"`
-	Amount      float64 `json:"amount"`
-	Fee         float64 `json:"fee"`
-	DonateBlock string  `json:"donateBlock"`
-	Donate      float64 `json:"donate"`
+	TxBlock     string `json:"txBlock"`
+	PreHash     string `json:"preHash"`
+	Share       string `json:"share"`
+	Amount      Amount `json:"amount"`
+	Fee         Amount `json:"fee"`
+	DonateBlock string `json:"donateBlock"`
+	Donate      Amount `json:"donate"`
 }
 
 type Message struct {
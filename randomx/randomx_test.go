@@ -279,3 +279,50 @@ func BenchmarkCalculateHashLargePage(b *testing.B) {
 	randomx.DestroyVM(vm)
 	randomx.ReleaseDataset(ds)
 }
+
+// verify shares concurrently with a pool of vms sharing the same dataset,
+// throughput scales with the number of vms up to the number of cores.
+// go test -v -run=^$ -benchtime=10s -timeout 20m -bench=VMPool
+func BenchmarkCalculateHashVMPool(b *testing.B) {
+	rx := randomx.NewRxHash(1)
+	rx.NewSeed([]byte("123"))
+
+	counts := []int{1, 2, 4}
+	if n := runtime.NumCPU(); n > 4 {
+		counts = append(counts, n)
+	}
+	for _, n := range counts {
+		b.Run(fmt.Sprintf("vms-%d", n), func(b *testing.B) {
+			rx.SetVMCount(n)
+			b.SetParallelism(n)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rx.CalculateHash([]byte("123"))
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCalculateHashVMPoolLight(b *testing.B) {
+	rx := randomx.NewRxHash(1)
+	rx.NewSeedSlow([]byte("123"))
+
+	counts := []int{1, 2, 4}
+	if n := runtime.NumCPU(); n > 4 {
+		counts = append(counts, n)
+	}
+	for _, n := range counts {
+		b.Run(fmt.Sprintf("vms-%d", n), func(b *testing.B) {
+			rx.SetVMCount(n)
+			b.SetParallelism(n)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rx.CalculateHash([]byte("123"))
+				}
+			})
+		})
+	}
+}
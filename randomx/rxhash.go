@@ -3,76 +3,173 @@ package randomx
 import (
 	"encoding/hex"
 	"sync"
+	"time"
 )
 
+const defaultInitThreads = 4
+
+// cache, dataset and vms initialized with a seed
+type rxSeed struct {
+	seed    string
+	fullMem bool
+	cache   Cache
+	dataset Dataset
+	vms     chan VM       // idle vms sharing the same cache/dataset
+	ready   chan struct{} // closed when seed is prepared
+}
+
 type RxHash struct {
 	sync.RWMutex
 	CurrentSeed string
 	LastSeed    string
-	Cache       Cache
-	Dataset     Dataset
-	Vm          VM
+
+	current   *rxSeed
+	last      *rxSeed            // previous seed, kept until its jobs expire
+	preparing map[string]*rxSeed // seeds initializing in background
+	buildMu   sync.Mutex         // one seed initializing at a time
+
+	vmCount     int
+	initThreads int
+
+	switchedAt     int64         // ms
+	switchDuration time.Duration // time to prepare last switched seed
+}
+
+type RxStats struct {
+	CurrentSeed    string `json:"currentSeed"`
+	LastSeed       string `json:"lastSeed"`
+	Preparing      int    `json:"preparing"`
+	Vms            int    `json:"vms"`
+	SwitchedAt     int64  `json:"switchedAt"`
+	SwitchDuration int64  `json:"switchDuration"` // ms
 }
 
 var Rx *RxHash
 
 func init() {
-	Rx = &RxHash{}
-	flags := GetFlags()
-	cache, _ := AllocCache(flags)
-	ds, _ := AllocDataset(flags)
-	Rx.Cache = cache
-	Rx.Dataset = ds
+	Rx = NewRxHash(1)
 }
 
-func (h *RxHash) NewSeed(seed []byte) {
+func NewRxHash(vmCount int) *RxHash {
+	if vmCount < 1 {
+		vmCount = 1
+	}
+	return &RxHash{vmCount: vmCount, initThreads: defaultInitThreads,
+		preparing: make(map[string]*rxSeed)}
+}
+
+// set the number of vms of a seed which can calculate hash concurrently
+func (h *RxHash) SetVMCount(n int) {
 	h.Lock()
 	defer h.Unlock()
-
-	if h.CurrentSeed == hex.EncodeToString(seed) {
+	if n < 1 {
+		n = 1
+	}
+	if n == h.vmCount {
 		return
 	}
+	h.vmCount = n
+	if h.current != nil {
+		h.current.createVMs(n)
+	}
+	if h.last != nil {
+		h.last.createVMs(n)
+	}
+}
 
-	h.LastSeed = h.CurrentSeed
-	h.CurrentSeed = hex.EncodeToString(seed)
-	flags := GetFlags()
-	InitCache(h.Cache, seed)
-
-	count := DatasetItemCount()
+func (h *RxHash) VMCount() int {
+	h.RLock()
+	defer h.RUnlock()
+	return h.vmCount
+}
 
-	var wg sync.WaitGroup
-	var workerNum = uint32(4)
-	for i := uint32(0); i < workerNum; i++ {
-		wg.Add(1)
-		a := (count * i) / workerNum
-		b := (count * (i + 1)) / workerNum
-		go func() {
-			defer wg.Done()
-			InitDataset(h.Dataset, h.Cache, a, b-a)
-		}()
+// set the number of goroutines to initialize dataset of a new seed
+func (h *RxHash) SetInitThreads(n int) {
+	h.Lock()
+	defer h.Unlock()
+	if n < 1 {
+		n = defaultInitThreads
 	}
-	wg.Wait()
+	h.initThreads = n
+}
 
-	vm, _ := CreateVM(h.Cache, h.Dataset, flags, FlagFullMEM)
-	DestroyVM(h.Vm)
-	h.Vm = vm
+// switch to seed in fast mode (dataset in memory), return when seed is ready
+func (h *RxHash) NewSeed(seed []byte) {
+	<-h.PrepareSeed(seed, true)
 }
 
+// switch to seed in light mode (cache only), return when seed is ready
 func (h *RxHash) NewSeedSlow(seed []byte) {
+	<-h.PrepareSeed(seed, false)
+}
+
+// initialize seed in background and swap it in as current seed when ready,
+// hashing with current and last seed continues meanwhile.
+// The returned channel is closed when the seed is current.
+func (h *RxHash) PrepareSeed(seed []byte, fullMem bool) <-chan struct{} {
 	h.Lock()
 	defer h.Unlock()
-	if h.CurrentSeed == hex.EncodeToString(seed) {
-		return
+
+	seedHex := hex.EncodeToString(seed)
+	if h.current != nil && h.current.seed == seedHex {
+		return h.current.ready
+	}
+	if s, ok := h.preparing[seedHex]; ok {
+		return s.ready
 	}
 
-	h.LastSeed = h.CurrentSeed
-	h.CurrentSeed = hex.EncodeToString(seed)
-	flags := GetFlags()
-	InitCache(h.Cache, seed)
+	if h.last != nil && h.last.seed == seedHex { // switch back to previous seed
+		h.current, h.last = h.last, h.current
+		h.LastSeed = h.CurrentSeed
+		h.CurrentSeed = seedHex
+		return h.current.ready
+	}
+
+	s := &rxSeed{seed: seedHex, fullMem: fullMem, ready: make(chan struct{})}
+	h.preparing[seedHex] = s
+	vmCount := h.vmCount
+	initThreads := h.initThreads
+
+	go func() {
+		h.buildMu.Lock()
+		defer h.buildMu.Unlock()
+
+		start := time.Now()
+		err := s.init(seed, vmCount, initThreads)
+
+		h.Lock()
+		defer h.Unlock()
+		delete(h.preparing, seedHex)
+		if err != nil {
+			close(s.ready)
+			return
+		}
+		if s.vms == nil || cap(s.vms) != h.vmCount {
+			s.createVMs(h.vmCount)
+		}
+		if h.last != nil {
+			h.last.release()
+		}
+		h.last = h.current
+		h.current = s
+		h.LastSeed = h.CurrentSeed
+		h.CurrentSeed = seedHex
+		h.switchDuration = time.Since(start)
+		h.switchedAt = time.Now().UnixNano() / int64(time.Millisecond)
+		close(s.ready)
+	}()
+	return s.ready
+}
 
-	vm, _ := CreateVM(h.Cache, nil, flags)
-	DestroyVM(h.Vm)
-	h.Vm = vm
+// release previous seed when no job of it is valid
+func (h *RxHash) ReleaseLast() {
+	h.Lock()
+	defer h.Unlock()
+	if h.last == nil {
+		return
+	}
+	h.last.release()
+	h.last = nil
 }
 
 func (h *RxHash) IsCurrentSeed(seed string) bool {
@@ -81,8 +178,137 @@ func (h *RxHash) IsCurrentSeed(seed string) bool {
 	return h.CurrentSeed == seed
 }
 
+func (h *RxHash) Stats() RxStats {
+	h.RLock()
+	defer h.RUnlock()
+	return RxStats{
+		CurrentSeed:    h.CurrentSeed,
+		LastSeed:       h.LastSeed,
+		Preparing:      len(h.preparing),
+		Vms:            h.vmCount,
+		SwitchedAt:     h.switchedAt,
+		SwitchDuration: int64(h.switchDuration / time.Millisecond),
+	}
+}
+
+// calculate hash with current seed
 func (h *RxHash) CalculateHash(input []byte) []byte {
-	h.Lock()
-	defer h.Unlock()
-	return CalculateHash(h.Vm, input)
+	h.RLock()
+	defer h.RUnlock()
+	return h.current.calculateHash(input)
+}
+
+// calculate hash with the seed of a job, current or previous one.
+// wait for the seed if it is initializing in background.
+func (h *RxHash) CalculateSeedHash(seed []byte, input []byte) []byte {
+	seedHex := hex.EncodeToString(seed)
+	h.RLock()
+	s, ok := h.preparing[seedHex]
+	h.RUnlock()
+	if ok {
+		<-s.ready
+	}
+
+	h.RLock()
+	defer h.RUnlock()
+	if h.current != nil && h.current.seed == seedHex {
+		return h.current.calculateHash(input)
+	}
+	if h.last != nil && h.last.seed == seedHex {
+		return h.last.calculateHash(input)
+	}
+	return nil
+}
+
+func (s *rxSeed) init(seed []byte, vmCount, initThreads int) error {
+	flags := GetFlags()
+	cache, err := AllocCache(flags)
+	if err != nil {
+		return err
+	}
+	InitCache(cache, seed)
+	s.cache = cache
+
+	if s.fullMem {
+		ds, err := AllocDataset(flags)
+		if err != nil {
+			ReleaseCache(cache)
+			s.cache = nil
+			return err
+		}
+		count := DatasetItemCount()
+
+		var wg sync.WaitGroup
+		var workerNum = uint32(initThreads)
+		for i := uint32(0); i < workerNum; i++ {
+			wg.Add(1)
+			a := (count * i) / workerNum
+			b := (count * (i + 1)) / workerNum
+			go func() {
+				defer wg.Done()
+				InitDataset(ds, cache, a, b-a)
+			}()
+		}
+		wg.Wait()
+		s.dataset = ds
+	}
+
+	s.createVMs(vmCount)
+	return nil
+}
+
+// replace all vms, no vm of the seed should be in use
+func (s *rxSeed) createVMs(n int) {
+	flags := GetFlags()
+	vms := make(chan VM, n)
+	for i := 0; i < n; i++ {
+		var vm VM
+		if s.dataset != nil {
+			vm, _ = CreateVM(s.cache, s.dataset, flags, FlagFullMEM)
+		} else {
+			vm, _ = CreateVM(s.cache, nil, flags)
+		}
+		if vm != nil {
+			vms <- vm
+		}
+	}
+	s.destroyVMs()
+	if len(vms) > 0 {
+		s.vms = vms
+	}
+}
+
+func (s *rxSeed) destroyVMs() {
+	if s.vms == nil {
+		return
+	}
+	close(s.vms)
+	for vm := range s.vms {
+		DestroyVM(vm)
+	}
+	s.vms = nil
+}
+
+func (s *rxSeed) release() {
+	s.destroyVMs()
+	if s.dataset != nil {
+		ReleaseDataset(s.dataset)
+		s.dataset = nil
+	}
+	if s.cache != nil {
+		ReleaseCache(s.cache)
+		s.cache = nil
+	}
+}
+
+// take an idle vm from pool, block until one is returned if all are busy
+func (s *rxSeed) calculateHash(input []byte) []byte {
+	if s == nil || s.vms == nil {
+		return nil
+	}
+	vm := <-s.vms
+	defer func() {
+		s.vms <- vm
+	}()
+	return CalculateHash(vm, input)
 }
@@ -4,9 +4,26 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"testing"
 )
 
+// rx hash of a test, its seeds are released when the test ends
+func newTestRx(t *testing.T, vmCount int) *RxHash {
+	rx := NewRxHash(vmCount)
+	t.Cleanup(func() {
+		rx.Lock()
+		defer rx.Unlock()
+		for _, s := range []*rxSeed{rx.current, rx.last} {
+			if s != nil {
+				s.release()
+			}
+		}
+		rx.current, rx.last = nil, nil
+	})
+	return rx
+}
+
 func TestRx(t *testing.T) {
 	Rx.NewSeed([]byte("test key 000"))
 	correct, _ := hex.DecodeString("639183aae1bf4c9a35884cb46b09cad9175f04efd7684e7262a0ac1c2f0b4e3f")
@@ -34,3 +51,88 @@ func TestRxSlow(t *testing.T) {
 	}
 	fmt.Println("end")
 }
+
+func TestRxConcurrent(t *testing.T) {
+	rx := newTestRx(t, 4)
+	rx.NewSeedSlow([]byte("test key 000"))
+	correct, _ := hex.DecodeString("639183aae1bf4c9a35884cb46b09cad9175f04efd7684e7262a0ac1c2f0b4e3f")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				hash := rx.CalculateHash([]byte("This is a test"))
+				if !bytes.Equal(hash, correct) {
+					t.Errorf("answer is incorrect: %x, %x", hash, correct)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// resize pool while seed is loaded
+	rx.SetVMCount(2)
+	hash := rx.CalculateHash([]byte("This is a test"))
+	if !bytes.Equal(hash, correct) {
+		t.Errorf("answer is incorrect after resize: %x, %x", hash, correct)
+	}
+}
+
+func TestRxLastSeed(t *testing.T) {
+	rx := newTestRx(t, 2)
+	rx.NewSeedSlow([]byte("test key 000"))
+	correct, _ := hex.DecodeString("639183aae1bf4c9a35884cb46b09cad9175f04efd7684e7262a0ac1c2f0b4e3f")
+
+	rx.NewSeedSlow([]byte("test key 001"))
+	if rx.LastSeed != hex.EncodeToString([]byte("test key 000")) {
+		t.Errorf("last seed error: %s", rx.LastSeed)
+	}
+	// share of a job with previous seed
+	hash := rx.CalculateSeedHash([]byte("test key 000"), []byte("This is a test"))
+	if !bytes.Equal(hash, correct) {
+		t.Errorf("answer is incorrect with last seed: %x, %x", hash, correct)
+	}
+	if bytes.Equal(rx.CalculateHash([]byte("This is a test")), correct) {
+		t.Error("current seed should give another hash")
+	}
+
+	rx.ReleaseLast()
+	if rx.CalculateSeedHash([]byte("test key 000"), []byte("This is a test")) != nil {
+		t.Error("released seed should not calculate hash")
+	}
+	if rx.CalculateSeedHash([]byte("test key 001"), []byte("This is a test")) == nil {
+		t.Error("current seed should calculate hash")
+	}
+}
+
+func TestRxPrepareSeed(t *testing.T) {
+	rx := newTestRx(t, 2)
+	rx.SetInitThreads(2)
+	rx.NewSeedSlow([]byte("test key 000"))
+	correct, _ := hex.DecodeString("639183aae1bf4c9a35884cb46b09cad9175f04efd7684e7262a0ac1c2f0b4e3f")
+
+	ready := rx.PrepareSeed([]byte("test key 001"), false)
+	// old seed is still current while new seed is initializing
+	hash := rx.CalculateSeedHash([]byte("test key 000"), []byte("This is a test"))
+	if !bytes.Equal(hash, correct) {
+		t.Errorf("answer is incorrect while preparing: %x, %x", hash, correct)
+	}
+	// share of new seed waits for it
+	if rx.CalculateSeedHash([]byte("test key 001"), []byte("This is a test")) == nil {
+		t.Error("preparing seed should calculate hash when ready")
+	}
+	<-ready
+	stats := rx.Stats()
+	if stats.CurrentSeed != hex.EncodeToString([]byte("test key 001")) || stats.Preparing != 0 {
+		t.Errorf("seed not switched: %+v", stats)
+	}
+	if stats.SwitchedAt == 0 {
+		t.Errorf("switch time not recorded: %+v", stats)
+	}
+	if !bytes.Equal(rx.CalculateSeedHash([]byte("test key 000"), []byte("This is a test")), correct) {
+		t.Error("last seed should still calculate hash")
+	}
+}
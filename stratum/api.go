@@ -11,7 +11,10 @@ import (
 	"time"
 
 	"github.com/XDagger/xdagpool/jrpc"
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/payouts"
 	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/randomx"
 	"github.com/XDagger/xdagpool/util"
 	"github.com/XDagger/xdagpool/ws"
 	"github.com/gorilla/mux"
@@ -32,9 +35,21 @@ func (s *StratumServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 		"now":         util.MakeTimestamp(),
 	}
 
-	stats["upstream"] = ws.Client.Url
-	// stats["luck"] = s.getLuckStats()
-	// stats["blocks"] = s.getBlocksStats()
+	stats["upstream"] = ws.ActiveName()
+	stats["upstreams"] = ws.UpstreamsStats()
+	stats["submits"] = ws.SubmitStats()
+	s.config.RLock()
+	mode := s.config.PayOut.Mode
+	reserve := payouts.PpsReserve(&s.config.PayOut)
+	s.config.RUnlock()
+	if payouts.IsPpsMode(mode) {
+		if pps, err := s.backend.GetPpsStats(reserve); err == nil {
+			stats["pps"] = pps
+		}
+	}
+	stats["randomx"] = randomx.Rx.Stats()
+	stats["luck"] = s.getLuckStats()
+	stats["blocks"] = s.getBlocksStats()
 
 	if t := s.currentBlockTemplate(); t != nil {
 		// stats["height"] = t.height
@@ -102,66 +117,27 @@ func (s *StratumServer) collectMinersStats() (float64, float64, int, []interface
 	return totalhashrate, totalhashrate24h, totalOnline, result
 }
 
-// func (s *StratumServer) getLuckStats() map[string]interface{} {
-// 	now := util.MakeTimestamp()
-// 	var variance float64
-// 	var totalVariance float64
-// 	var blocksCount int
-// 	var totalBlocksCount int
-
-// 	s.blocksMu.Lock()
-// 	defer s.blocksMu.Unlock()
-
-// 	for k, v := range s.blockStats {
-// 		if k >= now-s.luckWindow {
-// 			blocksCount++
-// 			variance += v.variance
-// 		}
-// 		if k >= now-s.luckLargeWindow {
-// 			totalBlocksCount++
-// 			totalVariance += v.variance
-// 		} else {
-// 			delete(s.blockStats, k)
-// 		}
-// 	}
-// 	if blocksCount != 0 {
-// 		variance = variance / float64(blocksCount)
-// 	}
-// 	if totalBlocksCount != 0 {
-// 		totalVariance = totalVariance / float64(totalBlocksCount)
-// 	}
-// 	result := make(map[string]interface{})
-// 	result["variance"] = variance
-// 	result["blocksCount"] = blocksCount
-// 	result["window"] = s.config.LuckWindow
-// 	result["totalVariance"] = totalVariance
-// 	result["totalBlocksCount"] = totalBlocksCount
-// 	result["largeWindow"] = s.config.LargeLuckWindow
-// 	return result
-// }
+const statsBlocks = 50 // latest blocks in stats
 
-// func (s *StratumServer) getBlocksStats() []interface{} {
-// 	now := util.MakeTimestamp()
-// 	var result []interface{}
-
-// 	s.blocksMu.Lock()
-// 	defer s.blocksMu.Unlock()
-
-// 	for k, v := range s.blockStats {
-// 		if k >= now-int64(s.luckLargeWindow) {
-// 			block := map[string]interface{}{
-// 				"height":    v.height,
-// 				"hash":      v.hash,
-// 				"variance":  v.variance,
-// 				"timestamp": k,
-// 			}
-// 			result = append(result, block)
-// 		} else {
-// 			delete(s.blockStats, k)
-// 		}
-// 	}
-// 	return result
-// }
+// luck of blocks found within luck window
+func (s *StratumServer) getLuckStats() *kvstore.LuckStats {
+	window := time.Duration(s.luckWindow) * time.Millisecond
+	blocks, err := s.backend.BlocksSince((util.MakeTimestamp() - s.luckWindow) / 1000)
+	if err != nil {
+		util.Error.Println("get luck stats error", err)
+		return nil
+	}
+	return kvstore.NewLuckStats(blocks, window)
+}
+
+func (s *StratumServer) getBlocksStats() []kvstore.BlockData {
+	_, blocks, err := s.backend.GetBlocks(0, statsBlocks-1)
+	if err != nil {
+		util.Error.Println("get blocks stats error", err)
+		return nil
+	}
+	return blocks
+}
 
 func (s *StratumServer) PoolDonateList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -663,17 +639,21 @@ func (s *StratumServer) XdagPoolConfig(id uint64, params json.RawMessage) jrpc.R
 		rec.GlobalMinerLimit = s.config.Stratum.Ports[0].MaxConn
 	}
 
-	n := strings.LastIndex(s.config.NodeRpc, ":")
-	if n > 0 && n < len(s.config.NodeRpc)-1 {
-		port, err := strconv.Atoi(s.config.NodeRpc[n+1:])
+	nodeRpc := ws.ActiveRpc()
+	if nodeRpc == "" {
+		nodeRpc = s.config.NodeRpc
+	}
+	n := strings.LastIndex(nodeRpc, ":")
+	if n > 0 && n < len(nodeRpc)-1 {
+		port, err := strconv.Atoi(nodeRpc[n+1:])
 		if err == nil {
 			rec.NodePort = port
-			rec.NodeIP = (s.config.NodeRpc[:n])
+			rec.NodeIP = (nodeRpc[:n])
 		} else {
-			rec.NodeIP = s.config.NodeRpc
+			rec.NodeIP = nodeRpc
 		}
 	} else {
-		rec.NodeIP = s.config.NodeRpc
+		rec.NodeIP = nodeRpc
 	}
 
 	rec.MaxConnectMinerPerIP = 0
@@ -755,17 +735,17 @@ func (s *StratumServer) XdagUpdatePoolConfig(id uint64, params json.RawMessage)
 type XdagPoolMiners struct {
 	Address      string       `json:"address"`
 	Status       string       `json:"status"`
-	UnpaidShares float64      `json:"unpaidShares"`
+	UnpaidShares pool.Amount  `json:"unpaidShares"`
 	Hashrate     float64      `json:"hashrate"`
 	Workers      []XdagWorker `json:"workers"`
 }
 type XdagWorker struct {
-	Address      string  `json:"address"`
-	InBound      int     `json:"inBound"`
-	OutBound     int     `json:"outBound"`
-	UnpaidShares float64 `json:"unpaidShares"`
-	Name         string  `json:"name"`
-	Hashrate     float64 `json:"hashrate"`
+	Address      string      `json:"address"`
+	InBound      int         `json:"inBound"`
+	OutBound     int         `json:"outBound"`
+	UnpaidShares pool.Amount `json:"unpaidShares"`
+	Name         string      `json:"name"`
+	Hashrate     float64     `json:"hashrate"`
 }
 
 func (s *StratumServer) XdagGetPoolWorkers(id uint64, params json.RawMessage) jrpc.Response {
@@ -834,11 +814,11 @@ func (s *StratumServer) XdagGetPoolWorkers(id uint64, params json.RawMessage) jr
 }
 
 type MinerAccount struct {
-	Address      string  `json:"address"`
-	Timestamp    int64   `json:"timestamp"`
-	TotalReward  float64 `json:"total_reward"`
-	TotalPayment float64 `json:"total_payment"`
-	TotalUnpaid  float64 `json:"total_unpaid"`
+	Address      string      `json:"address"`
+	Timestamp    int64       `json:"timestamp"`
+	TotalReward  pool.Amount `json:"total_reward"`
+	TotalPayment pool.Amount `json:"total_payment"`
+	TotalUnpaid  pool.Amount `json:"total_unpaid"`
 }
 
 func (s *StratumServer) XdagMinerAccount(id uint64, params json.RawMessage) jrpc.Response {
@@ -1043,3 +1023,275 @@ func (s *StratumServer) XdagPoolHashrate(id uint64, params json.RawMessage) jrpc
 func (s *StratumServer) XdagPoolVersion(id uint64, params json.RawMessage) jrpc.Response {
 	return jrpc.EncodeResponse(id, pool.Version, nil)
 }
+
+// compare kv store ledger with pool wallet balance on chain
+func (s *StratumServer) XdagReconcile(id uint64, params json.RawMessage) jrpc.Response {
+	r, err := payouts.Reconcile(s.config, s.backend)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	return jrpc.EncodeResponse(id, r, nil)
+}
+
+type MinerSettings struct {
+	Address       string      `json:"address"`
+	Threshold     pool.Amount `json:"threshold"` // effective threshold
+	PayoutAddress string      `json:"payoutAddress"`
+	Timestamp     int64       `json:"timestamp"`
+}
+
+func (s *StratumServer) XdagMinerSettings(id uint64, params json.RawMessage) jrpc.Response {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 1 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	address := args[0]
+	if address == "" || !util.ValidateAddress(address) {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("addres is empty or invalid"))
+	}
+	settings, err := s.backend.GetMinerSettings(address)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	s.config.RLock()
+	threshold, minThreshold := payouts.Thresholds(&s.config.PayOut)
+	s.config.RUnlock()
+
+	rec := MinerSettings{
+		Address:       address,
+		Threshold:     settings.EffectiveThreshold(threshold, minThreshold),
+		PayoutAddress: address,
+	}
+	if settings != nil {
+		rec.Timestamp = settings.Timestamp
+		if settings.PayoutAddress != "" {
+			rec.PayoutAddress = settings.PayoutAddress
+		}
+	}
+	return jrpc.EncodeResponse(id, rec, nil)
+}
+
+// params: address, threshold (xdag, "0" for pool default), payout address ("" for mining address),
+// unix timestamp, signature of the mining address key
+func (s *StratumServer) XdagSetMinerSettings(id uint64, params json.RawMessage) jrpc.Response {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 5 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	var address, payoutAddress, signature string
+	var settings kvstore.MinerSettings
+	if err := json.Unmarshal(args[0], &address); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if err := json.Unmarshal(args[1], &settings.Threshold); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if err := json.Unmarshal(args[2], &payoutAddress); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if err := json.Unmarshal(args[3], &settings.Timestamp); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if err := json.Unmarshal(args[4], &signature); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	settings.PayoutAddress = payoutAddress
+
+	err := payouts.UpdateMinerSettings(s.config, s.backend, address, &settings, signature)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	return jrpc.EncodeResponse(id, "Success", nil)
+}
+
+// admin params: dry run, password
+func (s *StratumServer) XdagRunPayouts(id uint64, params json.RawMessage) jrpc.Response {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 2 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	var dryRun bool
+	var password string
+	if err := json.Unmarshal(args[1], &password); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if !util.ValidatePasswd(s.config.AddressEncrypted, password) {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("password error"))
+	}
+	if err := json.Unmarshal(args[0], &dryRun); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	run, err := payouts.RunPayouts(s.config, s.backend, dryRun)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	return jrpc.EncodeResponse(id, run, nil)
+}
+
+// admin params: reason, password
+func (s *StratumServer) XdagPausePayouts(id uint64, params json.RawMessage) jrpc.Response {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 2 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	if !util.ValidatePasswd(s.config.AddressEncrypted, args[1]) {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("password error"))
+	}
+	if err := s.backend.PausePayouts(args[0]); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	util.Warn.Println("payouts paused:", args[0])
+	return jrpc.EncodeResponse(id, "Success", nil)
+}
+
+// admin params: password
+func (s *StratumServer) XdagResumePayouts(id uint64, params json.RawMessage) jrpc.Response {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 1 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	if !util.ValidatePasswd(s.config.AddressEncrypted, args[0]) {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("password error"))
+	}
+	if err := s.backend.ResumePayouts(); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	util.Info.Println("payouts resumed")
+	return jrpc.EncodeResponse(id, "Success", nil)
+}
+
+func (s *StratumServer) XdagPayoutsState(id uint64, params json.RawMessage) jrpc.Response {
+	state, err := s.backend.GetPayoutsState()
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	return jrpc.EncodeResponse(id, state, nil)
+}
+
+// params: number of latest runs, at most 100
+func (s *StratumServer) XdagPayoutReports(id uint64, params json.RawMessage) jrpc.Response {
+	var args []int64
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 1 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	n := args[0]
+	if n <= 0 || n > 100 {
+		n = 100
+	}
+	list, err := s.backend.PayoutReports(n)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	return jrpc.EncodeResponse(id, list, nil)
+}
+
+type PoolBlocks struct {
+	Total  int64               `json:"total"`
+	Page   int64               `json:"page"`
+	Blocks []kvstore.BlockData `json:"blocks"`
+}
+
+// found blocks newest first, params: [page, pageSize]
+func (s *StratumServer) XdagPoolBlocks(id uint64, params json.RawMessage) jrpc.Response {
+	var args []int64
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 2 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	page, pageSize := args[0], args[1]
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	start := (page - 1) * pageSize
+	total, blocks, err := s.backend.GetBlocks(start, start+pageSize-1)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	return jrpc.EncodeResponse(id, PoolBlocks{Total: total, Page: page, Blocks: blocks}, nil)
+}
+
+func (s *StratumServer) XdagPoolLuck(id uint64, params json.RawMessage) jrpc.Response {
+	luck := s.getLuckStats()
+	if luck == nil {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("luck stats not available"))
+	}
+	return jrpc.EncodeResponse(id, luck, nil)
+}
+
+type HashrateSeries struct {
+	Series     string                  `json:"series"`
+	Resolution string                  `json:"resolution"`
+	Bucket     int64                   `json:"bucket"` // bucket seconds
+	Points     []kvstore.HashratePoint `json:"points"`
+}
+
+func (s *StratumServer) shareSeries(id uint64, series, resolution string) jrpc.Response {
+	res, err := kvstore.FindResolution(resolution)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	points, err := s.backend.ShareSeries(series, res)
+	if err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	return jrpc.EncodeResponse(id, HashrateSeries{
+		Series:     series,
+		Resolution: res.Name,
+		Bucket:     int64(res.Bucket / time.Second),
+		Points:     points,
+	}, nil)
+}
+
+// pool share series, params: [resolution]
+func (s *StratumServer) XdagPoolHashrateSeries(id uint64, params json.RawMessage) jrpc.Response {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 1 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	return s.shareSeries(id, kvstore.SeriesPool, args[0])
+}
+
+// miner or worker share series, params: [address, worker, resolution], empty worker for whole miner
+func (s *StratumServer) XdagMinerHashrateSeries(id uint64, params json.RawMessage) jrpc.Response {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return jrpc.EncodeResponse(id, struct{}{}, err)
+	}
+	if len(args) != 3 {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("params length error"))
+	}
+	address, worker := args[0], args[1]
+	if address == "" || !util.ValidateAddress(address) {
+		return jrpc.EncodeResponse(id, struct{}{}, errors.New("addres is empty or invalid"))
+	}
+	if worker == "" {
+		return s.shareSeries(id, kvstore.MinerSeries(address), args[2])
+	}
+	return s.shareSeries(id, kvstore.WorkerSeries(address, worker), args[2])
+}
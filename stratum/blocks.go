@@ -8,6 +8,7 @@ import (
 	"github.com/XDagger/xdagpool/pool"
 	"github.com/XDagger/xdagpool/randomx"
 	"github.com/XDagger/xdagpool/util"
+	"github.com/XDagger/xdagpool/ws"
 	"github.com/XDagger/xdagpool/xdago/base58"
 )
 
@@ -62,7 +63,7 @@ func (s *StratumServer) fetchBlockTemplate(msg json.RawMessage) bool {
 		return false
 		// }
 	} else {
-		util.Info.Printf("New block to mine on %s at jobHash %s,  timestamp: %v", s.config.NodeName, reply.Data.PreHash, reply.Timestamp)
+		util.Info.Printf("New block to mine on %s at jobHash %s,  timestamp: %v", ws.ActiveName(), reply.Data.PreHash, reply.Timestamp)
 	}
 	// s.backend.AddWaiting(reply.Data.PreHash)
 
@@ -79,11 +80,12 @@ func (s *StratumServer) fetchBlockTemplate(msg json.RawMessage) bool {
 	newTemplate.buffer, _ = hex.DecodeString(reply.Data.PreHash)
 
 	if t == nil || reply.Data.TashSeed != hex.EncodeToString(t.seedHash) {
-		if s.config.RxMode == "fast" {
-			randomx.Rx.NewSeed(newTemplate.seedHash)
-		} else {
-			randomx.Rx.NewSeedSlow(newTemplate.seedHash)
-		}
+		// initialize new seed in background, shares of new jobs wait for it
+		// while shares of previous seed are still verified
+		randomx.Rx.PrepareSeed(newTemplate.seedHash, s.config.RxMode == "fast")
+	} else {
+		// previous template is already on current seed, no valid job uses last seed
+		randomx.Rx.ReleaseLast()
 	}
 	// newTemplate.nextSeedHash, _ = hex.DecodeString(reply.NextSeedHash)
 
@@ -111,5 +113,18 @@ func (s *StratumServer) fetchBlockTemplate(msg json.RawMessage) bool {
 	// newTemplate.txTotalFee = reply.ExpectedReward - newTemplate.blockReward
 
 	s.blockTemplate.Store(&newTemplate)
+	s.expireCandidates()
 	return true
 }
+
+// candidates never rewarded within candidateRounds tasks are lost
+func (s *StratumServer) expireCandidates() {
+	n, err := s.backend.ExpireCandidates(s.candidateRounds, util.MakeTimestamp())
+	if err != nil {
+		util.Error.Println("Failed to expire block candidates in backend:", err)
+		return
+	}
+	if n > 0 {
+		util.BlockLog.Printf("Closed %d block candidates without reward after %d tasks", n, s.candidateRounds)
+	}
+}
@@ -0,0 +1,192 @@
+package stratum
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/payouts"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/XDagger/xdagpool/ws"
+	"github.com/XDagger/xdagpool/xdagjtest"
+	"github.com/XDagger/xdagpool/xdago/base58"
+	"github.com/XDagger/xdagpool/xdago/cryptography"
+	"github.com/XDagger/xdagpool/xdago/secp256k1"
+)
+
+func TestMain(m *testing.M) {
+	util.InitLog(os.DevNull, os.DevNull, os.DevNull, os.DevNull, 40)
+	util.NewMinedShares()
+	os.Exit(m.Run())
+}
+
+func newTestAddress(t *testing.T) (string, *secp256k1.PrivateKey) {
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := cryptography.ToBytesAddress(key)
+	return base58.ChkEnc(b[:]), key
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(30 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+type testMiner struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// send a request and read its response, skipping pushed jobs
+func (m *testMiner) call(t *testing.T, id int, method string, params interface{}) JSONRpcResp {
+	req, _ := json.Marshal(map[string]interface{}{"id": id, "jsonrpc": "2.0", "method": method, "params": params})
+	if _, err := m.conn.Write(append(req, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_ = m.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		line, err := m.reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			JSONRpcResp
+			Result json.RawMessage `json:"result"`
+		}
+		if json.Unmarshal(line, &resp) != nil || resp.Id == nil || string(*resp.Id) != strconv.Itoa(id) {
+			continue
+		}
+		resp.JSONRpcResp.Result = resp.Result
+		return resp.JSONRpcResp
+	}
+}
+
+// task -> share -> reward -> payout against a mock xdagj node and redis
+func TestEndToEnd(t *testing.T) {
+	node := xdagjtest.NewNode()
+	defer node.Close()
+	backend, mr, err := xdagjtest.NewKvStore("xdag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	poolAddress, poolKey := newTestAddress(t)
+	minerAddress, _ := newTestAddress(t)
+	port := freePort(t)
+	cfg := &pool.Config{
+		Address:       poolAddress,
+		Coin:          "xdag",
+		Threads:       1,
+		RxMode:        "light",
+		PurgeInterval: "1h",
+		PurgeWindow:   "1h",
+		Stratum: pool.Stratum{Enabled: true, Timeout: "1m",
+			Ports: []pool.Port{{Difficulty: 1, Host: "127.0.0.1", Port: port, MaxConn: 8}}},
+		PayOut: pool.PayOutConfig{PoolRation: 10, RewardRation: 10, Mode: "equal", PaymentInterval: "100ms"},
+	}
+	payouts.Cfg = cfg
+	payouts.BipKey = poolKey
+
+	msgChan := make(chan pool.Message, 64)
+	ws.NewUpstreams([]pool.Node{{Name: "mock", Ws: node.WsURL, Rpc: node.RpcURL}}, pool.WsReconnect{}, msgChan)
+	s := NewStratum(cfg, backend, msgChan)
+	s.Listen()
+
+	if err := node.WaitConnected(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "active upstream", func() bool { return ws.ActiveName() == "mock" })
+
+	preHash := "10c19a83f28f1f39220782e45ee3e67c24ae5029cb3f941d4e53a1c24c3143e0"
+	seed := "4405f2f647119fd54273dfdac7091b2919030b33eaa7042296b3228da6c977a5"
+	taskIndex := node.SendTask(preHash, seed)
+	waitFor(t, "block template", func() bool { return s.currentBlockTemplate() != nil })
+
+	var conn net.Conn
+	waitFor(t, "stratum listening", func() bool {
+		conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		return err == nil
+	})
+	defer conn.Close()
+	miner := &testMiner{conn: conn, reader: bufio.NewReader(conn)}
+
+	resp := miner.call(t, 1, "login", LoginParams{Login: minerAddress, Pass: "w1"})
+	if resp.Error != nil {
+		t.Fatalf("login error: %v", resp.Error)
+	}
+	var login JobReply
+	if err := json.Unmarshal(resp.Result.(json.RawMessage), &login); err != nil {
+		t.Fatal(err)
+	}
+
+	// difficulty 1 accepts any hash
+	blob, _ := hex.DecodeString(login.Job.Blob)
+	seedHash, _ := hex.DecodeString(login.Job.SeedHash)
+	nonce := "0000002a"
+	nonceBytes, _ := hex.DecodeString(nonce)
+	copy(blob[60:], nonceBytes)
+	result := hex.EncodeToString(util.RxHash(seedHash, blob))
+
+	resp = miner.call(t, 2, "submit", SubmitParams{Id: "w1", JobId: login.Job.JobId, Nonce: nonce, Result: result})
+	if resp.Error != nil {
+		t.Fatalf("submit error: %v", resp.Error)
+	}
+
+	share, err := node.WaitShare(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if share.Hash != preHash || share.Index != taskIndex {
+		t.Fatalf("unexpected share %+v", share)
+	}
+
+	node.SendRewards(pool.XdagjReward{TxBlock: minerAddress, PreHash: preHash, Share: share.Share, Amount: 100 * pool.XDAG, Fee: pool.XDAG / 10})
+	account := "xdag:account:" + minerAddress
+	waitFor(t, "miner reward", func() bool { return mr.HGet(account, "reward") != "" })
+	// finder 10 + (100 - pool 10 - finder 10) * 1
+	if reward := mr.HGet(account, "reward"); reward != "90000000000" {
+		t.Fatalf("miner reward %s, want 90000000000", reward)
+	}
+	_, blocks, err := backend.GetBlocks(0, -1)
+	if err != nil || len(blocks) != 1 || blocks[0].Status != kvstore.BlockWin || blocks[0].Finder != minerAddress {
+		t.Fatalf("unexpected found blocks %+v %v", blocks, err)
+	}
+
+	node.SetBalance(poolAddress, "1000.000000000")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go payouts.PaymentTask(ctx, cfg, backend)
+	waitFor(t, "payout", func() bool { return mr.HGet(account, "unpaid") == "0" })
+	if payment := mr.HGet(account, "payment"); payment != "90000000000" {
+		t.Errorf("miner payment %s, want 90000000000", payment)
+	}
+	if txs := node.Transactions(); len(txs) != 1 {
+		t.Errorf("expect 1 transaction, got %d", len(txs))
+	}
+}
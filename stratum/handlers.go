@@ -3,11 +3,13 @@ package stratum
 import (
 	"encoding/json"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/XDagger/xdagpool/kvstore"
 	"github.com/XDagger/xdagpool/payouts"
 	"github.com/XDagger/xdagpool/pool"
 	"github.com/XDagger/xdagpool/util"
@@ -22,11 +24,18 @@ func init() {
 }
 
 func (s *StratumServer) handleLoginRPC(cs *Session, params *LoginParams) (*JobReply, *ErrorReply) {
-	address, id := extractWorkerId(params.Login, params.Pass)
+	address, id, diff := extractWorkerId(params.Login, params.Pass)
 	if !util.ValidateAddress(address) {
 		util.Error.Printf("Invalid address %s used for login by %s", address, cs.ip)
 		return nil, &ErrorReply{Code: -1, Message: "Invalid address used for login"}
 	}
+	if diff != 0 {
+		if !validateDifficulty(cs.endpoint.config, diff) {
+			util.Error.Printf("Invalid fixed difficulty %v used for login by %s", diff, cs.ip)
+			return nil, &ErrorReply{Code: -1, Message: "Invalid difficulty used for login"}
+		}
+		cs.setFixedDifficulty(diff)
+	}
 
 	t := s.currentBlockTemplate()
 	if t == nil {
@@ -92,6 +101,7 @@ func (s *StratumServer) handleSubmitRPC(cs *Session, params *SubmitParams) (*Sta
 	exist := job.submit(nonce)
 	if exist {
 		atomic.AddInt64(&miner.invalidShares, 1)
+		s.writeShareStats(cs, kvstore.ShareInvalid, job.difficulty)
 		return nil, &ErrorReply{Code: -1, Message: "Duplicate share"}
 	}
 
@@ -100,6 +110,7 @@ func (s *StratumServer) handleSubmitRPC(cs *Session, params *SubmitParams) (*Sta
 		util.Error.Printf("Stale share for job %s from %s.%s@%s", job.jobHash, cs.login, cs.id, cs.ip)
 		util.ShareLog.Printf("Stale share for job %s from %s.%s@%s", job.jobHash, cs.login, cs.id, cs.ip)
 		atomic.AddInt64(&miner.staleShares, 1)
+		s.writeShareStats(cs, kvstore.ShareStale, job.difficulty)
 		return nil, &ErrorReply{Code: -1, Message: "Block expired"}
 	}
 
@@ -133,6 +144,8 @@ func (s *StratumServer) broadcastNewJobs() {
 		n++
 		bcast <- n
 		go func(cs *Session) {
+			// idle retarget does not depend on miner keepalives, job carries new target
+			cs.varDiffIdle()
 			reply := cs.getJob(t)
 			err := cs.pushMessage("job", &reply)
 			<-bcast
@@ -172,12 +185,49 @@ func (s *StratumServer) processRewards(msg json.RawMessage) {
 
 }
 
-func extractWorkerId(loginWorkerPair, pass string) (string, string) {
+// login: address[.worker][+diff], pass: [worker][,d=diff]
+func extractWorkerId(loginWorkerPair, pass string) (string, string, int64) {
+	var diff int64
+	if n := strings.LastIndex(loginWorkerPair, "+"); n > 0 {
+		diff, _ = strconv.ParseInt(loginWorkerPair[n+1:], 10, 64)
+		if diff <= 0 {
+			diff = -1 // malformed difficulty suffix
+		}
+		loginWorkerPair = loginWorkerPair[:n]
+	}
+
+	var worker string
+	for _, field := range strings.FieldsFunc(pass, func(r rune) bool { return r == ',' || r == ';' }) {
+		field = strings.TrimSpace(field)
+		if strings.HasPrefix(field, "d=") {
+			if diff == 0 {
+				diff, _ = strconv.ParseInt(field[2:], 10, 64)
+				if diff <= 0 {
+					diff = -1
+				}
+			}
+		} else if len(field) > 0 && field != "x" && worker == "" {
+			worker = field
+		}
+	}
+
 	parts := strings.SplitN(loginWorkerPair, ".", 2)
 	if len(parts) > 1 {
-		return parts[0], parts[1]
-	} else if len(pass) > 0 && pass != "x" {
-		return loginWorkerPair, pass
+		return parts[0], parts[1], diff
+	} else if len(worker) > 0 {
+		return loginWorkerPair, worker, diff
+	}
+	return loginWorkerPair, defaultWorkerId, diff
+}
+
+// fixed difficulty is bounded by port minDiff, or by port diff when minDiff is not set
+func validateDifficulty(cfg *pool.Port, diff int64) bool {
+	minDiff := cfg.MinDiff
+	if minDiff <= 0 {
+		minDiff = cfg.Difficulty
+	}
+	if diff <= 0 || diff < minDiff {
+		return false
 	}
-	return loginWorkerPair, defaultWorkerId
+	return cfg.MaxDiff <= 0 || diff <= cfg.MaxDiff
 }
@@ -0,0 +1,49 @@
+package stratum
+
+import (
+	"testing"
+
+	"github.com/XDagger/xdagpool/pool"
+)
+
+func TestExtractWorkerId(t *testing.T) {
+	cases := []struct {
+		login, pass     string
+		address, worker string
+		diff            int64
+	}{
+		{"addr", "x", "addr", defaultWorkerId, 0},
+		{"addr.rig1", "x", "addr", "rig1", 0},
+		{"addr", "rig2", "addr", "rig2", 0},
+		{"addr.rig1+50000", "x", "addr", "rig1", 50000},
+		{"addr+50000", "", "addr", defaultWorkerId, 50000},
+		{"addr.rig1", "d=30000", "addr", "rig1", 30000},
+		{"addr", "rig3,d=30000", "addr", "rig3", 30000},
+		{"addr+40000", "d=30000", "addr", defaultWorkerId, 40000},
+		{"addr.rig1+abc", "x", "addr", "rig1", -1},
+		{"addr", "d=-5", "addr", defaultWorkerId, -1},
+	}
+	for _, c := range cases {
+		address, worker, diff := extractWorkerId(c.login, c.pass)
+		if address != c.address || worker != c.worker || diff != c.diff {
+			t.Errorf("extractWorkerId(%q, %q) = %q, %q, %v", c.login, c.pass, address, worker, diff)
+		}
+	}
+}
+
+func TestValidateDifficulty(t *testing.T) {
+	cfg := &pool.Port{Difficulty: 20000, MinDiff: 1000, MaxDiff: 100000}
+	if !validateDifficulty(cfg, 50000) || validateDifficulty(cfg, 500) ||
+		validateDifficulty(cfg, 200000) || validateDifficulty(cfg, -1) {
+		t.Error("difficulty bounds check error")
+	}
+	cfg.MaxDiff = 0
+	if !validateDifficulty(cfg, 200000) {
+		t.Error("difficulty without upper bound check error")
+	}
+	// port diff is the floor without minDiff
+	cfg.MinDiff = 0
+	if validateDifficulty(cfg, 1) || validateDifficulty(cfg, 19999) || !validateDifficulty(cfg, 20000) {
+		t.Error("difficulty without lower bound check error")
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/payouts"
 	"github.com/XDagger/xdagpool/util"
 	"github.com/XDagger/xdagpool/ws"
 	"github.com/XDagger/xdagpool/xdago/base58"
@@ -19,6 +22,7 @@ type Job struct {
 	sync.RWMutex
 	id          string
 	extraNonce  uint32
+	difficulty  int64
 	submissions map[string]struct{}
 }
 
@@ -61,17 +65,19 @@ func (cs *Session) getJob(t *BlockTemplate) *JobReplyData {
 		return &JobReplyData{}
 	}
 
+	diff, targetHex := cs.getDifficulty()
 	extraNonce := atomic.AddUint32(&cs.endpoint.extraNonce, 1) // increase extraNonce
 	blob := t.nextBlob(cs.address, extraNonce, cs.endpoint.instanceId)
 	id := atomic.AddUint64(&cs.endpoint.jobSequence, 1)
 	job := &Job{
 		id:         strconv.FormatUint(id, 10),
 		extraNonce: extraNonce,
+		difficulty: diff,
 		jobHash:    t.jobHash,
 	}
 	job.submissions = make(map[string]struct{})
 	cs.pushJob(job)
-	reply := &JobReplyData{Algo: "rx/xdag", JobId: job.id, Blob: blob, Target: cs.endpoint.targetHex,
+	reply := &JobReplyData{Algo: "rx/xdag", JobId: job.id, Blob: blob, Target: targetHex,
 		// Height:   t.height,
 		SeedHash: hex.EncodeToString(t.seedHash)}
 	return reply
@@ -136,6 +142,14 @@ func (m *Miner) hashrate(estimationWindow time.Duration) float64 {
 	return float64(totalShares) / float64(boundary)
 }
 
+// add share difficulty to persistent series of pool, miner and worker
+func (s *StratumServer) writeShareStats(cs *Session, kind string, diff int64) {
+	err := s.backend.WriteShareStats(cs.login, cs.id, kind, diff, util.MakeTimestamp()/1000)
+	if err != nil {
+		util.Error.Println("Failed to insert share stats into backend:", err)
+	}
+}
+
 func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTemplate,
 	nonce string, result string) bool {
 	// r := s.rpc()
@@ -157,12 +171,13 @@ func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTe
 	nonceBuff, _ := hex.DecodeString(nonce) // 32bits (4 bytes) share nonce sent by miner
 	copy(shareBuff[60:], nonceBuff[:4])
 
-	hashBytes := util.RxHash(shareBuff)
+	hashBytes := util.RxHash(t.seedHash, shareBuff)
 
 	if hex.EncodeToString(hashBytes) != result {
 		util.Error.Printf("Bad hash from miner %v.%v@%v", cs.login, cs.id, cs.ip)
 		util.ShareLog.Printf("Bad hash from miner %v.%v@%v", cs.login, cs.id, cs.ip)
 		atomic.AddInt64(&m.invalidShares, 1)
+		s.writeShareStats(cs, kvstore.ShareInvalid, job.difficulty)
 		return false
 	}
 
@@ -171,10 +186,11 @@ func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTe
 		util.Error.Printf("Bad hash from miner %v.%v@%v", cs.login, cs.id, cs.ip)
 		util.ShareLog.Printf("Bad hash from miner %v.%v@%v", cs.login, cs.id, cs.ip)
 		atomic.AddInt64(&m.invalidShares, 1)
+		s.writeShareStats(cs, kvstore.ShareInvalid, job.difficulty)
 		return false
 	}
 
-	block := hashDiff.Cmp(cs.endpoint.difficulty) >= 0
+	block := hashDiff.Cmp(big.NewInt(job.difficulty)) >= 0
 
 	// nonceHex := hex.EncodeToString(nonceBuff)
 	// extraHex := hex.EncodeToString(enonce)
@@ -189,8 +205,15 @@ func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTe
 
 		// block
 		if minShare {
+			jobHash, login := t.jobHash, cs.login
+			// registered as candidate only when the share reaches a node
 			err := ws.Submit(hex.EncodeToString(shareBuff[:32]),
-				hex.EncodeToString(shareBuff[32:]), t.taskIndex)
+				hex.EncodeToString(shareBuff[32:]), t.taskIndex, func() {
+					ms := util.MakeTimestamp()
+					if err := s.backend.WriteCandidate(jobHash, login, share, ms, ms/1000); err != nil {
+						util.Error.Println("Failed to insert block candidate into backend:", err)
+					}
+				})
 			if err != nil {
 				// atomic.AddInt64(&m.rejects, 1)
 				// atomic.AddInt64(&r.Rejects, 1)
@@ -216,16 +239,17 @@ func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTe
 		// atomic.AddInt64(&r.Accepts, 1)
 		// atomic.StoreInt64(&r.LastSubmissionAt, now)
 
-		exist, err := s.backend.WriteBlock(cs.login, cs.id, share, cs.endpoint.difficulty.Int64(),
+		exist, err := s.backend.WriteBlock(cs.login, cs.id, share, job.difficulty,
 			shareU64, t.timestamp, t.jobHash)
 		if exist {
 			ms := util.MakeTimestamp()
 			ts := ms / 1000
 
-			err := s.backend.WriteInvalidShare(ms, ts, cs.login, cs.id, cs.endpoint.difficulty.Int64())
+			err := s.backend.WriteInvalidShare(ms, ts, cs.login, cs.id, job.difficulty)
 			if err != nil {
 				util.Error.Println("Failed to insert invalid share data into backend:", err)
 			}
+			s.writeShareStats(cs, kvstore.ShareInvalid, job.difficulty)
 			return false
 		}
 		if err != nil {
@@ -246,7 +270,7 @@ func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTe
 		// invalid share
 		ms := util.MakeTimestamp()
 		ts := ms / 1000
-		err := s.backend.WriteRejectShare(ms, ts, cs.login, cs.id, cs.endpoint.difficulty.Int64())
+		err := s.backend.WriteRejectShare(ms, ts, cs.login, cs.id, job.difficulty)
 		if err != nil {
 			util.Error.Println("Failed to insert reject share data into backend:", err)
 			return false
@@ -254,14 +278,18 @@ func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTe
 		util.Error.Printf("Rejected low difficulty share of %v from %v.%v@%v", hashDiff, cs.login, cs.id, cs.ip)
 		util.ShareLog.Printf("Rejected low difficulty share of %v from %v.%v@%v", hashDiff, cs.login, cs.id, cs.ip)
 		atomic.AddInt64(&m.invalidShares, 1)
+		s.writeShareStats(cs, kvstore.ShareInvalid, job.difficulty)
 		return false
 	}
 
-	atomic.AddInt64(&s.roundShares, cs.endpoint.config.Difficulty)
+	payouts.CreditShare(s.config, s.backend, cs.login, job.difficulty)
+
+	atomic.AddInt64(&s.roundShares, job.difficulty)
 	atomic.AddInt64(&m.validShares, 1)
-	m.storeShare(cs.endpoint.config.Difficulty)
+	m.storeShare(job.difficulty)
+	s.writeShareStats(cs, kvstore.ShareAccepted, job.difficulty)
 
-	util.Info.Printf("Valid share of %v at difficulty %v from %v.%v@%v", hashDiff, cs.endpoint.config.Difficulty, cs.login, cs.id, cs.ip)
-	util.ShareLog.Printf("Valid share of %v at difficulty %v from %v.%v@%v", hashDiff, cs.endpoint.config.Difficulty, cs.login, cs.id, cs.ip)
+	util.Info.Printf("Valid share of %v at difficulty %v from %v.%v@%v", hashDiff, job.difficulty, cs.login, cs.id, cs.ip)
+	util.ShareLog.Printf("Valid share of %v at difficulty %v from %v.%v@%v", hashDiff, job.difficulty, cs.login, cs.id, cs.ip)
 	return true
 }
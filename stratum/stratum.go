@@ -8,8 +8,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"math/big"
 	"net"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,14 +17,15 @@ import (
 	"github.com/XDagger/xdagpool/kvstore"
 
 	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/randomx"
 	"github.com/XDagger/xdagpool/util"
 )
 
 type StratumServer struct {
-	luckWindow int64
+	luckWindow      int64
+	candidateRounds int64
 	// luckLargeWindow int64
 	roundShares   int64
-	blockStats    map[int64]blockEntry
 	config        *pool.Config
 	miners        MinersMap
 	workers       WorkersMap
@@ -35,27 +36,19 @@ type StratumServer struct {
 	purgeWindow      time.Duration
 	// purgeLargeWindow time.Duration
 
-	blocksMu   sync.RWMutex
 	sessionsMu sync.RWMutex
 	sessions   map[*Session]struct{}
 
-	backend *kvstore.KvClient
+	backend kvstore.Store
 
 	upstreamsStates []bool
 
 	maxConcurrency int
 }
 
-type blockEntry struct {
-	height   int64
-	variance float64
-	hash     string
-}
-
 type Endpoint struct {
 	jobSequence uint64
 	config      *pool.Port
-	difficulty  *big.Int
 	instanceId  []byte
 	extraNonce  uint32
 	targetHex   string
@@ -78,19 +71,35 @@ type Session struct {
 
 	endpoint  *Endpoint
 	validJobs []*Job
+
+	difficulty int64
+	targetHex  string
+	varDiff    *VarDiff
 }
 
 const (
 	MaxReqSize = 10 * 1024
+
+	defaultCandidateRounds = 32
 )
 
-func NewStratum(cfg *pool.Config, backend *kvstore.KvClient, msgChan chan pool.Message) *StratumServer {
-	stratum := &StratumServer{config: cfg, backend: backend, blockStats: make(map[int64]blockEntry),
-		maxConcurrency: cfg.Threads}
+func NewStratum(cfg *pool.Config, backend kvstore.Store, msgChan chan pool.Message) *StratumServer {
+	stratum := &StratumServer{config: cfg, backend: backend, maxConcurrency: cfg.Threads}
 
 	// stratum.upWsClient = ws.NewRpcClient(cfg.NodeWs, cfg.WsSsl)
 	// util.Info.Printf("Upstream ws: %s => %s", cfg.NodeName, cfg.NodeWs)
 
+	vms := cfg.RxVms
+	if vms <= 0 {
+		vms = cfg.Threads
+	}
+	if vms <= 0 {
+		vms = runtime.NumCPU()
+	}
+	randomx.Rx.SetVMCount(vms)
+	util.Info.Printf("RandomX verify shares with %v vms", vms)
+	randomx.Rx.SetInitThreads(cfg.RxInitThreads)
+
 	stratum.miners = NewMinersMap()
 	stratum.workers = NewWorkersMap()
 	stratum.sessions = make(map[*Session]struct{})
@@ -108,7 +117,14 @@ func NewStratum(cfg *pool.Config, backend *kvstore.KvClient, msgChan chan pool.M
 	// stratum.purgeLargeWindow = purgeLargeWindow
 
 	luckWindow, _ := time.ParseDuration(cfg.LuckWindow)
+	if luckWindow <= 0 {
+		luckWindow = 24 * time.Hour
+	}
 	stratum.luckWindow = int64(luckWindow / time.Millisecond)
+	stratum.candidateRounds = cfg.CandidateRounds
+	if stratum.candidateRounds <= 0 {
+		stratum.candidateRounds = defaultCandidateRounds
+	}
 	// luckLargeWindow, _ := time.ParseDuration(cfg.LargeLuckWindow)
 	// stratum.luckLargeWindow = int64(luckLargeWindow / time.Millisecond)
 
@@ -148,7 +164,6 @@ func NewEndpoint(cfg *pool.Port) *Endpoint {
 		util.Error.Fatalf("Can't seed with random bytes: %v", err)
 	}
 	e.targetHex = util.GetTargetHex(e.config.Difficulty) // default 000037EC8EC25E6D
-	e.difficulty = big.NewInt(e.config.Difficulty)       //default 300000
 	return e
 }
 
@@ -193,7 +208,8 @@ func (e *Endpoint) Listen(s *StratumServer) {
 		}
 		_ = conn.SetKeepAlive(true)
 		// ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		cs := &Session{conn: conn, ip: conn.RemoteAddr().String(), enc: json.NewEncoder(conn), endpoint: e, address: s.config.Address}
+		cs := &Session{conn: conn, ip: conn.RemoteAddr().String(), enc: json.NewEncoder(conn), endpoint: e, address: s.config.Address,
+			difficulty: e.config.Difficulty, targetHex: e.targetHex, varDiff: NewVarDiff(e.config)}
 		n += 1
 
 		accept <- n
@@ -248,7 +264,8 @@ func (e *Endpoint) ListenTLS(s *StratumServer, t pool.StratumTls) {
 			continue
 		}
 
-		cs := &Session{tlsConn: tlsConn, ip: ip, enc: json.NewEncoder(conn), endpoint: e, address: s.config.Address}
+		cs := &Session{tlsConn: tlsConn, ip: ip, enc: json.NewEncoder(conn), endpoint: e, address: s.config.Address,
+			difficulty: e.config.Difficulty, targetHex: e.targetHex, varDiff: NewVarDiff(e.config)}
 		n += 1
 
 		accept <- n
@@ -394,9 +411,17 @@ func (cs *Session) handleMessage(s *StratumServer, e *Endpoint, req *JSONRpcReq)
 		if errReply != nil {
 			return cs.sendError(req.Id, errReply, false)
 		}
-		return cs.sendResult(req.Id, &reply)
+		err = cs.sendResult(req.Id, &reply)
+		if err == nil && cs.varDiffShare() {
+			return s.pushDifficulty(cs)
+		}
+		return err
 	case "keepalived":
-		return cs.sendResult(req.Id, &StatusReply{Status: "KEEPALIVED"})
+		err := cs.sendResult(req.Id, &StatusReply{Status: "KEEPALIVED"})
+		if err == nil && cs.varDiffIdle() {
+			return s.pushDifficulty(cs)
+		}
+		return err
 	default:
 		errReply := s.handleUnknownRPC(req)
 		return cs.sendError(req.Id, errReply, true)
@@ -481,4 +506,12 @@ func (s *StratumServer) purgeStale() {
 	} else {
 		util.Info.Printf("Purged stale stats from backend, %v records affected, elapsed time %v", total, time.Since(start))
 	}
+
+	start = time.Now()
+	total, err = s.backend.PurgeShareStats()
+	if err != nil {
+		util.Error.Println("Failed to purge share series from backend:", err)
+	} else {
+		util.Info.Printf("Purged share series from backend, %v buckets affected, elapsed time %v", total, time.Since(start))
+	}
 }
@@ -0,0 +1,181 @@
+package stratum
+
+import (
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+const (
+	defaultTargetTime      = 15 * time.Second
+	defaultRetargetTime    = 90 * time.Second
+	defaultVariancePercent = 30.0
+)
+
+// VarDiff retargets difficulty of a session by the average time between its shares
+type VarDiff struct {
+	minDiff      int64
+	maxDiff      int64
+	targetTime   float64 // ms
+	retargetTime float64 // ms
+	tMin         float64 // ms
+	tMax         float64 // ms
+
+	lastShare    int64 // ms
+	lastRetarget int64 // ms
+	intervals    []float64
+	bufferSize   int
+}
+
+func NewVarDiff(cfg *pool.Port) *VarDiff {
+	if !cfg.VarDiff.Enabled {
+		return nil
+	}
+	targetTime, err := time.ParseDuration(cfg.VarDiff.TargetTime)
+	if err != nil || targetTime <= 0 {
+		targetTime = defaultTargetTime
+	}
+	retargetTime, err := time.ParseDuration(cfg.VarDiff.RetargetTime)
+	if err != nil || retargetTime <= 0 {
+		retargetTime = defaultRetargetTime
+	}
+	variance := cfg.VarDiff.VariancePercent
+	if variance <= 0 {
+		variance = defaultVariancePercent
+	}
+
+	v := &VarDiff{
+		minDiff:      cfg.MinDiff,
+		maxDiff:      cfg.MaxDiff,
+		targetTime:   float64(targetTime / time.Millisecond),
+		retargetTime: float64(retargetTime / time.Millisecond),
+	}
+	if v.minDiff <= 0 {
+		v.minDiff = 1
+	}
+	v.tMin = v.targetTime * (1 - variance/100)
+	v.tMax = v.targetTime * (1 + variance/100)
+	// keep enough intervals to cover several retarget periods
+	v.bufferSize = int(v.retargetTime/v.targetTime) * 4
+	if v.bufferSize < 4 {
+		v.bufferSize = 4
+	}
+	return v
+}
+
+// record a valid share at time now (ms), return new difficulty if it should change
+func (v *VarDiff) submit(now, diff int64) (int64, bool) {
+	if v.lastShare == 0 {
+		v.lastShare = now
+		v.lastRetarget = now
+		return diff, false
+	}
+	v.intervals = append(v.intervals, float64(now-v.lastShare))
+	if len(v.intervals) > v.bufferSize {
+		v.intervals = v.intervals[1:]
+	}
+	v.lastShare = now
+
+	if float64(now-v.lastRetarget) < v.retargetTime {
+		return diff, false
+	}
+	v.lastRetarget = now
+
+	var sum float64
+	for _, t := range v.intervals {
+		sum += t
+	}
+	return v.retarget(diff, sum/float64(len(v.intervals)))
+}
+
+// check a session without shares for a long time (ms), lower its difficulty
+func (v *VarDiff) idle(now, diff int64) (int64, bool) {
+	if v.lastShare == 0 {
+		v.lastShare = now
+		v.lastRetarget = now
+		return diff, false
+	}
+	since := float64(now - v.lastShare)
+	if since < v.retargetTime || float64(now-v.lastRetarget) < v.retargetTime {
+		return diff, false
+	}
+	v.lastRetarget = now
+	return v.retarget(diff, since)
+}
+
+func (v *VarDiff) retarget(diff int64, avg float64) (int64, bool) {
+	if avg <= 0 || (avg >= v.tMin && avg <= v.tMax) {
+		return diff, false
+	}
+	newDiff := int64(float64(diff) * v.targetTime / avg)
+	if newDiff < v.minDiff {
+		newDiff = v.minDiff
+	}
+	if v.maxDiff > 0 && newDiff > v.maxDiff {
+		newDiff = v.maxDiff
+	}
+	if newDiff == diff {
+		return diff, false
+	}
+	v.intervals = v.intervals[:0]
+	return newDiff, true
+}
+
+func (cs *Session) getDifficulty() (int64, string) {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.difficulty, cs.targetHex
+}
+
+// pin difficulty of a session requested by miner, disable vardiff
+func (cs *Session) setFixedDifficulty(diff int64) {
+	cs.Lock()
+	defer cs.Unlock()
+	cs.difficulty = diff
+	cs.targetHex = util.GetTargetHex(diff)
+	cs.varDiff = nil
+}
+
+// account a valid share for vardiff, return true when difficulty is retargeted
+func (cs *Session) varDiffShare() bool {
+	cs.Lock()
+	defer cs.Unlock()
+	if cs.varDiff == nil {
+		return false
+	}
+	diff, changed := cs.varDiff.submit(util.MakeTimestamp(), cs.difficulty)
+	if changed {
+		util.Info.Printf("Retarget difficulty %v -> %v for %s.%s@%s", cs.difficulty, diff, cs.login, cs.id, cs.ip)
+		cs.difficulty = diff
+		cs.targetHex = util.GetTargetHex(diff)
+	}
+	return changed
+}
+
+// lower difficulty of a session which has no shares for a long time
+func (cs *Session) varDiffIdle() bool {
+	cs.Lock()
+	defer cs.Unlock()
+	if cs.varDiff == nil {
+		return false
+	}
+	diff, changed := cs.varDiff.idle(util.MakeTimestamp(), cs.difficulty)
+	if changed {
+		util.Info.Printf("Retarget idle difficulty %v -> %v for %s.%s@%s", cs.difficulty, diff, cs.login, cs.id, cs.ip)
+		cs.difficulty = diff
+		cs.targetHex = util.GetTargetHex(diff)
+	}
+	return changed
+}
+
+// push a new job carrying the retargeted difficulty
+func (s *StratumServer) pushDifficulty(cs *Session) error {
+	t := s.currentBlockTemplate()
+	if t == nil {
+		return nil
+	}
+	cs.lastJobHash.Store("") // force a fresh job for current block template
+	reply := cs.getJob(t)
+	return cs.pushMessage("job", &reply)
+}
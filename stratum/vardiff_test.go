@@ -0,0 +1,94 @@
+package stratum
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+func TestVarDiffRetarget(t *testing.T) {
+	cfg := &pool.Port{Difficulty: 20000, MinDiff: 1000, MaxDiff: 100000,
+		VarDiff: pool.VarDiff{Enabled: true, TargetTime: "10s", RetargetTime: "60s", VariancePercent: 30}}
+	v := NewVarDiff(cfg)
+
+	// share every second, difficulty should go up
+	now := int64(1000000)
+	diff := cfg.Difficulty
+	v.submit(now, diff)
+	changed := false
+	for i := 0; i < 61; i++ {
+		now += 1000
+		diff, changed = v.submit(now, diff)
+		if changed {
+			break
+		}
+	}
+	if !changed || diff != 100000 {
+		t.Errorf("expect retarget to max diff, got %v %v", diff, changed)
+	}
+
+	// share every 40 seconds, difficulty should go down
+	changed = false
+	for i := 0; i < 4; i++ {
+		now += 40000
+		diff, changed = v.submit(now, diff)
+		if changed {
+			break
+		}
+	}
+	if !changed || diff != 25000 {
+		t.Errorf("expect retarget to 25000, got %v %v", diff, changed)
+	}
+
+	// share every 10 seconds, difficulty should stay
+	for i := 0; i < 20; i++ {
+		now += 10000
+		if _, changed = v.submit(now, diff); changed {
+			t.Errorf("unexpected retarget at target share time")
+		}
+	}
+
+	// idle session falls to min diff
+	now += 600000
+	diff, changed = v.idle(now, diff)
+	if !changed || diff != 1000 {
+		t.Errorf("expect idle retarget to min diff, got %v %v", diff, changed)
+	}
+}
+
+func TestVarDiffDisabled(t *testing.T) {
+	if NewVarDiff(&pool.Port{Difficulty: 20000}) != nil {
+		t.Error("vardiff should be nil when disabled")
+	}
+}
+
+func TestVarDiffIdleBroadcast(t *testing.T) {
+	cfg := &pool.Port{Difficulty: 20000, MinDiff: 1000,
+		VarDiff: pool.VarDiff{Enabled: true, TargetTime: "10s", RetargetTime: "60s"}}
+	address, _ := newTestAddress(t)
+	r, w := io.Pipe()
+	cs := &Session{enc: json.NewEncoder(w), address: address, varDiff: NewVarDiff(cfg),
+		endpoint: &Endpoint{config: cfg, instanceId: make([]byte, 4)},
+		difficulty: cfg.Difficulty, targetHex: util.GetTargetHex(cfg.Difficulty)}
+	// no share and no keepalive for 10 minutes
+	cs.varDiff.lastShare = util.MakeTimestamp() - 600000
+	cs.varDiff.lastRetarget = cs.varDiff.lastShare
+
+	s := &StratumServer{sessions: map[*Session]struct{}{cs: {}}}
+	s.blockTemplate.Store(&BlockTemplate{jobHash: "job", buffer: make([]byte, 32), seedHash: make([]byte, 32)})
+	s.broadcastNewJobs()
+
+	var push struct {
+		Method string       `json:"method"`
+		Params JobReplyData `json:"params"`
+	}
+	if err := json.NewDecoder(r).Decode(&push); err != nil {
+		t.Fatal(err)
+	}
+	if diff, _ := cs.getDifficulty(); diff != 1000 || push.Method != "job" || push.Params.Target != util.GetTargetHex(1000) {
+		t.Errorf("idle session not retargeted on broadcast, diff %v job %+v", diff, push)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/XDagger/xdagpool/payouts"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/XDagger/xdagpool/xdago/base58"
+	"github.com/XDagger/xdagpool/xdago/common"
+	"github.com/XDagger/xdagpool/xdago/cryptography"
+	bip "github.com/XDagger/xdagpool/xdago/wallet"
+	"golang.org/x/term"
+)
+
+var help bool
+var dryRun bool
+
+var dir string
+var walletDir string
+
+func init() {
+	flag.BoolVar(&help, "h", false, "this help")
+	flag.BoolVar(&dryRun, "n", false, "list unsigned batches without signing")
+
+	flag.StringVar(&dir, "d", "", "set signing dir of pool (payout.signingDir)")
+	flag.StringVar(&walletDir, "w", ".", "set dir containing "+common.BIP32_WALLET_FOLDER)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `offline signer of pool payout batches
+Usage: signer [-h] [-n] -d signing dir [-w wallet dir]
+Options:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Parse()
+	if help {
+		usage()
+		return
+	}
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "Must set signing dir!")
+		return
+	}
+	// errors of transaction building go to stderr
+	util.InitLog(os.DevNull, "/dev/stderr", os.DevNull, os.DevNull, 40)
+
+	ids, err := payouts.UnsignedBatches(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "List unsigned batches error: "+err.Error())
+		return
+	}
+	var batches []*payouts.SigningBatch
+	for _, id := range ids {
+		if _, err := os.Stat(payouts.SignedPath(dir, id)); err == nil {
+			continue // signed, waiting for pool to broadcast
+		}
+		b, err := payouts.ReadSigningBatch(payouts.UnsignedPath(dir, id))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Read batch error: "+err.Error())
+			return
+		}
+		var total, out pool.Amount
+		for i := range b.Amounts {
+			total += b.Amounts[i]
+			out += b.Outputs[i]
+		}
+		fmt.Printf("%s: %d outputs, paid %s, fee %s, from unpaid %s\n",
+			id, len(b.Outputs), out, b.Fee, total)
+		batches = append(batches, b)
+	}
+	if len(batches) == 0 {
+		fmt.Println("no batch to sign")
+		return
+	}
+	if dryRun {
+		return
+	}
+
+	fmt.Printf("Enter Wallet Password: \n")
+	password, err := readPassword()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Read wallet password error: "+err.Error())
+		return
+	}
+	wallet := bip.NewWallet(path.Join(walletDir, common.BIP32_WALLET_FOLDER, common.BIP32_WALLET_FILE_NAME))
+	if !wallet.UnlockWallet(string(password)) || !wallet.IsHdWalletInitialized() {
+		fmt.Fprintln(os.Stderr, "Unlock wallet error!")
+		return
+	}
+	key := wallet.GetDefKey()
+	b := cryptography.ToBytesAddress(key)
+	address := base58.ChkEnc(b[:])
+
+	for _, batch := range batches {
+		if batch.From != address {
+			fmt.Fprintln(os.Stderr, batch.ID+": pays from "+batch.From+", wallet address is "+address)
+			continue
+		}
+		if err := payouts.SignBatch(batch, key); err != nil {
+			fmt.Fprintln(os.Stderr, batch.ID+": sign error: "+err.Error())
+			continue
+		}
+		if err := payouts.WriteSigningBatch(payouts.SignedPath(dir, batch.ID), batch); err != nil {
+			fmt.Fprintln(os.Stderr, batch.ID+": write error: "+err.Error())
+			continue
+		}
+		fmt.Println(batch.ID + ": signed transaction " + batch.TxHash)
+	}
+}
+
+func readPassword() ([]byte, error) {
+	var fd int
+	if term.IsTerminal(int(syscall.Stdin)) {
+		fd = int(syscall.Stdin)
+	} else {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return nil, errors.New("error allocating terminal")
+		}
+		defer tty.Close()
+		fd = int(tty.Fd())
+	}
+	return term.ReadPassword(fd)
+}
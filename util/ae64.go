@@ -5,6 +5,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/base64"
+	"errors"
 )
 
 func padding(src []byte, blockSize int) []byte {
@@ -13,10 +14,16 @@ func padding(src []byte, blockSize int) []byte {
 	return append(src, pad...)
 }
 
-func unpadding(src []byte) []byte {
+func unpadding(src []byte) ([]byte, error) {
 	n := len(src)
+	if n == 0 {
+		return nil, errors.New("decrypt empty data")
+	}
 	unPadNum := int(src[n-1])
-	return src[:n-unPadNum]
+	if unPadNum == 0 || unPadNum > n {
+		return nil, errors.New("decrypt bad padding")
+	}
+	return src[:n-unPadNum], nil
 }
 
 func encryptAES(src []byte, key []byte) ([]byte, error) {
@@ -36,9 +43,11 @@ func decryptAES(src []byte, key []byte) ([]byte, error) {
 		return nil, err
 	}
 	blockMode := cipher.NewCBCDecrypter(block, key)
+	if len(src)%block.BlockSize() != 0 {
+		return nil, errors.New("decrypt data is not a multiple of block size")
+	}
 	blockMode.CryptBlocks(src, src)
-	src = unpadding(src)
-	return src, nil
+	return unpadding(src)
 }
 
 // MODE: CBC, Key Size: 128bits, IV and Secret Key: 16 characters long( add '*' if length not enough)
@@ -12,6 +12,7 @@ func FastHash(blob []byte) []byte {
 	return h[:]
 }
 
-func RxHash(blob []byte) []byte {
-	return randomx.Rx.CalculateHash(blob)
+// calculate rxhash with the seed of the job, nil if the seed is released
+func RxHash(seed, blob []byte) []byte {
+	return randomx.Rx.CalculateSeedHash(seed, blob)
 }
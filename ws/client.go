@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"log"
+	"math/rand"
 
 	"github.com/gorilla/websocket"
 
@@ -11,6 +12,7 @@ import (
 	"net/url"
 
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/XDagger/xdagpool/util"
@@ -21,22 +23,24 @@ type Empty struct {
 }
 
 type Socket struct {
-	Conn              *websocket.Conn
-	WebsocketDialer   *websocket.Dialer
-	Url               string
-	ConnectionOptions ConnectionOptions
-	RequestHeader     http.Header
-	OnConnected       func(socket Socket)
-	OnTextMessage     func(message string, socket Socket)
-	OnBinaryMessage   func(data []byte, socket Socket)
-	OnConnectError    func(err error, socket Socket)
-	OnDisconnected    func(err error, socket Socket)
-	OnPingReceived    func(data string, socket Socket)
-	OnPongReceived    func(data string, socket Socket)
-	IsConnected       bool
-	Timeout           time.Duration
-	sendMu            *sync.Mutex // Prevent "concurrent write to websocket connection"
-	receiveMu         *sync.Mutex
+	Conn                *websocket.Conn
+	WebsocketDialer     *websocket.Dialer
+	Url                 string
+	ConnectionOptions   ConnectionOptions
+	ReconnectionOptions ReconnectionOptions
+	RequestHeader       http.Header
+	OnConnected         func(socket *Socket)
+	OnTextMessage       func(message string, socket *Socket)
+	OnBinaryMessage     func(data []byte, socket *Socket)
+	OnConnectError      func(err error, socket *Socket)
+	OnDisconnected      func(err error, socket *Socket)
+	OnPingReceived      func(data string, socket *Socket)
+	OnPongReceived      func(data string, socket *Socket)
+	connected           atomic.Bool // written by supervisor, read by senders
+	sendMu              *sync.Mutex // Prevent "concurrent write to websocket connection"
+	receiveMu           *sync.Mutex
+	stop                chan struct{}
+	stopOnce            *sync.Once
 }
 
 type ConnectionOptions struct {
@@ -46,8 +50,25 @@ type ConnectionOptions struct {
 	Subprotocols   []string
 }
 
-// todo Yet to be done
+// reconnect with capped exponential backoff, detect dead connection by ping/pong
 type ReconnectionOptions struct {
+	MinBackoff   time.Duration // delay before first reconnection
+	MaxBackoff   time.Duration // cap of delay
+	Factor       float64       // delay multiplier of each failed attempt
+	Jitter       float64       // random part of delay, 0.2 means ±20%
+	PingInterval time.Duration // interval to ping server
+	PongTimeout  time.Duration // connection is dead without any message or pong in time
+}
+
+func DefaultReconnectionOptions() ReconnectionOptions {
+	return ReconnectionOptions{
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Minute,
+		Factor:       2,
+		Jitter:       0.2,
+		PingInterval: 30 * time.Second,
+		PongTimeout:  90 * time.Second,
+	}
 }
 
 func New(url string) *Socket {
@@ -58,10 +79,12 @@ func New(url string) *Socket {
 			UseCompression: false,
 			UseSSL:         true,
 		},
-		WebsocketDialer: &websocket.Dialer{},
-		Timeout:         0,
-		sendMu:          &sync.Mutex{},
-		receiveMu:       &sync.Mutex{},
+		ReconnectionOptions: DefaultReconnectionOptions(),
+		WebsocketDialer:     &websocket.Dialer{},
+		sendMu:              &sync.Mutex{},
+		receiveMu:           &sync.Mutex{},
+		stop:                make(chan struct{}),
+		stopOnce:            &sync.Once{},
 	}
 }
 
@@ -72,91 +95,168 @@ func (socket *Socket) setConnectionOptions() {
 	socket.WebsocketDialer.Subprotocols = socket.ConnectionOptions.Subprotocols
 }
 
+// start the goroutine owning the connection, it reconnects until Close
 func (socket *Socket) Connect() {
-	var err error
-	var resp *http.Response
 	socket.setConnectionOptions()
+	go socket.supervise()
+}
+
+func (socket *Socket) supervise() {
+	attempt := 0
+	for {
+		select {
+		case <-socket.stop:
+			return
+		default:
+		}
+
+		err := socket.dial()
+		if err == nil {
+			attempt = 0
+			err = socket.readLoop()
+			socket.connected.Store(false)
+			if socket.OnDisconnected != nil {
+				socket.OnDisconnected(err, socket)
+			}
+		} else {
+			socket.connected.Store(false)
+			if socket.OnConnectError != nil {
+				socket.OnConnectError(err, socket)
+			}
+		}
+
+		delay := socket.ReconnectionOptions.backoff(attempt)
+		attempt++
+		util.Warn.Printf("Reconnect to %s in %v", socket.Url, delay)
+		select {
+		case <-socket.stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
 
-	socket.Conn, resp, err = socket.WebsocketDialer.Dial(socket.Url, socket.RequestHeader)
+// delay before reconnection attempt, starts from 0
+func (o ReconnectionOptions) backoff(attempt int) time.Duration {
+	delay := float64(o.MinBackoff)
+	for i := 0; i < attempt && delay < float64(o.MaxBackoff); i++ {
+		delay *= o.Factor
+	}
+	if o.MaxBackoff > 0 && delay > float64(o.MaxBackoff) {
+		delay = float64(o.MaxBackoff)
+	}
+	delay += delay * o.Jitter * (2*rand.Float64() - 1)
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
 
+func (socket *Socket) dial() error {
+	conn, resp, err := socket.WebsocketDialer.Dial(socket.Url, socket.RequestHeader)
 	if err != nil {
 		util.Error.Println("Error while connecting to server ", err)
 		if resp != nil {
 			util.Error.Printf("HTTP Response %d status: %s", resp.StatusCode, resp.Status)
 		}
-		socket.IsConnected = false
-		if socket.OnConnectError != nil {
-			socket.OnConnectError(err, *socket)
-		}
-		return
+		return err
 	}
+	socket.sendMu.Lock()
+	socket.Conn = conn
+	socket.sendMu.Unlock()
 
 	util.Info.Println("Connected to server")
-
+	socket.connected.Store(true)
 	if socket.OnConnected != nil {
-		socket.IsConnected = true
-		socket.OnConnected(*socket)
+		socket.OnConnected(socket)
 	}
 
-	defaultPingHandler := socket.Conn.PingHandler()
-	socket.Conn.SetPingHandler(func(appData string) error {
+	defaultPingHandler := conn.PingHandler()
+	conn.SetPingHandler(func(appData string) error {
 		util.Info.Println("Received PING from server")
+		socket.extendDeadline(conn)
 		if socket.OnPingReceived != nil {
-			socket.OnPingReceived(appData, *socket)
+			socket.OnPingReceived(appData, socket)
 		}
 		return defaultPingHandler(appData)
 	})
 
-	defaultPongHandler := socket.Conn.PongHandler()
-	socket.Conn.SetPongHandler(func(appData string) error {
-		util.Info.Println("Received PONG from server")
+	defaultPongHandler := conn.PongHandler()
+	conn.SetPongHandler(func(appData string) error {
+		util.Debug.Println("Received PONG from server")
+		socket.extendDeadline(conn)
 		if socket.OnPongReceived != nil {
-			socket.OnPongReceived(appData, *socket)
+			socket.OnPongReceived(appData, socket)
 		}
 		return defaultPongHandler(appData)
 	})
 
-	defaultCloseHandler := socket.Conn.CloseHandler()
-	socket.Conn.SetCloseHandler(func(code int, text string) error {
+	defaultCloseHandler := conn.CloseHandler()
+	conn.SetCloseHandler(func(code int, text string) error {
 		result := defaultCloseHandler(code, text)
 		util.Warn.Println("Disconnected from server ", result)
-		if socket.OnDisconnected != nil {
-			socket.IsConnected = false
-			socket.OnDisconnected(errors.New(text), *socket)
-		}
 		return result
 	})
+	return nil
+}
 
-	go func() {
-		for {
-			socket.receiveMu.Lock()
-			if socket.Timeout != 0 {
-				socket.Conn.SetReadDeadline(time.Now().Add(socket.Timeout))
-			}
-			messageType, message, err := socket.Conn.ReadMessage()
-			socket.receiveMu.Unlock()
-			if err != nil {
-				util.Error.Println("read:", err)
-				if socket.OnDisconnected != nil {
-					socket.IsConnected = false
-					socket.OnDisconnected(err, *socket)
+func (socket *Socket) extendDeadline(conn *websocket.Conn) {
+	if socket.ReconnectionOptions.PongTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(socket.ReconnectionOptions.PongTimeout))
+	}
+}
+
+// read messages until connection fails, ping server meanwhile
+func (socket *Socket) readLoop() error {
+	conn := socket.Conn
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+
+	if socket.ReconnectionOptions.PingInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(socket.ReconnectionOptions.PingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := socket.send(websocket.PingMessage, nil); err != nil {
+						util.Error.Println("ping:", err)
+						return
+					}
 				}
-				return
 			}
-			util.Info.Printf("recv: %s", message)
+		}()
+	}
 
-			switch messageType {
-			case websocket.TextMessage:
-				if socket.OnTextMessage != nil {
-					socket.OnTextMessage(string(message), *socket)
-				}
-			case websocket.BinaryMessage:
-				if socket.OnBinaryMessage != nil {
-					socket.OnBinaryMessage(message, *socket)
-				}
+	for {
+		socket.receiveMu.Lock()
+		socket.extendDeadline(conn)
+		messageType, message, err := conn.ReadMessage()
+		socket.receiveMu.Unlock()
+		if err != nil {
+			util.Error.Println("read:", err)
+			return err
+		}
+		util.Info.Printf("recv: %s", message)
+
+		switch messageType {
+		case websocket.TextMessage:
+			if socket.OnTextMessage != nil {
+				socket.OnTextMessage(string(message), socket)
+			}
+		case websocket.BinaryMessage:
+			if socket.OnBinaryMessage != nil {
+				socket.OnBinaryMessage(message, socket)
 			}
 		}
-	}()
+	}
+}
+
+func (socket *Socket) IsConnected() bool {
+	return socket.connected.Load()
 }
 
 func (socket *Socket) SendText(message string) {
@@ -177,21 +277,27 @@ func (socket *Socket) SendBinary(data []byte) {
 
 func (socket *Socket) send(messageType int, data []byte) error {
 	socket.sendMu.Lock()
-	err := socket.Conn.WriteMessage(messageType, data)
-	socket.sendMu.Unlock()
-	return err
+	defer socket.sendMu.Unlock()
+	if socket.Conn == nil {
+		return errors.New("ws not connected")
+	}
+	return socket.Conn.WriteMessage(messageType, data)
 }
 
+// close connection and stop reconnecting, OnDisconnected is called by the supervisor
 func (socket *Socket) Close() {
+	socket.stopOnce.Do(func() {
+		close(socket.stop)
+	})
 	err := socket.send(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 	if err != nil {
 		util.Error.Println("write close:", err)
 	}
-	socket.Conn.Close()
-	if socket.OnDisconnected != nil {
-		socket.IsConnected = false
-		socket.OnDisconnected(err, *socket)
+	socket.sendMu.Lock()
+	if socket.Conn != nil {
+		socket.Conn.Close()
 	}
+	socket.sendMu.Unlock()
 }
 
 func BuildProxy(Url string) func(*http.Request) (*url.URL, error) {
@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBackoff(t *testing.T) {
+	o := ReconnectionOptions{MinBackoff: time.Second, MaxBackoff: 10 * time.Second, Factor: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if d := o.backoff(i); d != w {
+			t.Errorf("backoff(%d) = %v, want %v", i, d, w)
+		}
+	}
+
+	o.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		d := o.backoff(3)
+		if d < 6400*time.Millisecond || d > 9600*time.Millisecond {
+			t.Fatalf("backoff with jitter out of range: %v", d)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var accepted int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&accepted, 1)
+		conn.Close() // drop every connection
+	}))
+	defer srv.Close()
+
+	var disconnects, stale int32
+	socket := New("ws" + strings.TrimPrefix(srv.URL, "http"))
+	socket.ReconnectionOptions = ReconnectionOptions{MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond, Factor: 2, PingInterval: time.Second, PongTimeout: 3 * time.Second}
+	socket.OnDisconnected = func(err error, s *Socket) {
+		atomic.AddInt32(&disconnects, 1)
+		if s != socket || s.IsConnected() {
+			atomic.AddInt32(&stale, 1)
+		}
+	}
+	socket.Connect()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&accepted) < 3 && time.Now().Before(deadline) {
+		socket.IsConnected() // read while supervisor reconnects
+		time.Sleep(10 * time.Millisecond)
+	}
+	socket.Close()
+	if atomic.LoadInt32(&accepted) < 3 {
+		t.Fatalf("socket should reconnect, accepted %d", accepted)
+	}
+	if atomic.LoadInt32(&disconnects) < 2 {
+		t.Errorf("disconnects not reported: %d", disconnects)
+	}
+	if atomic.LoadInt32(&stale) > 0 {
+		t.Errorf("disconnected callback got a stale socket %d times", stale)
+	}
+}
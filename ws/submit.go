@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	submitQueueSize     = 256
+	submitRetryInterval = time.Second
+	submitHistorySize   = 64
+)
+
+const (
+	SubmitPending = "pending"
+	SubmitSent    = "sent"
+	SubmitDropped = "dropped"
+)
+
+// Submission is a block candidate share sent to xdagj
+type Submission struct {
+	Hash      string `json:"hash"`
+	Share     string `json:"share"`
+	TaskIndex int    `json:"taskIndex"`
+	Node      string `json:"node"`
+	Attempts  int    `json:"attempts"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+	DoneAt    int64  `json:"doneAt"`
+
+	onSent func() // called once the share is written to a node
+}
+
+type submitShare struct {
+	Share string `json:"share"`
+	Hash  string `json:"hash"`
+	Index int    `json:"taskIndex"`
+}
+
+var (
+	submitQueue       = make(chan *Submission, submitQueueSize)
+	taskIndex   int64 = -1 // index of latest task of active node
+
+	submitMu      sync.Mutex
+	submitHistory []Submission // results of recent submissions
+
+	submitSent    int64
+	submitRetries int64
+	submitDropped int64
+)
+
+// queue share of a block, it is sent to active node and retried
+// while reconnecting as long as its task is current. onSent is called
+// from the submitter after the share is sent, never for a dropped one
+func Submit(jobHash, share string, taskIndex int, onSent func()) error {
+	s := &Submission{
+		Hash:      jobHash,
+		Share:     share,
+		TaskIndex: taskIndex,
+		Status:    SubmitPending,
+		CreatedAt: util.MakeTimestamp(),
+		onSent:    onSent,
+	}
+	select {
+	case submitQueue <- s:
+		return nil
+	default:
+		dropSubmission(s, "submit queue is full")
+		return errors.New("submit queue is full")
+	}
+}
+
+func runSubmitter() {
+	for s := range submitQueue {
+		for !trySubmit(s) {
+			atomic.AddInt64(&submitRetries, 1)
+			time.Sleep(submitRetryInterval)
+		}
+	}
+}
+
+// send a submission once, return false if it should be retried
+func trySubmit(s *Submission) bool {
+	if int(atomic.LoadInt64(&taskIndex)) != s.TaskIndex {
+		dropSubmission(s, "task expired")
+		return true
+	}
+	u := Active()
+	if u == nil || !u.IsConnected() {
+		return false
+	}
+	s.Attempts++
+	s.Node = u.Name
+	if err := u.send(s); err != nil {
+		atomic.AddInt64(&u.errors, 1)
+		s.Error = err.Error()
+		util.Error.Printf("Submit block at hash %s to %s failed: %v", s.Hash, u.Name, err)
+		return false
+	}
+	// xdagj does not reply to shares, a completed write is the acknowledgement
+	atomic.AddInt64(&u.submits, 1)
+	atomic.AddInt64(&submitSent, 1)
+	s.Status = SubmitSent
+	s.Error = ""
+	s.DoneAt = util.MakeTimestamp()
+	recordSubmission(s)
+	util.BlockLog.Printf("Block submitted at hash %s task %d to %s, attempts %d", s.Hash, s.TaskIndex, u.Name, s.Attempts)
+	if s.onSent != nil {
+		s.onSent()
+	}
+	return true
+}
+
+func (u *Upstream) send(s *Submission) error {
+	data, _ := json.Marshal(submitShare{
+		Share: s.Share,
+		Hash:  s.Hash,
+		Index: s.TaskIndex,
+	})
+	wsData, _ := json.Marshal(pool.Message{
+		MsgType:    2,
+		MsgContent: data,
+	})
+	return u.socket.send(websocket.TextMessage, wsData)
+}
+
+func dropSubmission(s *Submission, reason string) {
+	atomic.AddInt64(&submitDropped, 1)
+	s.Status = SubmitDropped
+	s.Error = reason
+	s.DoneAt = util.MakeTimestamp()
+	recordSubmission(s)
+	util.Error.Printf("Block dropped at hash %s task %d: %s", s.Hash, s.TaskIndex, reason)
+	util.BlockLog.Printf("Block dropped at hash %s task %d: %s", s.Hash, s.TaskIndex, reason)
+}
+
+func recordSubmission(s *Submission) {
+	submitMu.Lock()
+	defer submitMu.Unlock()
+	submitHistory = append(submitHistory, *s)
+	if len(submitHistory) > submitHistorySize {
+		submitHistory = submitHistory[len(submitHistory)-submitHistorySize:]
+	}
+}
+
+// remember index of the task forwarded to stratum
+func setTaskIndex(msg pool.Message) {
+	var task pool.XdagjTask
+	if err := json.Unmarshal(msg.MsgContent, &task); err != nil {
+		return
+	}
+	atomic.StoreInt64(&taskIndex, int64(task.Index))
+}
+
+func SubmitStats() map[string]interface{} {
+	submitMu.Lock()
+	recent := make([]Submission, len(submitHistory))
+	copy(recent, submitHistory)
+	submitMu.Unlock()
+	return map[string]interface{}{
+		"queued":  len(submitQueue),
+		"sent":    atomic.LoadInt64(&submitSent),
+		"retries": atomic.LoadInt64(&submitRetries),
+		"dropped": atomic.LoadInt64(&submitDropped),
+		"recent":  recent,
+	}
+}
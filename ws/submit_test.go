@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/XDagger/xdagpool/pool"
+)
+
+func TestSubmitRetry(t *testing.T) {
+	msgOut = make(chan pool.Message, 8)
+	upstreams = []*Upstream{{Name: "a"}}
+	active = -1
+
+	content, _ := json.Marshal(pool.XdagjTask{Index: 7})
+	upstreams[0].setConnected(true)
+	upstreams[0].onMessage(pool.Message{MsgType: 1, MsgContent: content})
+	upstreams[0].setConnected(false)
+
+	sent := false
+	s := &Submission{Hash: "aa", Share: "bb", TaskIndex: 7, Status: SubmitPending, onSent: func() { sent = true }}
+	// node is down, submission is kept for retry
+	if trySubmit(s) {
+		t.Fatal("submission should be retried while disconnected")
+	}
+	if s.Status != SubmitPending {
+		t.Errorf("submission status should be pending, got %s", s.Status)
+	}
+
+	// new task arrives, submission is dropped
+	content, _ = json.Marshal(pool.XdagjTask{Index: 8})
+	upstreams[0].setConnected(true)
+	upstreams[0].onMessage(pool.Message{MsgType: 1, MsgContent: content})
+	if !trySubmit(s) {
+		t.Fatal("submission of expired task should not be retried")
+	}
+	if s.Status != SubmitDropped {
+		t.Errorf("submission status should be dropped, got %s", s.Status)
+	}
+	if sent {
+		t.Error("dropped submission should not be reported as sent")
+	}
+	stats := SubmitStats()
+	if stats["dropped"].(int64) != 1 || len(stats["recent"].([]Submission)) != 1 {
+		t.Errorf("drop not recorded: %v", stats)
+	}
+}
@@ -0,0 +1,211 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	// xdagj sends a task every 64 seconds, a node without tasks for longer is sick
+	taskTimeout = 3 * time.Minute
+)
+
+// Upstream is a xdagj node the pool connects to
+type Upstream struct {
+	Name string
+	Ws   string
+	Rpc  string
+
+	socket *Socket
+
+	connected   int32
+	connectedAt int64 // ms
+	lastTaskAt  int64 // ms
+	submits     int64
+	errors      int64
+
+	sync.Mutex
+	lastTask *pool.Message // latest task, forwarded when node becomes active
+}
+
+var (
+	upstreams []*Upstream
+	active    int32 = -1
+	msgOut    chan pool.Message
+	routeMu   sync.Mutex // selection of active node and forwarding of its task happen in order
+)
+
+// connect all configured nodes, tasks of the active one are sent to msgChan
+func NewUpstreams(nodes []pool.Node, reconnect pool.WsReconnect, msgChan chan pool.Message) {
+	msgOut = msgChan
+	upstreams = make([]*Upstream, len(nodes))
+	for i, n := range nodes {
+		upstreams[i] = &Upstream{Name: n.Name, Ws: n.Ws, Rpc: n.Rpc}
+		util.Info.Printf("Upstream %d: %s => %s, %s", i, n.Name, n.Ws, n.Rpc)
+	}
+	opts := NewReconnectionOptions(reconnect)
+	for i, n := range nodes {
+		upstreams[i].socket = NewClient(upstreams[i], n.Ssl, opts)
+	}
+	go runSubmitter()
+	go func() {
+		timer := time.NewTicker(healthCheckInterval)
+		for range timer.C {
+			checkUpstreams()
+		}
+	}()
+}
+
+// active upstream node, nil if no node is configured
+func Active() *Upstream {
+	i := atomic.LoadInt32(&active)
+	if i < 0 || int(i) >= len(upstreams) {
+		return nil
+	}
+	return upstreams[i]
+}
+
+// rpc url of active node, empty if no node is active
+func ActiveRpc() string {
+	if u := Active(); u != nil {
+		return u.Rpc
+	}
+	return ""
+}
+
+func ActiveName() string {
+	if u := Active(); u != nil {
+		return u.Name
+	}
+	return ""
+}
+
+func (u *Upstream) IsConnected() bool {
+	return atomic.LoadInt32(&u.connected) == 1
+}
+
+// connected node which sends tasks in time
+func (u *Upstream) Healthy(now int64) bool {
+	if !u.IsConnected() {
+		return false
+	}
+	last := atomic.LoadInt64(&u.lastTaskAt)
+	if last < atomic.LoadInt64(&u.connectedAt) {
+		// no task since connected, give the node some time
+		last = atomic.LoadInt64(&u.connectedAt)
+	}
+	return now-last < int64(taskTimeout/time.Millisecond)
+}
+
+func (u *Upstream) setConnected(connected bool) {
+	if connected {
+		atomic.StoreInt64(&u.connectedAt, util.MakeTimestamp())
+		atomic.StoreInt32(&u.connected, 1)
+	} else {
+		atomic.StoreInt32(&u.connected, 0)
+	}
+	checkUpstreams()
+}
+
+// route a message received from node u
+func (u *Upstream) onMessage(msg pool.Message) {
+	// rewards of blocks are accepted from every node, a share submitted through a node
+	// may be rewarded after failover. every node sends the same rewards, duplicates are
+	// dropped by payouts.ProcessReward
+	if msg.MsgType != 1 {
+		msgOut <- msg
+		return
+	}
+	atomic.StoreInt64(&u.lastTaskAt, util.MakeTimestamp())
+	routeMu.Lock()
+	u.Lock()
+	u.lastTask = &msg
+	u.Unlock()
+	current := Active()
+	if current == u {
+		forwardTask(msg)
+	}
+	routeMu.Unlock()
+	if current == nil {
+		checkUpstreams()
+	}
+}
+
+// select the first healthy node in configured order,
+// fail over when active node is sick and fail back when a prior node recovers
+func checkUpstreams() {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	now := util.MakeTimestamp()
+	selected := int32(-1)
+	for i, u := range upstreams {
+		if u.Healthy(now) {
+			selected = int32(i)
+			break
+		}
+	}
+	if selected < 0 {
+		return // keep current node until another one is usable
+	}
+	prev := atomic.SwapInt32(&active, selected)
+	if prev == selected {
+		return
+	}
+	u := upstreams[selected]
+	if prev >= 0 {
+		util.Warn.Printf("Switch upstream from %s to %s", upstreams[prev].Name, u.Name)
+	} else {
+		util.Info.Printf("Active upstream %s", u.Name)
+	}
+	if atomic.LoadInt64(&u.lastTaskAt) < atomic.LoadInt64(&u.connectedAt) {
+		return // task before reconnection is stale
+	}
+	u.Lock()
+	task := u.lastTask
+	u.Unlock()
+	if task != nil {
+		forwardTask(*task)
+	}
+}
+
+func forwardTask(msg pool.Message) {
+	setTaskIndex(msg)
+	msgOut <- msg
+}
+
+func UpstreamsStats() []map[string]interface{} {
+	now := util.MakeTimestamp()
+	current := Active()
+	stats := make([]map[string]interface{}, 0, len(upstreams))
+	for _, u := range upstreams {
+		stats = append(stats, map[string]interface{}{
+			"name":       u.Name,
+			"url":        u.Ws,
+			"rpc":        u.Rpc,
+			"active":     u == current,
+			"connected":  u.IsConnected(),
+			"healthy":    u.Healthy(now),
+			"lastTaskAt": atomic.LoadInt64(&u.lastTaskAt),
+			"submits":    atomic.LoadInt64(&u.submits),
+			"errors":     atomic.LoadInt64(&u.errors),
+		})
+	}
+	return stats
+}
+
+func decodeMessage(u *Upstream, data []byte) {
+	var msg pool.Message
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		atomic.AddInt64(&u.errors, 1)
+		util.Error.Println("unmarshal message error", err)
+		return
+	}
+	u.onMessage(msg)
+}
@@ -0,0 +1,95 @@
+package ws
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/util"
+)
+
+func TestMain(m *testing.M) {
+	util.InitLog(os.DevNull, os.DevNull, os.DevNull, os.DevNull, 40)
+	os.Exit(m.Run())
+}
+
+func TestUpstreamFailover(t *testing.T) {
+	msgOut = make(chan pool.Message, 8)
+	upstreams = []*Upstream{{Name: "a"}, {Name: "b"}}
+	active = -1
+
+	upstreams[1].setConnected(true)
+	if ActiveName() != "b" {
+		t.Fatalf("active upstream should be b, got %q", ActiveName())
+	}
+
+	// task of inactive node is not forwarded
+	upstreams[0].setConnected(true)
+	if ActiveName() != "a" {
+		t.Fatalf("should fail back to a, got %q", ActiveName())
+	}
+	upstreams[1].onMessage(pool.Message{MsgType: 1})
+	if len(msgOut) != 0 {
+		t.Errorf("task of inactive node forwarded")
+	}
+	// rewards are accepted from every node
+	upstreams[1].onMessage(pool.Message{MsgType: 3})
+	if len(msgOut) != 1 {
+		t.Errorf("rewards of inactive node dropped")
+	}
+	<-msgOut
+
+	// failover forwards latest task of new active node
+	upstreams[0].setConnected(false)
+	if ActiveName() != "b" {
+		t.Fatalf("should fail over to b, got %q", ActiveName())
+	}
+	if len(msgOut) != 1 {
+		t.Errorf("latest task of new active node not forwarded")
+	}
+
+	// keep active node when no node is healthy
+	upstreams[1].setConnected(false)
+	if ActiveName() != "b" {
+		t.Errorf("active node should be kept, got %q", ActiveName())
+	}
+}
+
+func TestUpstreamTaskOrder(t *testing.T) {
+	msgOut = make(chan pool.Message, 8)
+	b := &Upstream{Name: "b", connected: 1, connectedAt: 1}
+	upstreams = []*Upstream{{Name: "a"}, b}
+
+	task := func(index int) pool.Message {
+		content, _ := json.Marshal(pool.XdagjTask{Index: index})
+		return pool.Message{MsgType: 1, MsgContent: content}
+	}
+	for i := 1; i <= 200; i++ {
+		atomic.StoreInt32(&active, -1)
+		atomic.StoreInt64(&b.lastTaskAt, util.MakeTimestamp())
+		older := task(2*i - 1)
+		b.lastTask = &older
+
+		// b becomes active while it receives a newer task
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			checkUpstreams()
+		}()
+		go func() {
+			defer wg.Done()
+			b.onMessage(task(2 * i))
+		}()
+		wg.Wait()
+		for len(msgOut) > 0 {
+			<-msgOut
+		}
+		if index := atomic.LoadInt64(&taskIndex); index != int64(2*i) {
+			t.Fatalf("older task forwarded last, task index %d, want %d", index, 2*i)
+		}
+	}
+}
@@ -12,20 +12,20 @@ $(function () {
 	window.intlData = { locales: userLang };
 	var statsSource = $("#stats-template").html();
 	var statsTemplate = Handlebars.compile(statsSource);
-	// var blocksSource = $("#blocks-template").html();
-	// var blocksTemplate = Handlebars.compile(blocksSource);
-	refreshStats(statsTemplate);//, blocksTemplate);
+	var blocksSource = $("#blocks-template").html();
+	var blocksTemplate = Handlebars.compile(blocksSource);
+	refreshStats(statsTemplate, blocksTemplate);
 
 	$('#homeTab').on('click', function () {
 		window.homeTab = true;
 		refreshStats(statsTemplate, blocksTemplate);
 	});
-	// $('#blocksTab').on('click', function () {
-	// 	window.homeTab = false;
-	// 	refreshStats(statsTemplate, blocksTemplate);
-	// });
+	$('#blocksTab').on('click', function () {
+		window.homeTab = false;
+		refreshStats(statsTemplate, blocksTemplate);
+	});
 	setInterval(function () {
-		refreshStats(statsTemplate);//, blocksTemplate);
+		refreshStats(statsTemplate, blocksTemplate);
 	}, 15000)
 });
 
@@ -37,21 +37,21 @@ function refreshStats(statsTemplate, blocksTemplate) {
 		if (stats.miners) {
 			stats.miners = stats.miners.sort(compareMiners);
 		}
-		// Reverse sort blocks by height
-		// if (stats.blocks) {
-		// 	stats.blocks = stats.blocks.sort(compareBlocks);
-		// }
+		// Reverse sort blocks by found time
+		if (stats.blocks) {
+			stats.blocks = stats.blocks.sort(compareBlocks);
+		}
 
 		var html = null;
 		$('.nav-pills > li').removeClass('active');
 
-		// if (window.homeTab) {
-		html = statsTemplate(stats, { data: { intl: window.intlData } });
-		$('.nav-pills > li > #homeTab').parent().addClass('active');
-		// } else {
-		// 	html = blocksTemplate(stats, { data: { intl: window.intlData } });
-		// 	$('.nav-pills > li > #blocksTab').parent().addClass('active');
-		// }
+		if (window.homeTab) {
+			html = statsTemplate(stats, { data: { intl: window.intlData } });
+			$('.nav-pills > li > #homeTab').parent().addClass('active');
+		} else {
+			html = blocksTemplate(stats, { data: { intl: window.intlData } });
+			$('.nav-pills > li > #blocksTab').parent().addClass('active');
+		}
 		$('#stats').html(html);
 	}).fail(function () {
 		$("#alert").removeClass('hide');
@@ -67,9 +67,9 @@ function compareMiners(a, b) {
 }
 
 function compareBlocks(a, b) {
-	if (a.height > b.height)
+	if (a.foundAt > b.foundAt)
 		return -1;
-	if (a.height < b.height)
+	if (a.foundAt < b.foundAt)
 		return 1;
 	return 0;
 }
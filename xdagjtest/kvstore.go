@@ -0,0 +1,18 @@
+package xdagjtest
+
+import (
+	"github.com/XDagger/xdagpool/kvstore"
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/alicebob/miniredis/v2"
+)
+
+// start an in-process redis stand-in and a kv store client of coin on it,
+// close the returned server when done
+func NewKvStore(coin string) (*kvstore.KvClient, *miniredis.Miniredis, error) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		return nil, nil, err
+	}
+	backend := kvstore.NewKvClient(&pool.StorageConfig{Endpoint: mr.Addr(), PoolSize: 4}, coin)
+	return backend, mr, nil
+}
@@ -0,0 +1,296 @@
+// Package xdagjtest provides an in-process xdagj node for tests.
+// It implements the node side of the pool websocket protocol (ws.txt)
+// and the JSON-RPC methods used by payouts.
+package xdagjtest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XDagger/xdagpool/pool"
+	"github.com/XDagger/xdagpool/xdago/cryptography"
+	xdagoUtils "github.com/XDagger/xdagpool/xdago/utils"
+	"github.com/gorilla/websocket"
+)
+
+// Share is a msgType 2 message sent by the pool
+type Share struct {
+	Share string `json:"share"`
+	Hash  string `json:"hash"`
+	Index int    `json:"taskIndex"`
+}
+
+// RpcHandler answers a JSON-RPC method, a non-nil error is returned as rpc error
+type RpcHandler func(params []string) (interface{}, error)
+
+type Node struct {
+	WsURL  string
+	RpcURL string
+
+	wsServer  *httptest.Server
+	rpcServer *httptest.Server
+	upgrader  websocket.Upgrader
+
+	mu        sync.Mutex
+	conns     map[*websocket.Conn]*sync.Mutex
+	taskIndex int
+	txs       []string
+	txStates  map[string]string
+	balances  map[string]string
+	handlers  map[string]RpcHandler
+
+	connected chan struct{}
+	shares    chan Share
+}
+
+func NewNode() *Node {
+	n := &Node{
+		conns:     make(map[*websocket.Conn]*sync.Mutex),
+		balances:  make(map[string]string),
+		txStates:  make(map[string]string),
+		handlers:  make(map[string]RpcHandler),
+		connected: make(chan struct{}, 16),
+		shares:    make(chan Share, 256),
+	}
+	n.wsServer = httptest.NewServer(http.HandlerFunc(n.serveWs))
+	n.rpcServer = httptest.NewServer(http.HandlerFunc(n.serveRpc))
+	n.WsURL = "ws" + strings.TrimPrefix(n.wsServer.URL, "http")
+	n.RpcURL = n.rpcServer.URL
+	return n
+}
+
+func (n *Node) Close() {
+	n.Disconnect()
+	n.wsServer.Close()
+	n.rpcServer.Close()
+}
+
+// close all pool connections, pools may reconnect
+func (n *Node) Disconnect() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for c := range n.conns {
+		c.Close()
+		delete(n.conns, c)
+	}
+}
+
+// wait for a pool to connect
+func (n *Node) WaitConnected(timeout time.Duration) error {
+	select {
+	case <-n.connected:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("no pool connected")
+	}
+}
+
+// send a msgType 1 task to all pools, return its task index
+func (n *Node) SendTask(preHash, taskSeed string) int {
+	n.mu.Lock()
+	n.taskIndex++
+	index := n.taskIndex
+	n.mu.Unlock()
+
+	task := pool.XdagjTask{
+		Data:      pool.Task{PreHash: preHash, TashSeed: taskSeed},
+		Timestamp: uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		Index:     index,
+	}
+	content, _ := json.Marshal(task)
+	n.broadcast(pool.Message{MsgType: 1, MsgContent: content})
+	return index
+}
+
+// send msgType 3 rewards to all pools
+func (n *Node) SendRewards(rewards ...pool.XdagjReward) {
+	content, _ := json.Marshal(rewards)
+	n.broadcast(pool.Message{MsgType: 3, MsgContent: content})
+}
+
+// wait for a share submitted by a pool
+func (n *Node) WaitShare(timeout time.Duration) (Share, error) {
+	select {
+	case s := <-n.shares:
+		return s, nil
+	case <-time.After(timeout):
+		return Share{}, errors.New("no share submitted")
+	}
+}
+
+// balance returned by xdag_getBalance
+func (n *Node) SetBalance(address, balance string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.balances[address] = balance
+}
+
+// raw transactions received by xdag_sendRawTransaction
+func (n *Node) Transactions() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	txs := make([]string, len(n.txs))
+	copy(txs, n.txs)
+	return txs
+}
+
+// state of a transaction block returned by xdag_getBlockByHash, empty state forgets the block
+func (n *Node) SetTxState(hash, state string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if state == "" {
+		delete(n.txStates, hash)
+		return
+	}
+	n.txStates[hash] = state
+}
+
+// override or add a JSON-RPC method
+func (n *Node) HandleRpc(method string, h RpcHandler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[method] = h
+}
+
+// hash of a raw transaction block as returned by xdagj
+func TxHash(block string) string {
+	b, _ := hex.DecodeString(block)
+	hash := cryptography.HashTwice(b)
+	return xdagoUtils.Hash2Address(hash)
+}
+
+func (n *Node) broadcast(msg pool.Message) {
+	data, _ := json.Marshal(msg)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for c, mu := range n.conns {
+		mu.Lock()
+		_ = c.WriteMessage(websocket.TextMessage, data)
+		mu.Unlock()
+	}
+}
+
+func (n *Node) serveWs(w http.ResponseWriter, r *http.Request) {
+	c, err := n.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	n.conns[c] = &sync.Mutex{}
+	n.mu.Unlock()
+	select {
+	case n.connected <- struct{}{}:
+	default:
+	}
+
+	for {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			break
+		}
+		var msg pool.Message
+		if json.Unmarshal(data, &msg) != nil || msg.MsgType != 2 {
+			continue
+		}
+		var s Share
+		if json.Unmarshal(msg.MsgContent, &s) == nil {
+			n.shares <- s
+		}
+	}
+
+	n.mu.Lock()
+	delete(n.conns, c)
+	n.mu.Unlock()
+	c.Close()
+}
+
+type rpcRequest struct {
+	Id     interface{} `json:"id"`
+	Method string      `json:"method"`
+	Params []string    `json:"params"`
+}
+
+func (n *Node) serveRpc(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeRpc(w, nil, nil, err)
+		return
+	}
+
+	n.mu.Lock()
+	h, ok := n.handlers[req.Method]
+	n.mu.Unlock()
+	if !ok {
+		switch req.Method {
+		case "xdag_sendRawTransaction":
+			h = n.sendRawTransaction
+		case "xdag_getBalance":
+			h = n.getBalance
+		case "xdag_getBlockByHash":
+			h = n.getBlockByHash
+		default:
+			writeRpc(w, req.Id, nil, errors.New("method not found"))
+			return
+		}
+	}
+	result, err := h(req.Params)
+	writeRpc(w, req.Id, result, err)
+}
+
+func (n *Node) sendRawTransaction(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params")
+	}
+	hash := TxHash(params[0])
+	n.mu.Lock()
+	n.txs = append(n.txs, params[0])
+	if _, ok := n.txStates[hash]; !ok {
+		n.txStates[hash] = "Pending"
+	}
+	n.mu.Unlock()
+	return hash, nil
+}
+
+func (n *Node) getBlockByHash(params []string) (interface{}, error) {
+	if len(params) < 1 {
+		return nil, errors.New("invalid params")
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	state, ok := n.txStates[params[0]]
+	if !ok {
+		return nil, nil
+	}
+	return map[string]string{"hash": params[0], "state": state}, nil
+}
+
+func (n *Node) getBalance(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("invalid params")
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if b, ok := n.balances[params[0]]; ok {
+		return b, nil
+	}
+	return "0.000000000", nil
+}
+
+func writeRpc(w http.ResponseWriter, id, result interface{}, err error) {
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	if err != nil {
+		resp["error"] = map[string]interface{}{"code": -32000, "message": err.Error()}
+	} else {
+		resp["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
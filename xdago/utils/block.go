@@ -91,6 +91,13 @@ func Xdag2Amount(value float64) uint64 {
 	return res + amount
 }
 
+// block amount of nano xdag, the fraction is rounded up like Xdag2Amount
+func Nano2Amount(nano uint64) uint64 {
+	res := (nano / 1e9) << 32
+	frac := nano % 1e9
+	return res + (frac<<32+1e9-1)/1e9
+}
+
 //// RawBlock contains raw XDAG block bytes
 //type RawBlock struct {
 //	Hash      [32]byte
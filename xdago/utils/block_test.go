@@ -35,3 +35,12 @@ func TestAddress(t *testing.T) {
 	fmt.Println(addrBytes)
 
 }
+
+func TestNano2Amount(t *testing.T) {
+	for _, nano := range []uint64{0, 1, 500000000, 1000000000, 64000000000, 123456789012} {
+		want := Xdag2Amount(float64(nano) / 1e9)
+		if got := Nano2Amount(nano); got != want {
+			t.Errorf("Nano2Amount(%d) = %d, want %d", nano, got, want)
+		}
+	}
+}